@@ -64,6 +64,11 @@ type Token struct {
 	URL           string `json:"url"`
 	CallID        string `json:"callID"`
 	NoPublish     bool   `json:"noPublish"`
+	NotBefore     int64  `json:"nbf"`
+	ExpiresAt     int64  `json:"exp"`
+	Nonce         string `json:"jti"`
+	// Node contract address of the node the token is issued for
+	Node string `json:"node"`
 }
 
 // TokenView model
@@ -83,6 +88,9 @@ type NotifyData struct {
 	CallID   string `json:"callID"`
 	UID      string `json:"uid"`
 	Type     string `json:"type"`
+	// Host of the node a participant migrates to
+	Host      string `json:"host,omitempty"`
+	NoPublish bool   `json:"noPublish,omitempty"`
 }
 
 // NotifyRequest data
@@ -136,6 +144,7 @@ func createRoom(db *gorm.DB) func(echo.Context) error {
 			URL:           os.Getenv("CALLBACK_URL"),
 			CallID:        CallID,
 			NoPublish:     false,
+			Node:          nodeAddress,
 		}
 
 		tokenString, signature, err := GetTokenSignature(token)
@@ -232,6 +241,7 @@ func joinRoom(db *gorm.DB) func(echo.Context) error {
 			URL:           os.Getenv("CALLBACK_URL"),
 			CallID:        call.CallID,
 			NoPublish:     roomRequest.NoPublish,
+			Node:          nodeAddress,
 		}
 
 		tokenString, signature, err := GetTokenSignature(token)
@@ -326,20 +336,28 @@ func callbackRoom(db *gorm.DB) func(echo.Context) error {
 			return c.String(http.StatusBadRequest, "not verified signature")
 		}
 
-		var participant Participant
-		db.Where("uid=?", notifyData.UID).First(&participant)
-		if participant.UID != notifyData.UID {
-			return c.String(http.StatusNotFound, "")
-		}
+		// checkpoints of long calls and nodes settling a call after a restart
+		// only need the spent minutes signed
+		if notifyData.Type != "checkpoint" && notifyData.Type != "settle" {
+			var participant Participant
+			db.Where("uid=?", notifyData.UID).First(&participant)
+			if participant.UID != notifyData.UID {
+				return c.String(http.StatusNotFound, "")
+			}
 
-		if notifyData.Type == "join" {
-			participant.AddedAt = time.Now()
-		}
-		if notifyData.Type == "leave" {
-			participant.RemovedAt = time.Now()
-		}
+			if notifyData.Type == "migrate" {
+				return migrateParticipant(db, c, &participant, &notifyData)
+			}
+
+			if notifyData.Type == "join" {
+				participant.AddedAt = time.Now()
+			}
+			if notifyData.Type == "leave" {
+				participant.RemovedAt = time.Now()
+			}
 
-		db.Save(&participant)
+			db.Save(&participant)
+		}
 
 		h := fnv.New64a()
 		h.Write([]byte(notifyData.CallID))
@@ -373,7 +391,70 @@ func callbackRoom(db *gorm.DB) func(echo.Context) error {
 	}
 }
 
+// migrateParticipant issues a token for the node of notifyData.Host to a
+// participant of a draining node, the call on that node is created if needed
+func migrateParticipant(db *gorm.DB, c echo.Context, participant *Participant, notifyData *NotifyData) error {
+	var room Room
+	db.Where("s_id=?", participant.SID).First(&room)
+	if room.SID != participant.SID || notifyData.SID != participant.SID {
+		return c.String(http.StatusNotFound, "")
+	}
+
+	nodeAddress, nodePK, err := ton.GetNodeByHost(notifyData.Host)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	var call Call
+	db.Where("s_id=? AND node_address=?", room.SID, nodeAddress).First(&call)
+	if call.SID != room.SID {
+		call = Call{
+			SID:         room.SID,
+			CallID:      shortuuid.New(),
+			NodeAddress: nodeAddress,
+			NodePK:      nodePK,
+		}
+		db.Create(&call)
+	}
+
+	token := &Token{
+		SID:           room.SID,
+		UID:           participant.UID,
+		Name:          participant.Name,
+		IsHost:        participant.IsHost,
+		ClientAddress: os.Getenv("TON_ADDRESS"),
+		URL:           os.Getenv("CALLBACK_URL"),
+		CallID:        call.CallID,
+		NoPublish:     notifyData.NoPublish,
+		Node:          nodeAddress,
+	}
+
+	tokenString, signature, err := GetTokenSignature(token)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	tokenView := &TokenView{
+		Token:     tokenString,
+		Signature: signature,
+		URL:       notifyData.Host,
+		SID:       room.SID,
+		UID:       participant.UID,
+		Key:       room.Key,
+	}
+
+	return c.JSON(http.StatusOK, tokenView)
+}
+
+// tokenTTL how long a signed token can be used to join
+const tokenTTL = 5 * time.Minute
+
+// GetTokenSignature sets the validity window and a single use nonce, then signs the token
 func GetTokenSignature(token *Token) (string, string, error) {
+	now := time.Now()
+	token.NotBefore = now.Unix()
+	token.ExpiresAt = now.Add(tokenTTL).Unix()
+	token.Nonce = shortuuid.New()
 
 	j, err := json.Marshal(token)
 	if err != nil {
@@ -0,0 +1,24 @@
+package ton
+
+import (
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/tonclient"
+	"github.com/rs/zerolog/log"
+	"github.com/xssnick/tonutils-go/liteclient"
+	"github.com/xssnick/tonutils-go/ton"
+	"github.com/xssnick/tonutils-go/ton/wallet"
+	"os"
+)
+
+// shared is the user client of the helpers of this package
+var shared = tonclient.NewShared(func() (*UserToncli, error) {
+	user, err := NewUserToncli(os.Getenv("TON_SEED"), wallet.V4R2, os.Getenv("TON_MASTER_CONTRACT"))
+	if err != nil {
+		log.Printf("NewUserToncli %v: %v", os.Getenv("TON_MASTER_CONTRACT"), err)
+	}
+	return user, err
+})
+
+// connect a pool to the lite servers of the global config
+func connect() (*liteclient.ConnectionPool, *ton.APIClient, error) {
+	return tonclient.Connect()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/ed25519"
 	"fmt"
+	"github.com/rs/zerolog/log"
 	"github.com/xssnick/tonutils-go/address"
 	"github.com/xssnick/tonutils-go/liteclient"
 	"github.com/xssnick/tonutils-go/ton"
@@ -29,7 +30,7 @@ func NewMasterToncli(contractAddr string) (*MasterToncli, error) {
 	if hosts, err := contract.GetNodeHosts(); err != nil {
 		return nil, fmt.Errorf("masterContract.GetNodeHosts: %w\n", err)
 	} else {
-		fmt.Printf("hosts = %s\n", hosts)
+		log.Printf("hosts = %s", hosts)
 	}
 
 	return &MasterToncli{
@@ -52,7 +53,7 @@ func (c *MasterToncli) getWallet(seed string, version wallet.Version) (*wallet.W
 	if walletBalance, err := w.GetBalance(context.Background(), block); err != nil {
 		return nil, fmt.Errorf("w.GetBalance: %w", err)
 	} else {
-		fmt.Printf("wallet (address = %s, balance = %s)\n", w.Address(), walletBalance)
+		log.Printf("wallet (address = %s, balance = %s)", w.Address(), walletBalance)
 	}
 
 	return w, nil
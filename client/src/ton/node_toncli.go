@@ -5,6 +5,7 @@ import (
 	"crypto/ed25519"
 	"errors"
 	"fmt"
+	"github.com/rs/zerolog/log"
 	"github.com/xssnick/tonutils-go/address"
 	"github.com/xssnick/tonutils-go/liteclient"
 	"github.com/xssnick/tonutils-go/ton"
@@ -41,14 +42,14 @@ func NewNodeToncli(walletSeed string, walletVersion wallet.Version, masterContra
 	if walletBalance, err := w.GetBalance(context.Background(), block); err != nil {
 		return nil, fmt.Errorf("w.GetBalance: %w", err)
 	} else {
-		fmt.Printf("node wallet (address = %s, balance = %s)\n", w.Address(), walletBalance)
+		log.Printf("node wallet (address = %s, balance = %s)", w.Address(), walletBalance)
 	}
 
 	masterContract := OpenMasterContract(api, address.MustParseAddr(masterContractAddr))
 	if hosts, err := masterContract.GetNodeHosts(); err != nil {
 		return nil, fmt.Errorf("masterContract.GetNodeHosts: %w\n", err)
 	} else {
-		fmt.Printf("hosts = %s\n", hosts)
+		log.Printf("hosts = %s", hosts)
 	}
 
 	contractAddr, err := masterContract.GetNodeContractAddress(w.Address())
@@ -56,7 +57,7 @@ func NewNodeToncli(walletSeed string, walletVersion wallet.Version, masterContra
 		return nil, fmt.Errorf("masterContract.GetNodeContractAddress: %w\n", err)
 	}
 
-	fmt.Printf("node contract (address = %s)\n", contractAddr)
+	log.Printf("node contract (address = %s)", contractAddr)
 
 	contract := OpenNodeContract(api, contractAddr)
 	if nodeData, err := contract.GetData(); err != nil {
@@ -68,7 +69,7 @@ func NewNodeToncli(walletSeed string, walletVersion wallet.Version, masterContra
 			if contractBalance, err := contract.GetBalance(); err != nil {
 				return nil, fmt.Errorf("contract.GetBalance: %w", err)
 			} else {
-				fmt.Printf("node contract (address = %s, balance = %s)\n", contractAddr, contractBalance)
+				log.Printf("node contract (address = %s, balance = %s)", contractAddr, contractBalance)
 			}
 			return &NodeToncli{
 				api:            api,
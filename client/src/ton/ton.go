@@ -2,22 +2,49 @@ package ton
 
 import (
 	"crypto/ed25519"
+	"fmt"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/tonclient"
 	"github.com/rs/zerolog/log"
-	"github.com/xssnick/tonutils-go/ton/wallet"
+	"github.com/xssnick/tonutils-go/address"
 	"math/rand"
-	"os"
 	"strings"
+	"time"
 )
 
+const (
+	// nodeHostsTTL of the hosts registered in the master contract, looked up by
+	// every room request and callback
+	nodeHostsTTL = time.Minute
+	// nodePublicKeyTTL of the public keys of node contracts
+	nodePublicKeyTTL = 5 * time.Minute
+)
+
+var (
+	nodeHosts      = tonclient.NewCache[map[string]*address.Address](nodeHostsTTL)
+	nodePublicKeys = tonclient.NewCache[ed25519.PublicKey](nodePublicKeyTTL)
+)
+
+// getNodeHosts registered in the master contract
+func getNodeHosts(userToncli *UserToncli) (map[string]*address.Address, error) {
+	return nodeHosts.Get("", userToncli.GetNodeHosts)
+}
+
+// getNodePublicKey of the node contract at nodeAddress
+func getNodePublicKey(userToncli *UserToncli, nodeAddress *address.Address) (ed25519.PublicKey, error) {
+	return nodePublicKeys.Get(nodeAddress.String(), func() (ed25519.PublicKey, error) {
+		return userToncli.GetNodePublicKey(nodeAddress)
+	})
+}
+
 func GetNodeURL() (string, string, ed25519.PublicKey, error) {
 	var pk ed25519.PublicKey
 
-	userToncli, err := NewUserToncli(os.Getenv("TON_SEED"), wallet.V4R2, os.Getenv("TON_MASTER_CONTRACT"))
+	userToncli, err := shared.Get()
 	if err != nil {
 		return "", "", pk, err
 	}
 
-	nodes, err := userToncli.GetNodeHosts()
+	nodes, err := getNodeHosts(userToncli)
 	if err != nil {
 		return "", "", pk, err
 	}
@@ -38,7 +65,7 @@ func GetNodeURL() (string, string, ed25519.PublicKey, error) {
 	log.Printf("nodeUrl: %v", nodeUrl)
 	log.Printf("nodeAddress: %v", nodeAddress)
 
-	pk, err = userToncli.GetNodePublicKey(nodeAddress)
+	pk, err = getNodePublicKey(userToncli, nodeAddress)
 	if err != nil {
 		return "", "", pk, err
 	}
@@ -46,17 +73,45 @@ func GetNodeURL() (string, string, ed25519.PublicKey, error) {
 	return nodeUrl, nodeAddress.String(), pk, nil
 }
 
+// GetNodeByHost registered in the master contract, returns the node address and public key
+func GetNodeByHost(host string) (string, ed25519.PublicKey, error) {
+	var pk ed25519.PublicKey
+
+	userToncli, err := shared.Get()
+	if err != nil {
+		return "", pk, err
+	}
+
+	nodes, err := getNodeHosts(userToncli)
+	if err != nil {
+		return "", pk, err
+	}
+
+	nodeAddress, ok := nodes[host]
+	if !ok {
+		return "", pk, fmt.Errorf("node %v not registered", host)
+	}
+
+	pk, err = getNodePublicKey(userToncli, nodeAddress)
+	if err != nil {
+		return "", pk, err
+	}
+
+	return nodeAddress.String(), pk, nil
+}
+
+// GetSignature of data by the wallet of TON_SEED
 func GetSignature(data []byte) ([]byte, error) {
-	nodeToncli, err := NewNodeToncli(os.Getenv("TON_SEED"), wallet.V4R2, os.Getenv("TON_MASTER_CONTRACT"))
+	userToncli, err := shared.Get()
 	if err != nil {
 		return nil, err
 	}
 
-	return SignMessage(nodeToncli.wallet.PrivateKey(), data), nil
+	return SignMessage(userToncli.wallet.PrivateKey(), data), nil
 }
 
 func BuildCreateCallMessage(callId uint64) (msg, sign []byte, err error) {
-	userToncli, err := NewUserToncli(os.Getenv("TON_SEED"), wallet.V4R2, os.Getenv("TON_MASTER_CONTRACT"))
+	userToncli, err := shared.Get()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -64,7 +119,7 @@ func BuildCreateCallMessage(callId uint64) (msg, sign []byte, err error) {
 }
 
 func BuildEndCallMessage(callId uint64, spentMinutes uint32) (msg, sign []byte, err error) {
-	userToncli, err := NewUserToncli(os.Getenv("TON_SEED"), wallet.V4R2, os.Getenv("TON_MASTER_CONTRACT"))
+	userToncli, err := shared.Get()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -5,6 +5,7 @@ import (
 	"crypto/ed25519"
 	"errors"
 	"fmt"
+	"github.com/rs/zerolog/log"
 	"github.com/xssnick/tonutils-go/address"
 	"github.com/xssnick/tonutils-go/liteclient"
 	"github.com/xssnick/tonutils-go/ton"
@@ -15,6 +16,7 @@ import (
 )
 
 type UserToncli struct {
+	pool           *liteclient.ConnectionPool
 	api            *ton.APIClient
 	wallet         *wallet.Wallet
 	masterContract *MasterContract
@@ -22,13 +24,10 @@ type UserToncli struct {
 }
 
 func NewUserToncli(walletSeed string, walletVersion wallet.Version, masterContractAddr string) (*UserToncli, error) {
-	client := liteclient.NewConnectionPool()
-
-	configUrl := "https://ton-blockchain.github.io/testnet-global.config.json"
-	if err := client.AddConnectionsFromConfigUrl(context.Background(), configUrl); err != nil {
-		return nil, fmt.Errorf("client.AddConnectionsFromConfigUrl: %w", err)
+	pool, api, err := connect()
+	if err != nil {
+		return nil, err
 	}
-	api := ton.NewAPIClient(client)
 
 	w, err := wallet.FromSeed(api, strings.Split(walletSeed, " "), walletVersion)
 	if err != nil {
@@ -43,14 +42,14 @@ func NewUserToncli(walletSeed string, walletVersion wallet.Version, masterContra
 	if walletBalance, err := w.GetBalance(context.Background(), block); err != nil {
 		return nil, fmt.Errorf("w.GetBalance: %w", err)
 	} else {
-		fmt.Printf("user wallet (address = %s, balance = %s)\n", w.Address(), walletBalance)
+		log.Printf("user wallet (address = %s, balance = %s)", w.Address(), walletBalance)
 	}
 
 	masterContract := OpenMasterContract(api, address.MustParseAddr(masterContractAddr))
 	if hosts, err := masterContract.GetNodeHosts(); err != nil {
 		return nil, fmt.Errorf("masterContract.GetNodeHosts: %w\n", err)
 	} else {
-		fmt.Printf("hosts = %s\n", hosts)
+		log.Printf("hosts = %s", hosts)
 	}
 
 	contractAddr, err := masterContract.GetUserContractAddress(w.Address())
@@ -68,9 +67,10 @@ func NewUserToncli(walletSeed string, walletVersion wallet.Version, masterContra
 			if contractBalance, err := contract.GetBalance(); err != nil {
 				return nil, fmt.Errorf("contract.GetBalance: %w", err)
 			} else {
-				fmt.Printf("user contract (address = %s, balance = %s)\n", contractAddr, contractBalance)
+				log.Printf("user contract (address = %s, balance = %s)", contractAddr, contractBalance)
 			}
 			return &UserToncli{
+				pool:           pool,
 				api:            api,
 				wallet:         w,
 				masterContract: masterContract,
@@ -127,3 +127,16 @@ func (c *UserToncli) GetNodePublicKey(nodeContractAddr *address.Address) (ed2551
 		return nodeContractData.PublicKey, nil
 	}
 }
+
+// Ping a lite server
+func (c *UserToncli) Ping(ctx context.Context) error {
+	if _, err := c.api.GetMasterchainInfo(ctx); err != nil {
+		return fmt.Errorf("api.GetMasterchainInfo: %w", err)
+	}
+	return nil
+}
+
+// Pool of the lite server connections
+func (c *UserToncli) Pool() *liteclient.ConnectionPool {
+	return c.pool
+}
@@ -16,6 +16,6 @@ fi;
 
 export GOPATH=$PROJECT":"$PROJECT"/gopath:";
 
-gofmt -s -w . && $GOLINT ./... && go vet && go build;
+gofmt -s -w . && $GOLINT ./... && go vet && go build -o main;
 mv main ../;
 cd ../;
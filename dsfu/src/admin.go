@@ -0,0 +1,385 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/node"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/sfu"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AdminUID of the pseudo host executing admin commands
+const AdminUID = "admin"
+
+// AdminHandler serves the admin HTTP API of the node:
+//
+//	GET    /rooms                              rooms of this node
+//	GET    /rooms/{sid}                        room with participants, session and topic peers
+//	DELETE /rooms/{sid}                        end the room on every node
+//	DELETE /rooms/{sid}/participants/{uid}     kick a participant on its node, viewers may be only known there
+//	GET    /sessions                           sfu sessions with peers, receivers and down tracks
+//	GET    /node                               libp2p peers and topic peers by room
+//	POST   /drain                              migrate the participants, settle the calls and exit
+//
+// Every request needs the "Bearer <token>" Authorization of the admin config
+type AdminHandler struct {
+	Token string
+	SFU   *sfu.SFU
+	Node  node.Node
+	// DrainTimeout participants are given to migrate
+	DrainTimeout time.Duration
+}
+
+// AdminRoom summary of a room
+type AdminRoom struct {
+	SID                string   `json:"sid"`
+	CallID             string   `json:"callID"`
+	ClientAddress      string   `json:"clientAddress"`
+	LocalParticipants  int      `json:"localParticipants"`
+	RemoteParticipants int      `json:"remoteParticipants"`
+	LocalViewers       int      `json:"localViewers"`
+	RemoteViewers      int      `json:"remoteViewers"`
+	Hosts              []string `json:"hosts"`
+	Recording          bool     `json:"recording"`
+	Created            bool     `json:"created"`
+	Ended              bool     `json:"ended"`
+	Closed             bool     `json:"closed"`
+}
+
+// AdminRoomDetail of a room
+type AdminRoomDetail struct {
+	AdminRoom
+	Participants []*AdminParticipant `json:"participants"`
+	Session      *AdminSession       `json:"session"`
+	TopicPeers   []string            `json:"topicPeers"`
+}
+
+// AdminParticipant of a room, remote participants are hosted by another node
+type AdminParticipant struct {
+	UID          string         `json:"uid"`
+	Name         string         `json:"name"`
+	Host         string         `json:"host"`
+	Local        bool           `json:"local"`
+	IsHost       bool           `json:"isHost"`
+	NoPublish    bool           `json:"noPublish"`
+	Publishing   bool           `json:"publishing"`
+	AudioMuted   bool           `json:"audioMuted"`
+	VideoMuted   bool           `json:"videoMuted"`
+	Publications []*Publication `json:"publications"`
+	AddedAt      *time.Time     `json:"addedAt,omitempty"`
+}
+
+// AdminSession of the sfu
+type AdminSession struct {
+	ID         string            `json:"id"`
+	Peers      []*AdminPeer      `json:"peers"`
+	RelayPeers []*AdminRelayPeer `json:"relayPeers"`
+}
+
+// AdminPeer of a session with its publisher receivers and subscriber down tracks
+type AdminPeer struct {
+	ID              string            `json:"id"`
+	PublisherState  string            `json:"publisherState,omitempty"`
+	SubscriberState string            `json:"subscriberState,omitempty"`
+	Receivers       []*AdminReceiver  `json:"receivers"`
+	DownTracks      []*AdminDownTrack `json:"downTracks"`
+}
+
+// AdminRelayPeer forwarding tracks of a remote publisher
+type AdminRelayPeer struct {
+	ID        string           `json:"id"`
+	Receivers []*AdminReceiver `json:"receivers"`
+}
+
+// AdminReceiver of a published track
+type AdminReceiver struct {
+	TrackID   string    `json:"trackID"`
+	StreamID  string    `json:"streamID"`
+	Kind      string    `json:"kind"`
+	MimeType  string    `json:"mimeType"`
+	ClockRate uint32    `json:"clockRate"`
+	Layers    []uint16  `json:"layers"`
+	Bitrate   [3]uint64 `json:"bitrate"`
+}
+
+// AdminDownTrack forwarded to a subscriber
+type AdminDownTrack struct {
+	ID                  string `json:"id"`
+	StreamID            string `json:"streamID"`
+	Kind                string `json:"kind"`
+	MimeType            string `json:"mimeType"`
+	Enabled             bool   `json:"enabled"`
+	Simulcast           bool   `json:"simulcast"`
+	CurrentSpatialLayer int    `json:"currentSpatialLayer"`
+	TargetSpatialLayer  int    `json:"targetSpatialLayer"`
+	TemporalLayer       int    `json:"temporalLayer"`
+}
+
+// AdminNode libp2p state
+type AdminNode struct {
+	ID         string              `json:"id"`
+	Draining   bool                `json:"draining"`
+	Peers      []string            `json:"peers"`
+	TopicPeers map[string][]string `json:"topicPeers"`
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	authorization := r.Header.Get("Authorization")
+	if h.Token == "" || subtle.ConstantTimeCompare([]byte(authorization), []byte("Bearer "+h.Token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/rooms":
+		h.write(w, h.rooms())
+	case r.Method == http.MethodGet && len(parts) == 2 && parts[0] == "rooms":
+		room := h.room(w, r, parts[1])
+		if room != nil {
+			h.write(w, h.roomDetail(room))
+		}
+	case r.Method == http.MethodDelete && len(parts) == 2 && parts[0] == "rooms":
+		room := h.room(w, r, parts[1])
+		if room != nil {
+			log.Printf("admin: end room %v", room.SID)
+			room.OnEnd(h.adminParticipant(room))
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case r.Method == http.MethodDelete && len(parts) == 4 && parts[0] == "rooms" && parts[2] == "participants":
+		room := h.room(w, r, parts[1])
+		if room == nil {
+			return
+		}
+		log.Printf("admin: kick %v from %v", parts[3], room.SID)
+		if err := room.Moderate(&ModerationCommand{Action: "kick", UID: parts[3]}); err != nil {
+			http.Error(w, "participant not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && r.URL.Path == "/sessions":
+		sessions := make([]*AdminSession, 0)
+		for _, session := range h.SFU.GetSessions() {
+			sessions = append(sessions, adminSession(session))
+		}
+		sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+		h.write(w, sessions)
+	case r.Method == http.MethodGet && r.URL.Path == "/node":
+		h.write(w, h.node())
+	case r.Method == http.MethodPost && r.URL.Path == "/drain":
+		log.Printf("admin: drain")
+		go Drain(h.DrainTimeout)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *AdminHandler) write(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error().Err(err).Msg("admin")
+	}
+}
+
+func (h *AdminHandler) room(w http.ResponseWriter, r *http.Request, SID string) *Room {
+	ival, ok := Rooms.Load(SID)
+	if !ok {
+		http.Error(w, "room not found", http.StatusNotFound)
+		return nil
+	}
+	room, _ := ival.(*Room)
+	return room
+}
+
+// adminParticipant acts as host of room for admin commands, remote nodes
+// only follow commands of hosts
+func (h *AdminHandler) adminParticipant(room *Room) *Participant {
+	return &Participant{
+		SID:    room.SID,
+		UID:    AdminUID,
+		Name:   AdminUID,
+		IsHost: true,
+		Host:   h.Node.ID().Pretty(),
+	}
+}
+
+func (h *AdminHandler) rooms() []*AdminRoom {
+	rooms := make([]*AdminRoom, 0)
+	Rooms.Range(func(_, ival interface{}) bool {
+		room, _ := ival.(*Room)
+		rooms = append(rooms, h.roomSummary(room))
+		return true
+	})
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].SID < rooms[j].SID })
+	return rooms
+}
+
+func (h *AdminHandler) roomSummary(room *Room) *AdminRoom {
+	nodeID := h.Node.ID().Pretty()
+	summary := &AdminRoom{
+		SID:           room.SID,
+		CallID:        room.CallID,
+		ClientAddress: room.ClientAddress,
+		Hosts:         make([]string, 0),
+		Recording:     room.IsRecording(),
+		Created:       room.call.Created(),
+		Ended:         room.call.Ended(),
+		Closed:        room.IsClosed(),
+	}
+	room.OnlineParticipants.Range(func(_, ival interface{}) bool {
+		participant, _ := ival.(*Participant)
+		switch {
+		case participant.Host != nodeID:
+			summary.RemoteParticipants++
+		case participant.IsViewer():
+		default:
+			summary.LocalParticipants++
+		}
+		return true
+	})
+	summary.LocalViewers = room.GetLocalViewersCount()
+	room.RemoteViewersCount.Range(func(_, ival interface{}) bool {
+		summary.RemoteViewers += ival.(int)
+		return true
+	})
+	room.Hosts.Range(func(ikey, _ interface{}) bool {
+		summary.Hosts = append(summary.Hosts, ikey.(string))
+		return true
+	})
+	sort.Strings(summary.Hosts)
+	return summary
+}
+
+func (h *AdminHandler) roomDetail(room *Room) *AdminRoomDetail {
+	nodeID := h.Node.ID().Pretty()
+	detail := &AdminRoomDetail{
+		AdminRoom:    *h.roomSummary(room),
+		Participants: make([]*AdminParticipant, 0),
+		TopicPeers:   peerIDs(h.Node.TopicPeers(room.SID)),
+	}
+
+	room.OnlineParticipants.Range(func(_, ival interface{}) bool {
+		participant, _ := ival.(*Participant)
+		view := &AdminParticipant{
+			UID:          participant.UID,
+			Name:         participant.Name,
+			Host:         participant.Host,
+			Local:        participant.Host == nodeID,
+			IsHost:       participant.IsHost,
+			NoPublish:    participant.IsViewer(),
+			AudioMuted:   participant.IsMuted("audio"),
+			VideoMuted:   participant.IsMuted("video"),
+			Publications: participant.GetPublications(),
+		}
+		view.Publishing = len(view.Publications) > 0
+		if view.Local {
+			addedAt := participant.AddedAt
+			view.AddedAt = &addedAt
+		}
+		detail.Participants = append(detail.Participants, view)
+		return true
+	})
+	sort.Slice(detail.Participants, func(i, j int) bool { return detail.Participants[i].UID < detail.Participants[j].UID })
+
+	if room.Session != nil {
+		detail.Session = adminSession(room.Session)
+	}
+	return detail
+}
+
+func (h *AdminHandler) node() *AdminNode {
+	view := &AdminNode{
+		ID:         h.Node.ID().Pretty(),
+		Draining:   IsDraining(),
+		Peers:      peerIDs(h.Node.Peers()),
+		TopicPeers: make(map[string][]string),
+	}
+	Rooms.Range(func(ikey, _ interface{}) bool {
+		SID := ikey.(string)
+		view.TopicPeers[SID] = peerIDs(h.Node.TopicPeers(SID))
+		return true
+	})
+	return view
+}
+
+func adminSession(session sfu.Session) *AdminSession {
+	view := &AdminSession{
+		ID:         session.ID(),
+		Peers:      make([]*AdminPeer, 0),
+		RelayPeers: make([]*AdminRelayPeer, 0),
+	}
+
+	for _, peer := range session.Peers() {
+		peerView := &AdminPeer{
+			ID:         peer.ID(),
+			Receivers:  make([]*AdminReceiver, 0),
+			DownTracks: make([]*AdminDownTrack, 0),
+		}
+		if publisher := peer.Publisher(); publisher != nil {
+			peerView.PublisherState = publisher.PeerConnection().ConnectionState().String()
+			peerView.Receivers = adminReceivers(publisher.GetRouter())
+		}
+		if subscriber := peer.Subscriber(); subscriber != nil {
+			peerView.SubscriberState = subscriber.PeerConnection().ConnectionState().String()
+			for _, dt := range subscriber.DownTracks() {
+				peerView.DownTracks = append(peerView.DownTracks, &AdminDownTrack{
+					ID:                  dt.ID(),
+					StreamID:            dt.StreamID(),
+					Kind:                dt.Kind().String(),
+					MimeType:            dt.Codec().MimeType,
+					Enabled:             dt.Enabled(),
+					Simulcast:           dt.Simulcast(),
+					CurrentSpatialLayer: dt.CurrentSpatialLayer(),
+					TargetSpatialLayer:  dt.TargetSpatialLayer(),
+					TemporalLayer:       dt.CurrentTemporalLayer(),
+				})
+			}
+		}
+		view.Peers = append(view.Peers, peerView)
+	}
+
+	for _, relayPeer := range session.RelayPeers() {
+		view.RelayPeers = append(view.RelayPeers, &AdminRelayPeer{
+			ID:        relayPeer.ID(),
+			Receivers: adminReceivers(relayPeer.GetRouter()),
+		})
+	}
+
+	sort.Slice(view.Peers, func(i, j int) bool { return view.Peers[i].ID < view.Peers[j].ID })
+	sort.Slice(view.RelayPeers, func(i, j int) bool { return view.RelayPeers[i].ID < view.RelayPeers[j].ID })
+	return view
+}
+
+func adminReceivers(router sfu.Router) []*AdminReceiver {
+	receivers := make([]*AdminReceiver, 0)
+	for _, receiver := range router.GetReceiver() {
+		codec := receiver.Codec()
+		receivers = append(receivers, &AdminReceiver{
+			TrackID:   receiver.TrackID(),
+			StreamID:  receiver.StreamID(),
+			Kind:      receiver.Kind().String(),
+			MimeType:  codec.MimeType,
+			ClockRate: codec.ClockRate,
+			Layers:    receiver.AvailableLayers(),
+			Bitrate:   receiver.GetBitrate(),
+		})
+	}
+	sort.Slice(receivers, func(i, j int) bool { return receivers[i].TrackID < receivers[j].TrackID })
+	return receivers
+}
+
+func peerIDs(peers []peer.ID) []string {
+	ids := make([]string, 0, len(peers))
+	for _, id := range peers {
+		ids = append(ids, id.Pretty())
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/billing"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/stats"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/ton"
+	"github.com/lucsky/cuid"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// JournalTickInterval in observer ticks between the ticks of a billing
+// journal, participants of a crashed node are billed up to the last one
+const JournalTickInterval = 30
+
+// openJournal of the call of the room, rooms without billing have none
+func (r *Room) openJournal() {
+	if r.NoBilling || conf.Billing.Journal == "" {
+		return
+	}
+	journal, err := billing.New(conf.Billing.Journal, cuid.New(), billing.Call{
+		SID:           r.SID,
+		CallID:        r.CallID,
+		ClientAddress: r.ClientAddress,
+		URL:           r.URL,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("sid", r.SID).Msg("journal")
+		return
+	}
+	r.journal = journal
+}
+
+// record an entry in the journal of the room, if it has one
+func (r *Room) record(write func(journal *billing.Journal) error) {
+	if r.journal == nil {
+		return
+	}
+	// entries after a settlement removed the journal are dropped
+	if err := write(r.journal); err != nil && !errors.Is(err, billing.ErrClosed) {
+		log.Error().Err(err).Str("sid", r.SID).Msg("journal")
+	}
+}
+
+// SettleJournals ends the calls of replays a crash or a failed end call
+// transaction left unsettled. The client backend signs the spent minutes of
+// the journal, the last message it signed before is sent if it is still
+// valid. Journals of calls which could not be settled are kept for the next
+// start
+func SettleJournals(replays []*billing.Replay) {
+	for _, replay := range replays {
+		settled, err := isSettled(replay)
+		if err != nil {
+			log.Error().Err(err).Str("sid", replay.SID).Str("callID", replay.CallID).Msg("settle")
+			continue
+		}
+		if !settled {
+			if err := settle(replay); err != nil {
+				log.Error().Err(err).Str("sid", replay.SID).Str("callID", replay.CallID).Msg("settle")
+				continue
+			}
+			log.Info().Str("sid", replay.SID).Str("callID", replay.CallID).Msg("settled")
+		}
+		if err := replay.Remove(); err != nil {
+			log.Error().Err(err).Msg("journal")
+		}
+	}
+}
+
+// isSettled tells if the call of replay needs no end call transaction. A
+// create call transaction which failed or was cut by a crash may still have
+// been processed, the call is settled if the user contract has no such call
+func isSettled(replay *billing.Replay) (bool, error) {
+	if !replay.CreateUncertain() {
+		return replay.Settled(), nil
+	}
+	open, err := isCallOpen(replay.ClientAddress, replay.CreateMessage)
+	if err != nil {
+		return false, err
+	}
+	return !open, nil
+}
+
+// settle the call of replay with an end call transaction
+func settle(replay *billing.Replay) error {
+	duration := replay.Minutes()
+	if replay.LastResponse != nil && replay.LastResponse.Duration > duration {
+		duration = replay.LastResponse.Duration
+	}
+
+	notifyData := NotifyData{
+		Duration: duration,
+		SID:      replay.SID,
+		CallID:   replay.CallID,
+		Type:     "settle",
+	}
+	var notifyResponse NotifyResponse
+	if err := postNotify(replay.URL, notifyData, &notifyResponse); err != nil {
+		last := replay.LastResponse
+		if last == nil {
+			return err
+		}
+		if validUntil, ok := ton.EndCallValidUntil(last.Message); !ok || time.Now().After(validUntil) {
+			return err
+		}
+		log.Warn().Err(err).Str("sid", replay.SID).Msg("settle with the last signed message")
+		notifyResponse = NotifyResponse{Message: last.Message, Signature: last.Signature, Duration: last.Duration}
+	}
+
+	err := endCallTx(replay.ClientAddress, notifyResponse.Signature, notifyResponse.Message)
+	stats.TonTransaction("settle_call", err)
+	return err
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/billing"
+)
+
+// replayOf a journal of dir with a failed create call transaction
+func replayOf(t *testing.T, dir string) *billing.Replay {
+	j, err := billing.New(dir, "room", billing.Call{SID: "room", CallID: "call", ClientAddress: "EQclient"})
+	require.NoError(t, err)
+	require.NoError(t, j.Join("a", false, time.Now()))
+	require.NoError(t, j.Response(billing.Response{Action: "join", Message: []byte("create")}))
+	require.NoError(t, j.Created(errors.New("transaction not processed")))
+	require.NoError(t, j.Close())
+
+	replays, err := billing.Load(dir)
+	require.NoError(t, err)
+	require.Len(t, replays, 1)
+	return replays[0]
+}
+
+func TestIsSettled(t *testing.T) {
+	open, lookupErr := false, error(nil)
+	callOpen := isCallOpen
+	isCallOpen = func(userWalletAddr string, userMsg []byte) (bool, error) {
+		assert.Equal(t, "EQclient", userWalletAddr)
+		assert.Equal(t, []byte("create"), userMsg)
+		return open, lookupErr
+	}
+	t.Cleanup(func() {
+		isCallOpen = callOpen
+	})
+	replay := replayOf(t, t.TempDir())
+
+	settled, err := isSettled(replay)
+	require.NoError(t, err)
+	assert.True(t, settled, "the create was not processed")
+
+	open = true
+	settled, err = isSettled(replay)
+	require.NoError(t, err)
+	assert.False(t, settled, "the create was processed and the call is open")
+
+	lookupErr = errors.New("lite server unavailable")
+	_, err = isSettled(replay)
+	assert.Error(t, err, "the journal is kept for the next start")
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/billing"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/stats"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/ton"
+	"github.com/rs/zerolog/log"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// CheckpointInterval in observer ticks between the checkpoints of a call.
+// Signed end call messages are valid for a minute, the node always keeps one
+// ready to submit
+const CheckpointInterval = 20
+
+// checkpointMargin a signed message must stay valid for beyond the next
+// checkpoint, the call is settled when the client backend missed too many
+const checkpointMargin = 5 * time.Second
+
+// checkpoint asks the client backend to sign the minutes spent so far and
+// keeps its message for the end of the call. A call whose last message would
+// expire before the next checkpoint is settled with it
+func (r *Room) checkpoint() {
+	if !atomic.CompareAndSwapInt32(&r.checkpointing, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&r.checkpointing, 0)
+
+	if r.call.Ending() {
+		return
+	}
+	err := r.requestCheckpoint()
+	if err == nil {
+		return
+	}
+	log.Warn().Err(err).Str("sid", r.SID).Msg("checkpoint")
+
+	last := r.lastNotifyResponse()
+	validUntil, ok := ton.EndCallValidUntil(last.Message)
+	if !ok || time.Until(validUntil) > CheckpointInterval*time.Second+checkpointMargin {
+		return
+	}
+	log.Warn().Str("sid", r.SID).Time("validUntil", validUntil).Msg("client backend unavailable, settling the call")
+	if r.endCall() {
+		// the participants are not billed anymore
+		r.EndRoom()
+	}
+}
+
+// requestCheckpoint of the spent minutes signed by the client backend
+func (r *Room) requestCheckpoint() error {
+	duration := r.getSpentDuration()
+	if duration == 0 {
+		// the client backend signs create call messages for no minutes
+		return nil
+	}
+	notifyData := NotifyData{
+		Duration: duration,
+		SID:      r.SID,
+		CallID:   r.CallID,
+		Type:     "checkpoint",
+	}
+
+	var notifyResponse NotifyResponse
+	if err := r.callback(notifyData, &notifyResponse); err != nil {
+		return err
+	}
+	if notifyResponse.Duration == 0 {
+		notifyResponse.Duration = duration
+	}
+	r.keepNotifyResponse(notifyResponse)
+	r.record(func(journal *billing.Journal) error {
+		return journal.Response(billing.Response{
+			Action:    notifyData.Type,
+			Message:   notifyResponse.Message,
+			Signature: notifyResponse.Signature,
+			Duration:  notifyResponse.Duration,
+		})
+	})
+	return nil
+}
+
+// endCall with the last signed spent minutes, once per call. A message which
+// expired is signed again first. Returns false if the call was already ended
+func (r *Room) endCall() bool {
+	if !r.call.BeginEnd() {
+		return false
+	}
+
+	last := r.lastNotifyResponse()
+	if validUntil, ok := ton.EndCallValidUntil(last.Message); !ok || time.Now().After(validUntil) {
+		if err := r.requestCheckpoint(); err != nil {
+			log.Error().Err(err).Str("sid", r.SID).Msg("end call")
+		}
+		last = r.lastNotifyResponse()
+	}
+
+	log.Printf("end call: %v", last)
+
+	err := endCallTx(r.ClientAddress, last.Signature, last.Message)
+	stats.TonTransaction("end_call", err)
+	log.Printf("err: %v", err)
+	// a failed end is settled on the next start
+	r.record(func(journal *billing.Journal) error {
+		return journal.Ended(err)
+	})
+	return true
+}
+
+// keepNotifyResponse with the most spent minutes for the end of the call
+func (r *Room) keepNotifyResponse(notifyResponse NotifyResponse) {
+	r.notifyMu.Lock()
+	defer r.notifyMu.Unlock()
+	// notifies and checkpoints may answer out of order, the spent minutes only grow
+	if notifyResponse.Duration >= r.LastNotifyResponse.Duration {
+		r.LastNotifyResponse = notifyResponse
+	}
+}
+
+// getSpentDuration of the local publishers who left or were demoted and of
+// those still online
+func (r *Room) getSpentDuration() int {
+	duration := 0.0
+	now := time.Now()
+
+	r.EndedParticipants.Range(func(k, _ interface{}) bool {
+		interval := k.(*PublishInterval)
+		duration += interval.RemovedAt.Sub(interval.AddedAt).Seconds()
+		return true
+	})
+	r.OnlineParticipants.Range(func(_, v interface{}) bool {
+		participant := v.(*Participant)
+		if participant.Host != r.Node.ID().Pretty() {
+			return true
+		}
+		if noPublish, since := participant.role(); !noPublish {
+			duration += now.Sub(since).Seconds()
+		}
+		return true
+	})
+
+	return int(math.Ceil(duration / 60.0))
+}
@@ -0,0 +1,576 @@
+// Command loadtest joins synthetic publishers and subscribers to dsfu nodes
+// and reports join latency, time to first frame, packet loss, NACK/PLI
+// counts and CPU usage per node, to size nodes before they are registered
+// in the master contract.
+//
+// Nodes run locally with the [loadtest] section of config.toml enabled and
+// its clientkey set to the public key printed by -keygen:
+//
+//	go run ./cmd/loadtest -keygen
+//	go run ./cmd/loadtest -key <seed> -nodes ws://127.0.0.1:7880/ws -pids <node pid> -publishers 2 -subscribers 20
+//
+// Publishers loop Opus and VP8 from -audio and -video or synthetic frames,
+// video is sent as three simulcast layers unless -simulcast=false.
+//
+// With -churn subscribers leave and join again and publishers toggle their
+// audio mute at that interval, with -end the first publisher joins as host
+// and ends the call at the end of -duration while the churn goes on. Nodes
+// built with -race then check the rooms for data races between joins,
+// leaves, the end of the call and the state published to other nodes:
+//
+//	go build -race -o dsfu-race . && ./dsfu-race -c config.toml
+//	go run ./cmd/loadtest -key <seed> -nodes ws://127.0.0.1:7880/ws,ws://127.0.0.1:7882/ws -publishers 2 -subscribers 8 -churn 200ms -end
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/client"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/ton"
+	"github.com/lucsky/cuid"
+	"github.com/pion/ice/v2"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+)
+
+// tokenTTL of the generated join tokens
+const tokenTTL = 5 * time.Minute
+
+// token signed like the client backend does, see Token of dsfu
+type token struct {
+	SID           string `json:"sid"`
+	UID           string `json:"uid"`
+	Name          string `json:"name"`
+	IsHost        bool   `json:"isHost"`
+	ClientAddress string `json:"clientAddress"`
+	URL           string `json:"url"`
+	CallID        string `json:"callID"`
+	NoPublish     bool   `json:"noPublish"`
+	NotBefore     int64  `json:"nbf"`
+	ExpiresAt     int64  `json:"exp"`
+	Nonce         string `json:"jti"`
+}
+
+type options struct {
+	nodes       []string
+	key         ed25519.PrivateKey
+	room        string
+	publishers  int
+	subscribers int
+	duration    time.Duration
+	ramp        time.Duration
+	simulcast   bool
+	churn       time.Duration
+	end         bool
+	audio       []frame
+	video       []frame
+}
+
+func main() {
+	var (
+		nodes       = flag.String("nodes", "ws://127.0.0.1:7880/ws", "comma separated websocket URLs of the nodes")
+		pids        = flag.String("pids", "", "comma separated pids of the nodes for CPU usage")
+		key         = flag.String("key", os.Getenv("LOADTEST_KEY"), "hex ed25519 seed tokens are signed with")
+		keygen      = flag.Bool("keygen", false, "print a new seed and the clientkey of the node config")
+		room        = flag.String("room", "loadtest", "room SID")
+		publishers  = flag.Int("publishers", 1, "number of publishers")
+		subscribers = flag.Int("subscribers", 10, "number of subscribers")
+		duration    = flag.Duration("duration", time.Minute, "duration after all clients joined")
+		ramp        = flag.Duration("ramp", 100*time.Millisecond, "delay between joins")
+		simulcast   = flag.Bool("simulcast", true, "publish video as q, h and f layers")
+		churn       = flag.Duration("churn", 0, "interval subscribers rejoin and publishers toggle mute at, 0 disables")
+		end         = flag.Bool("end", false, "the first publisher ends the call as host after -duration")
+		audio       = flag.String("audio", "", "Ogg Opus file, synthetic frames if empty")
+		video       = flag.String("video", "", "IVF VP8 file, synthetic frames if empty")
+	)
+	flag.Parse()
+
+	if *keygen {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			exit(err)
+		}
+		fmt.Printf("key:       %s\nclientkey: %s\n", hex.EncodeToString(priv.Seed()), hex.EncodeToString(pub))
+		return
+	}
+
+	seed, err := hex.DecodeString(*key)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		exit(fmt.Errorf("-key: hex ed25519 seed required, see -keygen"))
+	}
+
+	o := &options{
+		nodes:       strings.Split(*nodes, ","),
+		key:         ed25519.NewKeyFromSeed(seed),
+		room:        *room,
+		publishers:  *publishers,
+		subscribers: *subscribers,
+		duration:    *duration,
+		ramp:        *ramp,
+		simulcast:   *simulcast,
+		churn:       *churn,
+		end:         *end,
+		audio:       syntheticOpus(),
+	}
+	if *audio != "" {
+		if o.audio, err = loadOgg(*audio); err != nil {
+			exit(err)
+		}
+	}
+	if *video != "" {
+		if o.video, err = loadIVF(*video); err != nil {
+			exit(err)
+		}
+	}
+
+	stats := make([]*nodeStats, len(o.nodes))
+	for i, URL := range o.nodes {
+		stats[i] = &nodeStats{URL: URL}
+	}
+	for i, pid := range strings.Split(*pids, ",") {
+		if pid != "" && i < len(stats) {
+			stats[i].cpu = newCPUSampler(pid)
+		}
+	}
+	self := newCPUSampler("self")
+
+	run(o, stats, self)
+
+	for _, s := range stats {
+		s.report(os.Stdout)
+	}
+	fmt.Printf("loadtest cpu     %s\n", self)
+}
+
+func run(o *options, stats []*nodeStats, self *cpuSampler) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for _, s := range stats {
+					if s.cpu != nil {
+						s.cpu.sample()
+					}
+				}
+				self.sample()
+			}
+		}
+	}()
+
+	settingEngine, err := loopbackSettingEngine()
+	if err != nil {
+		exit(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var closers []func()
+	var endCall func()
+
+	for i := 0; i < o.publishers+o.subscribers && ctx.Err() == nil; i++ {
+		node := i % len(o.nodes)
+		publisher := i < o.publishers
+		host := i == 0 && o.end
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var closer func()
+			switch {
+			case publisher:
+				var end func()
+				closer, end = publish(ctx, o, node, stats[node], settingEngine, host)
+				if host && end != nil {
+					mu.Lock()
+					endCall = end
+					mu.Unlock()
+				}
+			case o.churn > 0:
+				closer = rejoin(ctx, o.churn, func() func() {
+					return subscribe(ctx, o, node, stats[node], settingEngine)
+				})
+			default:
+				closer = subscribe(ctx, o, node, stats[node], settingEngine)
+			}
+			if closer != nil {
+				mu.Lock()
+				closers = append(closers, closer)
+				mu.Unlock()
+			}
+		}()
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(o.ramp):
+		}
+	}
+	wg.Wait()
+
+	fmt.Printf("%d clients joined, running for %v\n", len(closers), o.duration)
+	select {
+	case <-ctx.Done():
+	case <-time.After(o.duration):
+	}
+	if endCall != nil {
+		fmt.Println("ending the call")
+		endCall()
+		// joins go on against the ended room
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+	cancel()
+
+	for _, s := range stats {
+		if s.cpu != nil {
+			s.cpu.sample()
+		}
+	}
+	self.sample()
+
+	for _, closer := range closers {
+		closer()
+	}
+}
+
+// loopbackSettingEngine of the clients. Loopback interfaces are not gathered
+// by pion, host candidates of the other interfaces reach the loopback
+// candidates of the nodes as the ICE lite nodes answer to the source address
+func loopbackSettingEngine() (*webrtc.SettingEngine, error) {
+	se := &webrtc.SettingEngine{}
+	se.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4})
+	se.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
+	return se, nil
+}
+
+// join a new client to node, setup sets its callbacks. The join is timed
+// from the signed token to the join reply
+func join(ctx context.Context, o *options, node int, stats *nodeStats, se *webrtc.SettingEngine, noPublish bool, isHost bool, setup func(c *client.Client)) (*client.Client, error) {
+	c, err := client.New(client.Config{SettingEngine: se})
+	if err != nil {
+		return nil, err
+	}
+	if setup != nil {
+		setup(c)
+	}
+
+	now := time.Now()
+	UID := cuid.New()
+	t := &token{
+		SID:       o.room,
+		UID:       UID,
+		Name:      "loadtest-" + UID,
+		IsHost:    isHost,
+		NoPublish: noPublish,
+		NotBefore: now.Unix(),
+		ExpiresAt: now.Add(tokenTTL).Unix(),
+		Nonce:     cuid.New(),
+	}
+	tokenJson, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+
+	view := &client.TokenView{
+		Token:     base64.StdEncoding.EncodeToString(tokenJson),
+		Signature: base64.StdEncoding.EncodeToString(ton.SignMessage(o.key, tokenJson)),
+		URL:       o.nodes[node],
+		SID:       o.room,
+		UID:       UID,
+		NoPublish: noPublish,
+	}
+
+	err = c.Join(ctx, view)
+	stats.addJoin(time.Since(now), err)
+	if err != nil {
+		_ = c.Close()
+		fmt.Fprintf(os.Stderr, "join %s: %v\n", o.nodes[node], err)
+		return nil, err
+	}
+	return c, nil
+}
+
+// publish joins a publisher looping audio and video until the test ends,
+// end ends the call of a host
+func publish(ctx context.Context, o *options, node int, stats *nodeStats, se *webrtc.SettingEngine, host bool) (closer func(), end func()) {
+	c, err := join(ctx, o, node, stats, se, false, host, nil)
+	if err != nil {
+		return nil, nil
+	}
+
+	streamID := "camera-" + c.UID
+	audio := newSampleTrack(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}, "audio-"+c.UID, "", streamID)
+
+	rids := []string{""}
+	if o.simulcast {
+		rids = rids[:0]
+		for _, layer := range layers {
+			rids = append(rids, layer.rid)
+		}
+	}
+	videos := make([]*sampleTrack, len(rids))
+	for i, rid := range rids {
+		videos[i] = newSampleTrack(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}, "video-"+c.UID, rid, streamID)
+	}
+
+	pc := c.Publisher()
+	audioSender, err := pc.AddTrack(audio)
+	if err != nil {
+		_ = c.Close()
+		return nil, nil
+	}
+	videoSender, err := pc.AddTrack(videos[0])
+	if err != nil {
+		_ = c.Close()
+		return nil, nil
+	}
+	for _, video := range videos[1:] {
+		if err := videoSender.AddEncoding(video); err != nil {
+			_ = c.Close()
+			return nil, nil
+		}
+	}
+
+	if err := c.Renegotiate(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "publish %s: %v\n", o.nodes[node], err)
+		_ = c.Close()
+		return nil, nil
+	}
+	for _, transceiver := range pc.GetTransceivers() {
+		if transceiver.Sender() == videoSender {
+			for _, video := range videos {
+				video.SetMid(transceiver.Mid())
+			}
+		}
+	}
+
+	done := make(chan struct{})
+	go readRTCP(stats, nil, func(b []byte) (int, error) {
+		n, _, err := audioSender.Read(b)
+		return n, err
+	})
+	go loop(o.audio, []*sampleTrack{audio}, nil, done)
+
+	// one loop for the layers of a file, synthetic layers differ in bitrate
+	keyframes := make([]chan struct{}, len(videos))
+	for i, video := range videos {
+		if i == 0 || o.video == nil {
+			keyframes[i] = make(chan struct{}, 1)
+		} else {
+			keyframes[i] = keyframes[0]
+		}
+
+		rid := video.RID()
+		go readRTCP(stats, keyframes[i], func(b []byte) (int, error) {
+			n, _, err := videoSender.ReadSimulcast(b, rid)
+			return n, err
+		})
+
+		if o.video == nil {
+			bitrate := layers[len(layers)-1].bitrate
+			if o.simulcast {
+				bitrate = layers[i].bitrate
+			}
+			go loop(syntheticVP8(bitrate), []*sampleTrack{video}, keyframes[i], done)
+		}
+	}
+	if o.video != nil {
+		go loop(o.video, videos, keyframes[0], done)
+	}
+	if o.churn > 0 {
+		go toggleMute(ctx, c, o.churn, done)
+	}
+
+	closer = func() {
+		close(done)
+		_ = c.Close()
+	}
+	end = func() {
+		if err := c.End(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "end %s: %v\n", o.nodes[node], err)
+		}
+	}
+	return closer, end
+}
+
+// toggleMute of the audio of a publisher every interval
+func toggleMute(ctx context.Context, c *client.Client, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	muted := false
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		muted = !muted
+		if err := c.Mute(ctx, "audio", muted); err != nil {
+			return
+		}
+	}
+}
+
+// rejoin a client every interval until the test ends, join returns the
+// closer of a new client or nil if its join failed
+func rejoin(ctx context.Context, interval time.Duration, join func() func()) func() {
+	closer := join()
+	if closer == nil {
+		return nil
+	}
+
+	var mu sync.Mutex
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			mu.Lock()
+			if closer != nil {
+				closer()
+			}
+			closer = join()
+			mu.Unlock()
+		}
+	}()
+
+	return func() {
+		close(done)
+		mu.Lock()
+		defer mu.Unlock()
+		if closer != nil {
+			closer()
+			closer = nil
+		}
+	}
+}
+
+// readRTCP counts the NACKs and PLIs the node sends to a publisher, PLIs
+// request a keyframe
+func readRTCP(stats *nodeStats, keyframe chan<- struct{}, read func([]byte) (int, error)) {
+	buf := make([]byte, 1500)
+	for {
+		n, err := read(buf)
+		if err != nil {
+			return
+		}
+		packets, err := rtcp.Unmarshal(buf[:n])
+		if err != nil {
+			continue
+		}
+		var nacks, plis uint64
+		for _, packet := range packets {
+			switch packet.(type) {
+			case *rtcp.TransportLayerNack:
+				nacks++
+			case *rtcp.PictureLossIndication:
+				plis++
+				select {
+				case keyframe <- struct{}{}:
+				default:
+				}
+			}
+		}
+		stats.addRTCP(nacks, plis)
+	}
+}
+
+// subscribe joins a viewer counting the packets of every track it receives
+func subscribe(ctx context.Context, o *options, node int, stats *nodeStats, se *webrtc.SettingEngine) func() {
+	var mu sync.Mutex
+	var sequences []*sequence
+	var once sync.Once
+	start := time.Now()
+
+	c, err := join(ctx, o, node, stats, se, true, false, func(c *client.Client) {
+		c.OnTrack = func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+			s := &sequence{}
+			mu.Lock()
+			sequences = append(sequences, s)
+			mu.Unlock()
+
+			video := track.Kind() == webrtc.RTPCodecTypeVideo
+			for {
+				packet, _, err := track.ReadRTP()
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				s.push(packet.SequenceNumber)
+				mu.Unlock()
+
+				if video && isKeyframe(packet.Payload) {
+					once.Do(func() {
+						stats.addTTFF(time.Since(start))
+					})
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil
+	}
+
+	return func() {
+		_ = c.Close()
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, s := range sequences {
+			stats.addPackets(s.expected(), s.received)
+		}
+	}
+}
+
+// isKeyframe start of a VP8 keyframe
+func isKeyframe(payload []byte) bool {
+	vp8 := &codecs.VP8Packet{}
+	data, err := vp8.Unmarshal(payload)
+	if err != nil || len(data) == 0 {
+		return false
+	}
+	return vp8.S == 1 && vp8.PID == 0 && data[0]&0x01 == 0
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
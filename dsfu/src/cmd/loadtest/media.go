@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/ivfreader"
+	"github.com/pion/webrtc/v3/pkg/media/oggreader"
+)
+
+// mtu of the packetized samples
+const mtu = 1200
+
+// Simulcast layers, bitrates of the synthetic video in bps
+var layers = []struct {
+	rid     string
+	bitrate int
+}{
+	{"q", 150_000},
+	{"h", 500_000},
+	{"f", 1_500_000},
+}
+
+// frame of a looped sample
+type frame struct {
+	data     []byte
+	duration time.Duration
+}
+
+// loadOgg reads the Opus pages of an Ogg file
+func loadOgg(path string) ([]frame, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ogg, _, err := oggreader.NewWith(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var frames []frame
+	var lastGranule uint64
+	for {
+		data, header, err := ogg.ParseNextPage()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		samples := header.GranulePosition - lastGranule
+		lastGranule = header.GranulePosition
+		if samples == 0 || len(data) == 0 {
+			continue
+		}
+		frames = append(frames, frame{data: data, duration: time.Duration(samples) * time.Second / 48000})
+	}
+	if len(frames) == 0 {
+		return nil, errors.New("no opus pages in " + path)
+	}
+	return frames, nil
+}
+
+// loadIVF reads the VP8 frames of an IVF file
+func loadIVF(path string) ([]frame, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ivf, header, err := ivfreader.NewWith(file)
+	if err != nil {
+		return nil, err
+	}
+	if header.FourCC != "VP80" {
+		return nil, errors.New("not a VP8 file: " + path)
+	}
+	duration := time.Duration(header.TimebaseNumerator) * time.Second / time.Duration(header.TimebaseDenominator)
+
+	var frames []frame
+	for {
+		data, _, err := ivf.ParseNextFrame()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame{data: data, duration: duration})
+	}
+	if len(frames) == 0 {
+		return nil, errors.New("no frames in " + path)
+	}
+	return frames, nil
+}
+
+// syntheticOpus frames of 20ms at about 32kbps, the SFU does not decode them
+func syntheticOpus() []frame {
+	frames := make([]frame, 50)
+	for i := range frames {
+		data := make([]byte, 80)
+		rand.Read(data)
+		// TOC of a 20ms CELT fullband frame
+		data[0] = 0xfc
+		frames[i] = frame{data: data, duration: 20 * time.Millisecond}
+	}
+	return frames
+}
+
+// syntheticVP8 frames at 30fps with a keyframe every 2 seconds. Only the
+// keyframe bit of the VP8 header is meaningful, the SFU does not decode them
+func syntheticVP8(bitrate int) []frame {
+	const fps = 30
+	size := bitrate / 8 / fps
+
+	frames := make([]frame, 2*fps)
+	for i := range frames {
+		n := size
+		if i == 0 {
+			n = size * 4
+		}
+		data := make([]byte, n)
+		rand.Read(data)
+		if i == 0 {
+			// keyframe with show_frame set and the VP8 start code
+			data[0] = 0x10
+			copy(data[3:], []byte{0x9d, 0x01, 0x2a})
+		} else {
+			data[0] = 0x11
+		}
+		frames[i] = frame{data: data, duration: time.Second / fps}
+	}
+	return frames
+}
+
+// sampleTrack is a TrackLocal looping frames. Unlike TrackLocalStaticSample
+// it sets the mid and rid header extensions that simulcast layers need
+type sampleTrack struct {
+	mu sync.Mutex
+
+	id, rid, streamID string
+	codec             webrtc.RTPCodecCapability
+	payloader         rtp.Payloader
+
+	mid        string
+	packetizer rtp.Packetizer
+	write      webrtc.TrackLocalWriter
+	midID      uint8
+	ridID      uint8
+}
+
+func newSampleTrack(codec webrtc.RTPCodecCapability, id, rid, streamID string) *sampleTrack {
+	var payloader rtp.Payloader = &codecs.OpusPayloader{}
+	if codec.MimeType == webrtc.MimeTypeVP8 {
+		payloader = &codecs.VP8Payloader{EnablePictureID: true}
+	}
+	return &sampleTrack{id: id, rid: rid, streamID: streamID, codec: codec, payloader: payloader}
+}
+
+// Bind implements webrtc.TrackLocal
+func (t *sampleTrack) Bind(ctx webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	for _, codec := range ctx.CodecParameters() {
+		if codec.MimeType != t.codec.MimeType {
+			continue
+		}
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		t.packetizer = rtp.NewPacketizer(mtu, uint8(codec.PayloadType), uint32(ctx.SSRC()), t.payloader, rtp.NewRandomSequencer(), codec.ClockRate)
+		t.write = ctx.WriteStream()
+		for _, extension := range ctx.HeaderExtensions() {
+			switch extension.URI {
+			case sdp.SDESMidURI:
+				t.midID = uint8(extension.ID)
+			case sdp.SDESRTPStreamIDURI:
+				t.ridID = uint8(extension.ID)
+			}
+		}
+		return codec, nil
+	}
+	return webrtc.RTPCodecParameters{}, webrtc.ErrUnsupportedCodec
+}
+
+// Unbind implements webrtc.TrackLocal
+func (t *sampleTrack) Unbind(webrtc.TrackLocalContext) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.write = nil
+	return nil
+}
+
+// ID implements webrtc.TrackLocal
+func (t *sampleTrack) ID() string { return t.id }
+
+// RID implements webrtc.TrackLocal
+func (t *sampleTrack) RID() string { return t.rid }
+
+// StreamID implements webrtc.TrackLocal
+func (t *sampleTrack) StreamID() string { return t.streamID }
+
+// Kind implements webrtc.TrackLocal
+func (t *sampleTrack) Kind() webrtc.RTPCodecType {
+	if t.codec.MimeType == webrtc.MimeTypeVP8 {
+		return webrtc.RTPCodecTypeVideo
+	}
+	return webrtc.RTPCodecTypeAudio
+}
+
+// SetMid of the transceiver once negotiated
+func (t *sampleTrack) SetMid(mid string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.mid = mid
+}
+
+// WriteFrame packetizes a frame, frames before Bind are dropped
+func (t *sampleTrack) WriteFrame(f frame) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.write == nil {
+		return nil
+	}
+
+	samples := uint32(f.duration.Seconds() * float64(t.codec.ClockRate))
+	for _, packet := range t.packetizer.Packetize(f.data, samples) {
+		if t.midID != 0 && t.mid != "" {
+			if err := packet.SetExtension(t.midID, []byte(t.mid)); err != nil {
+				return err
+			}
+		}
+		if t.ridID != 0 && t.rid != "" {
+			if err := packet.SetExtension(t.ridID, []byte(t.rid)); err != nil {
+				return err
+			}
+		}
+		if _, err := t.write.WriteRTP(&packet.Header, packet.Payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loop frames into tracks until done, all tracks share the frame clock.
+// A keyframe request restarts the frames, they start with a keyframe
+func loop(frames []frame, tracks []*sampleTrack, keyframe <-chan struct{}, done <-chan struct{}) {
+	next := time.Now()
+	for i := 0; ; i = (i + 1) % len(frames) {
+		for _, t := range tracks {
+			if err := t.WriteFrame(frames[i]); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+				return
+			}
+		}
+
+		next = next.Add(frames[i].duration)
+		select {
+		case <-done:
+			return
+		case <-keyframe:
+			i = -1
+			<-time.After(time.Until(next))
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockTicks per second of /proc/<pid>/stat times
+const clockTicks = 100
+
+// nodeStats of the clients of one node
+type nodeStats struct {
+	sync.Mutex
+
+	URL          string
+	joins        []time.Duration
+	joinFailures int
+	ttff         []time.Duration
+	expected     uint64
+	received     uint64
+	nacks        uint64
+	plis         uint64
+	cpu          *cpuSampler
+}
+
+func (s *nodeStats) addJoin(d time.Duration, err error) {
+	s.Lock()
+	defer s.Unlock()
+	if err != nil {
+		s.joinFailures++
+		return
+	}
+	s.joins = append(s.joins, d)
+}
+
+func (s *nodeStats) addTTFF(d time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.ttff = append(s.ttff, d)
+}
+
+func (s *nodeStats) addPackets(expected, received uint64) {
+	s.Lock()
+	defer s.Unlock()
+	s.expected += expected
+	s.received += received
+}
+
+func (s *nodeStats) addRTCP(nacks, plis uint64) {
+	s.Lock()
+	defer s.Unlock()
+	s.nacks += nacks
+	s.plis += plis
+}
+
+func (s *nodeStats) report(w io.Writer) {
+	s.Lock()
+	defer s.Unlock()
+
+	fmt.Fprintf(w, "node %s\n", s.URL)
+	fmt.Fprintf(w, "  joins          %d ok, %d failed\n", len(s.joins), s.joinFailures)
+	fmt.Fprintf(w, "  join latency   %s\n", percentiles(s.joins))
+	fmt.Fprintf(w, "  first frame    %s\n", percentiles(s.ttff))
+
+	loss := 0.0
+	if s.expected > 0 && s.received < s.expected {
+		loss = float64(s.expected-s.received) / float64(s.expected) * 100
+	}
+	fmt.Fprintf(w, "  packets        %d received, %.2f%% lost\n", s.received, loss)
+	fmt.Fprintf(w, "  nack/pli       %d/%d received by publishers\n", s.nacks, s.plis)
+	if s.cpu != nil {
+		fmt.Fprintf(w, "  cpu            %s\n", s.cpu)
+	}
+}
+
+// percentiles p50/p95/max of durations
+func percentiles(durations []time.Duration) string {
+	if len(durations) == 0 {
+		return "-"
+	}
+	sorted := append([]time.Duration{}, durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	p := func(q float64) time.Duration {
+		return sorted[int(q*float64(len(sorted)-1))].Round(time.Millisecond)
+	}
+	return fmt.Sprintf("p50 %v, p95 %v, max %v", p(0.5), p(0.95), sorted[len(sorted)-1].Round(time.Millisecond))
+}
+
+// sequence counts received and expected packets of an RTP stream
+type sequence struct {
+	started  bool
+	cycles   uint64
+	first    uint64
+	highest  uint16
+	received uint64
+}
+
+func (s *sequence) push(sn uint16) {
+	s.received++
+	if !s.started {
+		s.started = true
+		s.first = uint64(sn)
+		s.highest = sn
+		return
+	}
+	// newer packet, a wrap of the 16 bit sequence number starts a cycle
+	if diff := sn - s.highest; diff != 0 && diff < 1<<15 {
+		if sn < s.highest {
+			s.cycles += 1 << 16
+		}
+		s.highest = sn
+	}
+}
+
+func (s *sequence) expected() uint64 {
+	if !s.started {
+		return 0
+	}
+	return s.cycles + uint64(s.highest) - s.first + 1
+}
+
+// cpuSampler of a process by pid, linux only
+type cpuSampler struct {
+	mu    sync.Mutex
+	pid   string
+	start uint64
+	at    time.Time
+	last  uint64
+	lastA time.Time
+	peak  float64
+	err   error
+}
+
+func newCPUSampler(pid string) *cpuSampler {
+	s := &cpuSampler{pid: pid, at: time.Now(), lastA: time.Now()}
+	s.start, s.err = cpuTicks(pid)
+	s.last = s.start
+	return s
+}
+
+// sample the usage since the previous sample, the peak is kept
+func (s *cpuSampler) sample() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	ticks, err := cpuTicks(s.pid)
+	if err != nil {
+		s.err = err
+		return
+	}
+	now := time.Now()
+	if usage := cpuPercent(ticks-s.last, now.Sub(s.lastA)); usage > s.peak {
+		s.peak = usage
+	}
+	s.last, s.lastA = ticks, now
+}
+
+func (s *cpuSampler) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err.Error()
+	}
+	return fmt.Sprintf("%.1f%% avg, %.1f%% peak (pid %s)", cpuPercent(s.last-s.start, s.lastA.Sub(s.at)), s.peak, s.pid)
+}
+
+func cpuPercent(ticks uint64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(ticks) / clockTicks / d.Seconds() * 100
+}
+
+// cpuTicks utime + stime of a process
+func cpuTicks(pid string) (uint64, error) {
+	data, err := os.ReadFile("/proc/" + pid + "/stat")
+	if err != nil {
+		return 0, err
+	}
+	// the command in parentheses may contain spaces
+	fields := strings.Fields(string(data[strings.LastIndexByte(string(data), ')')+1:]))
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("unexpected /proc/%s/stat", pid)
+	}
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return utime + stime, nil
+}
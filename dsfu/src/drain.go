@@ -0,0 +1,147 @@
+package main
+
+import (
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/ton"
+	"github.com/rs/zerolog/log"
+	"hash/fnv"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// draining is set once the node refuses new participants
+var draining int32
+
+// IsDraining node, new participants are refused
+func IsDraining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+// Migration of a participant to another node, the token is signed by the
+// client backend for the node at URL
+type Migration struct {
+	URL       string `json:"url"`
+	Token     string `json:"token"`
+	Signature string `json:"signature"`
+}
+
+// Drain the node before shutdown. New participants are refused and the local
+// participants are asked to migrate to another registered node. Once they
+// left or timeout passed the calls are settled and the process exits
+func Drain(timeout time.Duration) {
+	if !atomic.CompareAndSwapInt32(&draining, 0, 1) {
+		return
+	}
+	log.Info().Dur("timeout", timeout).Msg("draining")
+
+	hosts, err := ton.GetOtherNodeHosts()
+	if err != nil {
+		log.Error().Err(err).Msg("drain")
+	}
+
+	Rooms.Range(func(_, ival interface{}) bool {
+		room, _ := ival.(*Room)
+		room.Migrate(migrationHost(room.SID, hosts))
+		return true
+	})
+
+	deadline := time.Now().Add(timeout)
+	for localParticipantsCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+	}
+
+	var wg sync.WaitGroup
+	Rooms.Range(func(_, ival interface{}) bool {
+		room, _ := ival.(*Room)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			room.drain()
+		}()
+		return true
+	})
+	wg.Wait()
+
+	log.Info().Msg("drained")
+	os.Exit(0)
+}
+
+// migrationHost of a room, every draining node sends the participants of a
+// room to the same host
+func migrationHost(SID string, hosts []string) string {
+	if len(hosts) == 0 {
+		return ""
+	}
+	h := fnv.New32a()
+	h.Write([]byte(SID))
+	return hosts[h.Sum32()%uint32(len(hosts))]
+}
+
+// localParticipantsCount of all rooms, viewers included
+func localParticipantsCount() int {
+	count := 0
+	Rooms.Range(func(_, ival interface{}) bool {
+		room, _ := ival.(*Room)
+		count += len(room.getLocalParticipants())
+		return true
+	})
+	return count
+}
+
+// Migrate the local participants to host, the client backend of the room
+// issues their tokens for the node of host
+func (r *Room) Migrate(host string) {
+	if r.NoBilling || host == "" {
+		log.Warn().Str("sid", r.SID).Msg("no node to migrate to")
+		return
+	}
+	for _, participant := range r.getLocalParticipants() {
+		go r.migrate(participant, host)
+	}
+}
+
+func (r *Room) migrate(participant *Participant, host string) {
+	notifyData := NotifyData{
+		SID:       r.SID,
+		CallID:    r.CallID,
+		UID:       participant.UID,
+		Type:      "migrate",
+		Host:      host,
+		NoPublish: participant.NoPublish,
+	}
+
+	var migration Migration
+	if err := r.callback(notifyData, &migration); err != nil {
+		log.Error().Err(err).Str("uid", participant.UID).Msg("migrate")
+		return
+	}
+	if err := participant.Notify("migrate", migration); err != nil {
+		log.Error().Err(err).Str("uid", participant.UID).Msg("migrate")
+	}
+}
+
+// drain closes the local participants who did not migrate, leaves the room
+// and settles the call
+func (r *Room) drain() {
+	for _, participant := range r.getLocalParticipants() {
+		participant.CloseConn()
+		participant.Close()
+	}
+	r.Node.LeaveRoom(r.SID)
+	r.Close()
+}
+
+// getLocalParticipants publishers and viewers of this node
+func (r *Room) getLocalParticipants() []*Participant {
+	nodeID := r.Node.ID().Pretty()
+	participants := make([]*Participant, 0)
+	r.OnlineParticipants.Range(func(_, ival interface{}) bool {
+		participant, _ := ival.(*Participant)
+		if participant.Host == nodeID {
+			participants = append(participants, participant)
+		}
+		return true
+	})
+	return participants
+}
@@ -1,4 +1,4 @@
-module main
+module github.com/dTelecom/hack-a-tonx/dsfu/src
 
 go 1.18
 
@@ -33,6 +33,7 @@ require (
 	github.com/libp2p/go-libp2p v0.22.0
 	github.com/libp2p/go-libp2p-kad-dht v0.20.0
 	github.com/libp2p/go-libp2p-pubsub v0.8.2
+	github.com/pion/interceptor v0.1.10
 	github.com/pion/stun v0.3.5
 	github.com/pkg/errors v0.9.1
 	github.com/spf13/viper v1.14.0
@@ -126,7 +127,6 @@ require (
 	github.com/pelletier/go-toml v1.9.5 // indirect
 	github.com/pelletier/go-toml/v2 v2.0.5 // indirect
 	github.com/pion/datachannel v1.5.2 // indirect
-	github.com/pion/interceptor v0.1.10 // indirect
 	github.com/pion/mdns v0.0.5 // indirect
 	github.com/pion/randutil v0.1.0 // indirect
 	github.com/pion/sctp v1.8.2 // indirect
@@ -2,49 +2,196 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
 	"crypto/tls"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/billing"
+	sfuLog "github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/logger"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/middlewares/datachannel"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/node"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/sfu"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/stats"
 	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
 	maddr "github.com/multiformats/go-multiaddr"
 	"github.com/pion/stun"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/sourcegraph/jsonrpc2"
 	websocketjsonrpc2 "github.com/sourcegraph/jsonrpc2/websocket"
 	"github.com/spf13/viper"
 	"golang.org/x/crypto/acme/autocert"
-	sfuLog "main/pkg/logger"
-	"main/pkg/middlewares/datachannel"
-	"main/pkg/node"
-	"main/pkg/sfu"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"os/user"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 )
 
+// Config for the dsfu node
+type Config struct {
+	sfu.Config `mapstructure:",squash"`
+	Server     ServerConfig    `mapstructure:"server"`
+	P2P        P2PConfig       `mapstructure:"p2p"`
+	Signal     SignalConfig    `mapstructure:"signal"`
+	Recording  RecordingConfig `mapstructure:"recording"`
+	LoadTest   LoadTestConfig  `mapstructure:"loadtest"`
+	Admin      AdminConfig     `mapstructure:"admin"`
+	Metrics    MetricsConfig   `mapstructure:"metrics"`
+	Drain      DrainConfig     `mapstructure:"drain"`
+	Billing    BillingConfig   `mapstructure:"billing"`
+}
+
+// ServerConfig of the signaling HTTP server
+type ServerConfig struct {
+	// TLS mode, "autocert" for Let's Encrypt certificates of domain, "static"
+	// for certfile and keyfile or "none" for plain HTTP behind a TLS proxy
+	TLS string `mapstructure:"tls"`
+	// Domain of the autocert certificates, the -d flag overrides it
+	Domain string `mapstructure:"domain"`
+	// CertCache directory of the autocert certificates, a temp directory if empty
+	CertCache string `mapstructure:"certcache"`
+	// CertFile and KeyFile in PEM of the static mode
+	CertFile string `mapstructure:"certfile"`
+	KeyFile  string `mapstructure:"keyfile"`
+	// Listen address of the server, TLS unless the mode is none
+	Listen string `mapstructure:"listen"`
+	// HTTPListen address answering the ACME challenges of the autocert mode
+	HTTPListen string `mapstructure:"httplisten"`
+	// WSPath of the JSON-RPC websocket
+	WSPath string `mapstructure:"wspath"`
+	// PublicIP announced in the ICE candidates, discovered with STUN if empty
+	PublicIP string `mapstructure:"publicip"`
+	// STUN servers the public IP is discovered with
+	STUN []string `mapstructure:"stun"`
+}
+
+// P2PConfig of the libp2p node
+type P2PConfig struct {
+	// Port of the libp2p TCP listener, the -n flag overrides it
+	Port uint `mapstructure:"port"`
+	// PrivateKey file of the node identity, generated if missing
+	PrivateKey string `mapstructure:"privatekey"`
+	// Bootstrap multiaddrs of the nodes to join the network with
+	Bootstrap []string `mapstructure:"bootstrap"`
+}
+
+// TLS modes of the signaling server
+const (
+	TLSAutocert = "autocert"
+	TLSStatic   = "static"
+	TLSNone     = "none"
+)
+
+// legacyPrivateKey file of the node identity before p2p.privatekey, kept so
+// the peer ID in the bootstrap addresses of the other nodes stays valid
+const legacyPrivateKey = ":57000libp2p-webrtc.privkey"
+
+// SignalConfig for the JSON-RPC signaling
+type SignalConfig struct {
+	// ResumeGracePeriod in seconds a participant is kept after its websocket dropped
+	ResumeGracePeriod int `mapstructure:"resumegraceperiod"`
+}
+
+// RecordingConfig for server side recordings
+type RecordingConfig struct {
+	// Dir recordings are written to
+	Dir string `mapstructure:"dir"`
+}
+
+// LoadTestConfig runs the node locally for the loadtest command, never enable it in production
+type LoadTestConfig struct {
+	// Enabled serves plain HTTP with loopback candidates and rooms are not billed
+	Enabled bool `mapstructure:"enabled"`
+	// Listen address of the plain HTTP server
+	Listen string `mapstructure:"listen"`
+	// ClientKey hex encoded ed25519 public key loadtest tokens are signed with
+	ClientKey string `mapstructure:"clientkey"`
+	// Bootstrap multiaddrs of the other local nodes
+	Bootstrap []string `mapstructure:"bootstrap"`
+}
+
+// AdminConfig for the admin HTTP API
+type AdminConfig struct {
+	// Listen address of the admin API, keep it private. Empty disables the API
+	Listen string `mapstructure:"listen"`
+	// Token of the Bearer authorization, ADMIN_TOKEN of the environment if not set
+	Token string `mapstructure:"token"`
+}
+
+// MetricsConfig for the prometheus endpoint
+type MetricsConfig struct {
+	// Listen address of /metrics, empty disables the endpoint
+	Listen string `mapstructure:"listen"`
+}
+
+// DrainConfig for shutting the node down, see Drain
+type DrainConfig struct {
+	// Timeout in seconds participants are given to migrate to another node
+	Timeout int `mapstructure:"timeout"`
+}
+
+// BillingConfig for the settlement of calls
+type BillingConfig struct {
+	// Journal directory of the billing journals of the calls, calls a crash
+	// left unsettled are settled on startup. Empty disables the journals
+	Journal string `mapstructure:"journal"`
+}
+
+// loadTestSinglePort of the ICE UDP mux, loopback candidates need a mux bound to all addresses
+const loadTestSinglePort = 5000
+
 var (
 	file     string
-	conf     = sfu.Config{}
+	conf     = Config{}
 	nodePort *uint
 	domain   string
 )
 
+// setDefaults of the settings missing in older config files. Every setting
+// can be overridden by the environment, DSFU_SERVER_TLS for server.tls
+func setDefaults() {
+	viper.SetDefault("server.tls", TLSAutocert)
+	viper.SetDefault("server.domain", "")
+	viper.SetDefault("server.certcache", "")
+	viper.SetDefault("server.certfile", "")
+	viper.SetDefault("server.keyfile", "")
+	viper.SetDefault("server.listen", ":https")
+	viper.SetDefault("server.httplisten", ":http")
+	viper.SetDefault("server.wspath", "/ws")
+	viper.SetDefault("server.publicip", "")
+	viper.SetDefault("server.stun", []string{"stun.l.google.com:19302"})
+	viper.SetDefault("p2p.port", 6666)
+	viper.SetDefault("p2p.privatekey", "libp2p-webrtc.privkey")
+	viper.SetDefault("p2p.bootstrap", []string{
+		"/ip4/141.95.127.30/tcp/6666/p2p/12D3KooWR5szoBtZEb7VJnD6ize6EjPNbt1Lo7YytCDW5EjV8Zae",
+		"/ip4/51.195.202.15/tcp/6666/p2p/12D3KooWCviAPtTK2Tjkdgxagg6ek6sp7mg1ZTX6N6WhMmHvd55K",
+	})
+	viper.SetDefault("billing.journal", "billing")
+
+	viper.SetEnvPrefix("dsfu")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+}
+
 func showHelp() {
 	fmt.Printf("Usage:%s {params}\n", os.Args[0])
-	fmt.Println("      -n {p2p listen port}")
+	fmt.Println("      -n {p2p listen port, p2p.port of the config}")
 	fmt.Println("      -c {config file}")
-	fmt.Println("      -d (domain)")
+	fmt.Println("      -d (domain, server.domain of the config)")
 	fmt.Println("      -h (show help info)")
 }
 
 func parse() bool {
-	nodePort = flag.Uint("n", 6666, "node port")
+	nodePort = flag.Uint("n", 0, "node port")
 	flag.StringVar(&file, "c", "config.toml", "config file")
 	flag.StringVar(&domain, "d", "", "domain")
 	help := flag.Bool("h", false, "help info")
@@ -64,6 +211,7 @@ func load() bool {
 
 	viper.SetConfigFile(file)
 	viper.SetConfigType("toml")
+	setDefaults()
 
 	err = viper.ReadInConfig()
 	if err != nil {
@@ -74,6 +222,13 @@ func load() bool {
 		return false
 	}
 
+	if *nodePort != 0 {
+		conf.P2P.Port = *nodePort
+	}
+	if domain != "" {
+		conf.Server.Domain = domain
+	}
+
 	return true
 }
 
@@ -99,42 +254,31 @@ func main() {
 
 	sfu.Logger = sfuLog.New()
 
-	ip, err := GetExternalIP(context.Background(), []string{"stun.l.google.com:19302"})
-	if err != nil {
-		panic(err)
-	}
-	conf.WebRTC.Candidates.NAT1To1IPs = []string{ip}
-
-	s := sfu.NewSFU(conf)
-	dc := s.NewDatachannel(sfu.APIChannelLabel)
-	dc.Use(datachannel.SubscriberAPI)
-
-	certManager := autocert.Manager{
-		Prompt:     autocert.AcceptTOS,
-		HostPolicy: autocert.HostWhitelist(domain),
-	}
-
-	dir := cacheDir()
-	if dir != "" {
-		certManager.Cache = autocert.DirCache(dir)
-	}
+	if conf.LoadTest.Enabled {
+		clientKey, err := hex.DecodeString(conf.LoadTest.ClientKey)
+		if err != nil || len(clientKey) != ed25519.PublicKeySize {
+			panic(fmt.Errorf("loadtest clientkey: invalid ed25519 public key"))
+		}
+		LoadTestClientKey = clientKey
 
-	server := &http.Server{
-		Addr: ":https",
-		TLSConfig: &tls.Config{
-			GetCertificate: certManager.GetCertificate,
-		},
-	}
-	log.Printf("Serving http/https for domains: %+v", domain)
-	go func() {
-		// serve HTTP, which will redirect automatically to HTTPS
-		h := certManager.HTTPHandler(nil)
-		err := http.ListenAndServe(":http", h)
+		if conf.WebRTC.ICESinglePort == 0 {
+			conf.WebRTC.ICESinglePort = loadTestSinglePort
+		}
+		conf.WebRTC.Candidates.NAT1To1IPs = []string{"127.0.0.1"}
+		log.Printf("load test mode on %v, ice port %v", conf.LoadTest.Listen, conf.WebRTC.ICESinglePort)
+	} else if conf.Server.PublicIP != "" {
+		conf.WebRTC.Candidates.NAT1To1IPs = []string{conf.Server.PublicIP}
+	} else {
+		ip, err := GetExternalIP(context.Background(), conf.Server.STUN)
 		if err != nil {
 			panic(err)
 		}
+		conf.WebRTC.Candidates.NAT1To1IPs = []string{ip}
+	}
 
-	}()
+	s := sfu.NewSFU(conf.Config)
+	dc := s.NewDatachannel(sfu.APIChannelLabel)
+	dc.Use(datachannel.SubscriberAPI)
 
 	upgrader := websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
@@ -146,23 +290,29 @@ func main() {
 
 	ctx := context.Background()
 
-	n := node.NewNode(":57000" + "libp2p-webrtc.privkey")
-	if err := n.Start(ctx, uint16(*nodePort)); err != nil {
+	n := node.NewNode(privateKeyFile())
+	if err := n.Start(ctx, uint16(conf.P2P.Port)); err != nil {
 		panic(err)
 	}
 
+	bootstrap := conf.P2P.Bootstrap
+	if conf.LoadTest.Enabled {
+		bootstrap = conf.LoadTest.Bootstrap
+	}
 	var bootstrapNodes []maddr.Multiaddr
-	addr1, _ := maddr.NewMultiaddr("/ip4/141.95.127.30/tcp/6666/p2p/12D3KooWR5szoBtZEb7VJnD6ize6EjPNbt1Lo7YytCDW5EjV8Zae")
-	addr2, _ := maddr.NewMultiaddr("/ip4/51.195.202.15/tcp/6666/p2p/12D3KooWCviAPtTK2Tjkdgxagg6ek6sp7mg1ZTX6N6WhMmHvd55K")
-
-	bootstrapNodes = append(bootstrapNodes, addr1)
-	bootstrapNodes = append(bootstrapNodes, addr2)
+	for _, b := range bootstrap {
+		addr, err := maddr.NewMultiaddr(b)
+		if err != nil {
+			panic(err)
+		}
+		bootstrapNodes = append(bootstrapNodes, addr)
+	}
 
 	if err := n.Bootstrap(ctx, bootstrapNodes); err != nil {
 		log.Error().Err(err).Msg("bootstrap")
 	}
 
-	http.Handle("/ws", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	http.Handle(conf.Server.WSPath, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		c, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
@@ -172,18 +322,124 @@ func main() {
 		defer c.Close()
 
 		p := NewParticipant(sfu.NewPeer(s), n)
-		defer p.Close()
 
 		jc := jsonrpc2.NewConn(r.Context(), websocketjsonrpc2.NewObjectStream(c), p)
 		<-jc.DisconnectNotify()
+		p.Disconnect(jc)
 	}))
 
-	err = server.ListenAndServeTLS("", "")
-	if err != nil {
+	http.Handle("/whip/", &HTTPSessionHandler{Kind: WHIP, SFU: s, Node: n})
+	http.Handle("/whep/", &HTTPSessionHandler{Kind: WHEP, SFU: s, Node: n})
+
+	if conf.Billing.Journal != "" && !conf.LoadTest.Enabled {
+		// journals of the previous runs, the rooms of this one open theirs once served
+		replays, err := billing.Load(conf.Billing.Journal)
+		if err != nil {
+			log.Error().Err(err).Msg("journal")
+		}
+		go SettleJournals(replays)
+	}
+
+	drainTimeout := time.Duration(conf.Drain.Timeout) * time.Second
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	go func() {
+		<-signals
+		Drain(drainTimeout)
+	}()
+
+	if conf.Admin.Token == "" {
+		conf.Admin.Token = os.Getenv("ADMIN_TOKEN")
+	}
+	if conf.Admin.Listen != "" && conf.Admin.Token != "" {
+		go func() {
+			err := http.ListenAndServe(conf.Admin.Listen, &AdminHandler{Token: conf.Admin.Token, SFU: s, Node: n, DrainTimeout: drainTimeout})
+			if err != nil {
+				log.Error().Err(err).Msg("admin")
+			}
+		}()
+	}
+
+	if conf.Metrics.Listen != "" {
+		stats.InitNodeStats(&RoomCollector{Node: n})
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		go func() {
+			err := http.ListenAndServe(conf.Metrics.Listen, mux)
+			if err != nil {
+				log.Error().Err(err).Msg("metrics")
+			}
+		}()
+	}
+
+	if err := serve(); err != nil {
 		panic(err)
 	}
 }
 
+// serve the signaling server in the TLS mode of the config, the load test
+// serves plain HTTP on its own address
+func serve() error {
+	if conf.LoadTest.Enabled {
+		return http.ListenAndServe(conf.LoadTest.Listen, nil)
+	}
+
+	switch conf.Server.TLS {
+	case TLSNone:
+		log.Printf("Serving http on %v", conf.Server.Listen)
+		return http.ListenAndServe(conf.Server.Listen, nil)
+	case TLSStatic:
+		log.Printf("Serving https on %v with %v", conf.Server.Listen, conf.Server.CertFile)
+		return http.ListenAndServeTLS(conf.Server.Listen, conf.Server.CertFile, conf.Server.KeyFile, nil)
+	case TLSAutocert:
+		certManager := autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(conf.Server.Domain),
+		}
+
+		dir := conf.Server.CertCache
+		if dir == "" {
+			dir = cacheDir()
+		}
+		if dir != "" {
+			certManager.Cache = autocert.DirCache(dir)
+		}
+
+		server := &http.Server{
+			Addr: conf.Server.Listen,
+			TLSConfig: &tls.Config{
+				GetCertificate: certManager.GetCertificate,
+			},
+		}
+
+		log.Printf("Serving http/https for domains: %+v", conf.Server.Domain)
+		go func() {
+			// serve HTTP, which will redirect automatically to HTTPS
+			h := certManager.HTTPHandler(nil)
+			err := http.ListenAndServe(conf.Server.HTTPListen, h)
+			if err != nil {
+				panic(err)
+			}
+		}()
+
+		return server.ListenAndServeTLS("", "")
+	default:
+		return fmt.Errorf("unknown tls mode: %v", conf.Server.TLS)
+	}
+}
+
+// privateKeyFile of the node identity, the legacy file is used as long as
+// the configured one does not exist
+func privateKeyFile() string {
+	if _, err := os.Stat(conf.P2P.PrivateKey); os.IsNotExist(err) {
+		if _, err := os.Stat(legacyPrivateKey); err == nil {
+			log.Warn().Str("file", legacyPrivateKey).Msg("legacy private key, rename it to p2p.privatekey")
+			return legacyPrivateKey
+		}
+	}
+	return conf.P2P.PrivateKey
+}
+
 func cacheDir() (dir string) {
 	if u, _ := user.Current(); u != nil {
 		dir = filepath.Join(os.TempDir(), "cache-golang-autocert-"+u.Username)
@@ -194,13 +450,28 @@ func cacheDir() (dir string) {
 	return ""
 }
 
-// GetExternalIP return external IP for localAddr from stun server.
+// GetExternalIP return external IP for localAddr from the first stun server
+// answering, the next server is tried when one fails.
 func GetExternalIP(ctx context.Context, stunServers []string) (string, error) {
 	if len(stunServers) == 0 {
 		return "", errors.New("STUN servers are required but not defined")
 	}
+	var err error
+	for _, stunServer := range stunServers {
+		var ip string
+		ip, err = getExternalIP(ctx, stunServer)
+		if err == nil {
+			return ip, nil
+		}
+		log.Printf("STUN server %v: %v", stunServer, err)
+	}
+	return "", err
+}
+
+// getExternalIP from one stun server
+func getExternalIP(ctx context.Context, stunServer string) (string, error) {
 	dialer := &net.Dialer{}
-	conn, err := dialer.Dial("udp4", stunServers[0])
+	conn, err := dialer.Dial("udp4", stunServer)
 	if err != nil {
 		return "", err
 	}
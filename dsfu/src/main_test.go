@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/pion/stun"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newStunServer answering binding requests with ip
+func newStunServer(t *testing.T, ip net.IP) string {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			request := &stun.Message{Raw: append([]byte{}, buf[:n]...)}
+			if err := request.Decode(); err != nil {
+				continue
+			}
+			response, err := stun.Build(stun.NewTransactionIDSetter(request.TransactionID), stun.BindingSuccess,
+				&stun.XORMappedAddress{IP: ip, Port: 3478})
+			if err != nil {
+				continue
+			}
+			_, _ = conn.WriteTo(response.Raw, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestGetExternalIP(t *testing.T) {
+	server := newStunServer(t, net.IPv4(203, 0, 113, 7))
+
+	ip, err := GetExternalIP(context.Background(), []string{server})
+	require.NoError(t, err)
+	assert.Equal(t, "203.0.113.7", ip)
+
+	// a failing server falls back to the next one
+	ip, err = GetExternalIP(context.Background(), []string{"127.0.0.1", server})
+	require.NoError(t, err)
+	assert.Equal(t, "203.0.113.7", ip)
+
+	_, err = GetExternalIP(context.Background(), []string{"127.0.0.1"})
+	assert.Error(t, err)
+	_, err = GetExternalIP(context.Background(), nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/node"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	roomsDesc = prometheus.NewDesc("dsfu_rooms", "Current number of rooms", nil, nil)
+
+	participantsDesc = prometheus.NewDesc("dsfu_participants", "Current number of publishing participants by room, local to this node or remote",
+		[]string{"room", "location"}, nil)
+
+	viewersDesc = prometheus.NewDesc("dsfu_viewers", "Current number of viewers by room, local to this node or remote",
+		[]string{"room", "location"}, nil)
+
+	relayPeersDesc = prometheus.NewDesc("dsfu_relay_peers", "Current number of relay peers by room",
+		[]string{"room"}, nil)
+)
+
+// RoomCollector collects the room gauges at scrape time from Rooms
+type RoomCollector struct {
+	Node node.Node
+}
+
+// Describe implements prometheus.Collector
+func (c *RoomCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- roomsDesc
+	ch <- participantsDesc
+	ch <- viewersDesc
+	ch <- relayPeersDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *RoomCollector) Collect(ch chan<- prometheus.Metric) {
+	nodeID := c.Node.ID().Pretty()
+	rooms := 0
+
+	Rooms.Range(func(_, ival interface{}) bool {
+		room, _ := ival.(*Room)
+		rooms++
+
+		var localParticipants, remoteParticipants, localViewers, remoteViewers int
+		room.OnlineParticipants.Range(func(_, ival interface{}) bool {
+			participant, _ := ival.(*Participant)
+			switch {
+			case participant.Host != nodeID:
+				remoteParticipants++
+			case participant.IsViewer():
+			default:
+				localParticipants++
+			}
+			return true
+		})
+		localViewers = room.GetLocalViewersCount()
+		// remote viewers are only counted by their nodes
+		room.RemoteViewersCount.Range(func(_, ival interface{}) bool {
+			remoteViewers += ival.(int)
+			return true
+		})
+
+		relayPeers := 0
+		if room.Session != nil {
+			relayPeers = len(room.Session.RelayPeers())
+		}
+
+		ch <- prometheus.MustNewConstMetric(participantsDesc, prometheus.GaugeValue, float64(localParticipants), room.SID, "local")
+		ch <- prometheus.MustNewConstMetric(participantsDesc, prometheus.GaugeValue, float64(remoteParticipants), room.SID, "remote")
+		ch <- prometheus.MustNewConstMetric(viewersDesc, prometheus.GaugeValue, float64(localViewers), room.SID, "local")
+		ch <- prometheus.MustNewConstMetric(viewersDesc, prometheus.GaugeValue, float64(remoteViewers), room.SID, "remote")
+		ch <- prometheus.MustNewConstMetric(relayPeersDesc, prometheus.GaugeValue, float64(relayPeers), room.SID)
+		return true
+	})
+
+	ch <- prometheus.MustNewConstMetric(roomsDesc, prometheus.GaugeValue, float64(rooms))
+}
@@ -3,18 +3,29 @@ package main
 import (
 	"context"
 	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/call"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/node"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/roomstate"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/rpcerror"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/sfu"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/stats"
+	"github.com/lucsky/cuid"
 	"github.com/pion/webrtc/v3"
 	"github.com/rs/zerolog/log"
 	"github.com/sourcegraph/jsonrpc2"
-	"main/pkg/node"
-	"main/pkg/sfu"
-	"main/pkg/ton"
+	"strings"
+	"sync"
 	"time"
 )
 
+// maxChatMessageLength in bytes
+const maxChatMessageLength = 4096
+
 // Participant participant
 type Participant struct {
 	Peer *sfu.PeerLocal `json:"-"`
@@ -23,19 +34,41 @@ type Participant struct {
 	SID        string `json:"sid"`
 	UID        string `json:"uid"`
 	Name       string `json:"name"`
-	StreamID   string `json:"streamID"`
+	StreamID   string `json:"streamID"` // primary stream, see Publications
 	IsHost     bool   `json:"isHost"`
 	Host       string `json:"host"`
 	NoPublish  bool   `json:"noPublish"`
 	AudioMuted bool   `json:"audioMuted"`
 	VideoMuted bool   `json:"videoMuted"`
 
+	ForceAudioMuted bool `json:"forceAudioMuted"`
+	ForceVideoMuted bool `json:"forceVideoMuted"`
+	HandRaised      bool `json:"handRaised"`
+
+	Publications []*Publication `json:"publications"`
+
 	AddedAt   time.Time `json:"-"`
 	RemovedAt time.Time `json:"-"`
 
-	ctx     context.Context `json:"-"`
-	conn    *jsonrpc2.Conn  `json:"-"`
-	relayed map[string]bool `json:"-"`
+	ResumeSecret string `json:"-"`
+
+	mu             sync.Mutex
+	ctx            context.Context `json:"-"`
+	conn           *jsonrpc2.Conn  `json:"-"`
+	relayed        map[string]bool `json:"-"`
+	closed         bool
+	disconnectedAt time.Time
+	resumeTimer    *time.Timer
+	resumed        *Participant
+	sources        map[string]string
+	// roleAt the participant joined, was promoted or demoted, publishers are
+	// billed from it
+	roleAt time.Time
+
+	// roomMu orders entering and leaving the room, a participant closed
+	// while joining never enters it
+	roomMu  sync.Mutex
+	entered bool
 }
 
 // NewParticipant create new JSONSignal
@@ -53,23 +86,62 @@ type JoinRequest struct {
 	Signature string                    `json:"signature"`
 	Name      string                    `json:"name"`
 	Offer     webrtc.SessionDescription `json:"offer"`
+	// NoAutoSubscribe participants pull streams with subscribe
+	NoAutoSubscribe bool `json:"noAutoSubscribe"`
+	// Sources of the offered streams by stream ID, camera or screen
+	Sources map[string]string `json:"sources,omitempty"`
+}
+
+// JoinResponse message sent back on join
+type JoinResponse struct {
+	*webrtc.SessionDescription
+	ResumeSecret string         `json:"resumeSecret"`
+	ChatHistory  []*ChatMessage `json:"chatHistory"`
+	Recording    bool           `json:"recording"`
+}
+
+// ResumeRequest message sent to rebind a new connection to a dropped participant
+type ResumeRequest struct {
+	SID          string `json:"sid"`
+	UID          string `json:"uid"`
+	ResumeSecret string `json:"resumeSecret"`
 }
 
-// Token model
-type Token struct {
-	SID           string `json:"sid"`
-	UID           string `json:"uid"`
-	Name          string `json:"name"`
-	IsHost        bool   `json:"isHost"`
-	ClientAddress string `json:"clientAddress"`
-	URL           string `json:"url"`
-	CallID        string `json:"callID"`
-	NoPublish     bool   `json:"noPublish"`
+// ResumeResponse message sent back on resume
+type ResumeResponse struct {
+	// IceRestart asks the client to send a publisher offer with new ICE credentials
+	IceRestart bool `json:"iceRestart"`
+}
+
+// ModerationRequest message sent by a host to moderate another participant
+type ModerationRequest struct {
+	UID   string `json:"uid"`
+	Kind  string `json:"kind"`
+	Muted bool   `json:"muted"`
+}
+
+// RaiseHandRequest message sent by a participant asking to speak
+type RaiseHandRequest struct {
+	Raised bool `json:"raised"`
+}
+
+// SubscribeRequest message sent to subscribe or unsubscribe streams,
+// streams can be given by their ID or by participant UID
+type SubscribeRequest struct {
+	StreamIDs []string `json:"streamIds"`
+	UIDs      []string `json:"uids"`
+}
+
+// ChatSendRequest message sent by a participant to the room chat
+type ChatSendRequest struct {
+	Text string `json:"text"`
 }
 
 // WebrtcNegotiation message sent when renegotiating the peer connection
 type WebrtcNegotiation struct {
 	Desc webrtc.SessionDescription `json:"desc"`
+	// Sources of the offered streams by stream ID, camera or screen
+	Sources map[string]string `json:"sources,omitempty"`
 }
 
 // WebrtcTrickle message sent when renegotiating the peer connection
@@ -86,12 +158,28 @@ type MuteEvent struct {
 
 // Handle incoming RPC call events like join, answer, offer and trickle
 func (p *Participant) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+	if resumed := p.resumedParticipant(); resumed != nil {
+		resumed.Handle(ctx, conn, req)
+		return
+	}
+
 	replyError := func(err error) {
 		log.Error().Err(err).Msg("replyError")
-		_ = conn.ReplyWithError(ctx, req.ID, &jsonrpc2.Error{
-			Code:    500,
-			Message: fmt.Sprintf("%s", err),
-		})
+		_ = conn.ReplyWithError(ctx, req.ID, rpcerror.Reply(err))
+	}
+
+	// joinFailed counts the failed join by reason
+	joinFailed := func(reason string, err error) {
+		stats.JoinFailures.WithLabelValues(reason).Inc()
+		replyError(err)
+	}
+
+	// joinAborted undoes a join which failed once its token was accepted, the
+	// token can be used again
+	joinAborted := func(token *Token, reason string, err error) {
+		token.Release()
+		p.abortJoin()
+		joinFailed(reason, err)
 	}
 
 	log.Info().Str("message", req.Method).Msg("received")
@@ -99,90 +187,50 @@ func (p *Participant) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *json
 	switch req.Method {
 	case "join":
 		if p.UID != "" {
-			err := fmt.Errorf("already joined")
-			replyError(err)
+			err := rpcerror.ErrAlreadyJoined
+			joinFailed("already_joined", err)
+			break
+		}
+		if IsDraining() {
+			joinFailed("draining", rpcerror.ErrDraining)
 			break
 		}
 
 		var joinRequest JoinRequest
 		err := json.Unmarshal(*req.Params, &joinRequest)
 		if err != nil {
-			replyError(err)
+			joinFailed("bad_request", err)
 			break
 		}
 
 		log.Printf("got joinRequest: %v", joinRequest)
 
-		tokenJson, err := base64.StdEncoding.DecodeString(joinRequest.Token)
+		token, room, clientPk, err := VerifyToken(joinRequest.Token, joinRequest.Signature)
 		if err != nil {
-			replyError(err)
+			joinFailed("token", err)
 			break
 		}
 
-		signature, err := base64.StdEncoding.DecodeString(joinRequest.Signature)
-		if err != nil {
-			replyError(err)
-			break
-		}
+		p.SetToken(token)
+		p.mu.Lock()
+		p.ctx = ctx
+		p.conn = conn
+		p.mu.Unlock()
 
-		var token Token
-		err = json.Unmarshal(tokenJson, &token)
+		p.ResumeSecret, err = newResumeSecret()
 		if err != nil {
-			replyError(err)
+			joinAborted(token, "internal", err)
 			break
 		}
-		log.Printf("got token: %v", token)
-
-		var room *Room
-		if ival, ok := Rooms.Load(token.SID); ok {
-			room, _ = ival.(*Room)
-			if room.IsClosed() {
-				replyError(fmt.Errorf("room closed"))
-				break
-			}
-		}
-
-		var clientPk ed25519.PublicKey
-		if room != nil {
-			clientPk = room.ClientPk
-		} else {
-			clientPk, err = ton.GetClientPubKey(token.ClientAddress)
-			log.Printf("GetClientPubKey: %v, %v, %v", token.ClientAddress, clientPk, err)
-			if err != nil {
-				replyError(err)
-				break
-			}
-		}
-
-		verified := ton.VerifyMessage(clientPk, tokenJson, signature)
-		if verified != true {
-			replyError(fmt.Errorf("not verified signature"))
-			break
-		}
-
-		log.Printf("verified: %v", verified)
-
-		p.UID = token.UID
-		p.SID = token.SID
-		p.Name = token.Name
-		p.IsHost = token.IsHost
-
-		p.ctx = ctx
-		p.conn = conn
-		p.Host = p.Node.ID().Pretty()
-		p.AddedAt = time.Now()
-		p.NoPublish = token.NoPublish
-		p.AudioMuted = true
-		p.VideoMuted = true
 
 		p.Peer.OnOffer = func(offer *webrtc.SessionDescription) {
-			if err := conn.Notify(ctx, "offer", offer); err != nil {
+			if err := p.Notify("offer", offer); err != nil {
 				log.Error().Err(err).Msg("join")
 			}
 		}
 
 		p.Peer.OnIceCandidate = func(candidate *webrtc.ICECandidateInit, target int) {
-			if err := conn.Notify(ctx, "trickle", WebrtcTrickle{
+			if err := p.Notify("trickle", WebrtcTrickle{
 				Candidate: *candidate,
 				Target:    target,
 			}); err != nil {
@@ -190,62 +238,119 @@ func (p *Participant) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *json
 			}
 		}
 
+		// viewers only get a subscriber transport
 		joinConfig := sfu.JoinConfig{
-			NoPublish:       false,
+			NoPublish:       p.NoPublish,
 			NoSubscribe:     false,
-			NoAutoSubscribe: false,
+			NoAutoSubscribe: joinRequest.NoAutoSubscribe,
 		}
 
 		err = p.Peer.Join(p.SID, p.UID, joinConfig)
 		if err != nil {
-			replyError(err)
+			joinAborted(token, "peer", err)
 			break
 		}
 
-		answer, err := p.Peer.Answer(joinRequest.Offer)
+		err = p.SetSources(joinRequest.Sources)
 		if err != nil {
-			replyError(err)
+			joinAborted(token, "sources", err)
 			break
 		}
 
-		if err := conn.Reply(ctx, req.ID, answer); err != nil {
+		// the offer is optional for viewers, the subscriber offer is pushed to them
+		var answer *webrtc.SessionDescription
+		if joinRequest.Offer.SDP != "" {
+			answer, err = p.Peer.Answer(joinRequest.Offer)
+			if err != nil {
+				joinAborted(token, "answer", err)
+				break
+			}
+		}
+
+		joinResponse := JoinResponse{
+			SessionDescription: answer,
+			ResumeSecret:       p.ResumeSecret,
+			ChatHistory:        []*ChatMessage{},
+		}
+		if room != nil {
+			joinResponse.ChatHistory = room.GetChatHistory()
+			joinResponse.Recording = room.IsRecording()
+		}
+		if err := conn.Reply(ctx, req.ID, joinResponse); err != nil {
 			log.Error().Err(err).Msg("join")
 		}
 
-		if room == nil {
-			room = &Room{
-				SID:           token.SID,
-				Session:       p.Peer.Session(),
-				Node:          p.Node,
-				ClientAddress: token.ClientAddress,
-				ClientPk:      clientPk,
-				URL:           token.URL,
-				CallID:        token.CallID,
-				createdChan:   make(chan struct{}),
-			}
-			Rooms.Store(token.SID, room)
-			err := p.Node.JoinRoom(token.SID, p.Node.ID().Pretty(), room.OnRemoteMessage)
-			if err != nil {
-				log.Error().Err(err).Msg("JoinRoom")
-			}
+		room = p.EnterRoom(token, room, clientPk)
 
-			go room.observer()
+		if err := conn.Notify(ctx, "participants", room.GetPublishParticipants()); err != nil {
+			log.Error().Err(err).Msg("join")
+		}
+		if err := conn.Notify(ctx, "activeSpeakers", room.GetActiveSpeakers()); err != nil {
+			log.Error().Err(err).Msg("join")
 		}
 
-		onPublisherTrack := func(track sfu.PublisherTrack) {
-			if p.StreamID != track.Track.StreamID() {
-				p.StreamID = track.Track.StreamID()
-				room.OnStream(p)
-			}
+	case "resume":
+		if p.UID != "" {
+			err := rpcerror.ErrAlreadyJoined
+			replyError(err)
+			break
+		}
+
+		var resumeRequest ResumeRequest
+		err := json.Unmarshal(*req.Params, &resumeRequest)
+		if err != nil {
+			replyError(err)
+			break
+		}
+
+		var room *Room
+		if ival, ok := Rooms.Load(resumeRequest.SID); ok {
+			room, _ = ival.(*Room)
+		} else {
+			err := rpcerror.ErrRoomNotFound
+			replyError(err)
+			break
 		}
 
-		p.Peer.Publisher().OnPublisherTrack(onPublisherTrack)
+		var participant *Participant
+		if ival, ok := room.OnlineParticipants.Load(resumeRequest.UID); ok {
+			participant, _ = ival.(*Participant)
+		}
+		if participant == nil || participant.Host != p.Node.ID().Pretty() ||
+			subtle.ConstantTimeCompare([]byte(participant.ResumeSecret), []byte(resumeRequest.ResumeSecret)) != 1 {
+			err := rpcerror.ErrSessionNotFound
+			replyError(err)
+			break
+		}
 
-		room.OnlineParticipants.Store(token.UID, p)
-		room.OnJoin(p)
+		err = participant.Resume(conn)
+		if err != nil {
+			replyError(err)
+			break
+		}
+
+		// the fresh peer of this connection was never joined
+		p.Peer.Close()
+		p.mu.Lock()
+		p.resumed = participant
+		p.mu.Unlock()
+
+		if err := conn.Reply(ctx, req.ID, ResumeResponse{IceRestart: true}); err != nil {
+			log.Error().Err(err).Msg("resume")
+		}
 
 		if err := conn.Notify(ctx, "participants", room.GetPublishParticipants()); err != nil {
-			log.Error().Err(err).Msg("join")
+			log.Error().Err(err).Msg("resume")
+		}
+		if err := conn.Notify(ctx, "participantsCount", room.GetAllCountJson()); err != nil {
+			log.Error().Err(err).Msg("resume")
+		}
+		if err := conn.Notify(ctx, "activeSpeakers", room.GetActiveSpeakers()); err != nil {
+			log.Error().Err(err).Msg("resume")
+		}
+
+		if err := participant.Peer.RestartICE(); err != nil {
+			log.Error().Err(err).Msg("resume")
 		}
 
 	case "offer":
@@ -257,6 +362,12 @@ func (p *Participant) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *json
 			break
 		}
 
+		err = p.SetSources(webrtcNegotiation.Sources)
+		if err != nil {
+			replyError(err)
+			break
+		}
+
 		answer, err := p.Peer.Answer(webrtcNegotiation.Desc)
 		if err != nil {
 			replyError(err)
@@ -303,15 +414,9 @@ func (p *Participant) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *json
 			replyError(err)
 			break
 		}
-		if muteEvent.Kind == "audio" {
-			p.AudioMuted = muteEvent.Muted
-		}
-		if muteEvent.Kind == "video" {
-			p.VideoMuted = muteEvent.Muted
-		}
 
 		if p.UID == "" {
-			err := fmt.Errorf("not joined")
+			err := rpcerror.ErrNotJoined
 			replyError(err)
 			break
 		}
@@ -319,15 +424,20 @@ func (p *Participant) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *json
 		if ival, ok := Rooms.Load(p.SID); ok {
 			room, _ = ival.(*Room)
 		} else {
-			err := fmt.Errorf("room not found")
+			err := rpcerror.ErrRoomNotFound
 			replyError(err)
 			break
 		}
 
-		room.Broadcast(p, req.Method, *req.Params)
+		// the state is broadcast with the participant, set it first
+		if err := p.SetMuted(muteEvent.Kind, muteEvent.Muted); err != nil {
+			replyError(err)
+			break
+		}
+		room.BroadcastState(p, req.Method, *req.Params)
 	case "end":
 		if p.UID == "" {
-			err := fmt.Errorf("not joined")
+			err := rpcerror.ErrNotJoined
 			replyError(err)
 			break
 		}
@@ -335,7 +445,7 @@ func (p *Participant) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *json
 		if ival, ok := Rooms.Load(p.SID); ok {
 			room, _ = ival.(*Room)
 		} else {
-			err := fmt.Errorf("room not found")
+			err := rpcerror.ErrRoomNotFound
 			replyError(err)
 			break
 		}
@@ -344,16 +454,561 @@ func (p *Participant) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *json
 		} else {
 			room.OnEnd(p)
 		}
+
+	case "raiseHand":
+		if p.UID == "" {
+			err := rpcerror.ErrNotJoined
+			replyError(err)
+			break
+		}
+		var room *Room
+		if ival, ok := Rooms.Load(p.SID); ok {
+			room, _ = ival.(*Room)
+		} else {
+			err := rpcerror.ErrRoomNotFound
+			replyError(err)
+			break
+		}
+
+		var raiseHandRequest RaiseHandRequest
+		err := json.Unmarshal(*req.Params, &raiseHandRequest)
+		if err != nil {
+			replyError(err)
+			break
+		}
+
+		p.mu.Lock()
+		p.HandRaised = raiseHandRequest.Raised
+		p.mu.Unlock()
+
+		room.BroadcastState(p, req.Method, *req.Params)
+
+	case "chatSend":
+		if p.UID == "" {
+			err := rpcerror.ErrNotJoined
+			replyError(err)
+			break
+		}
+		var room *Room
+		if ival, ok := Rooms.Load(p.SID); ok {
+			room, _ = ival.(*Room)
+		} else {
+			err := rpcerror.ErrRoomNotFound
+			replyError(err)
+			break
+		}
+
+		var chatSendRequest ChatSendRequest
+		err := json.Unmarshal(*req.Params, &chatSendRequest)
+		if err != nil {
+			replyError(err)
+			break
+		}
+
+		text := strings.TrimSpace(chatSendRequest.Text)
+		if text == "" {
+			replyError(rpcerror.ErrBadRequest.Wrap(fmt.Errorf("empty message")))
+			break
+		}
+		if len(text) > maxChatMessageLength {
+			replyError(rpcerror.ErrBadRequest.Wrap(fmt.Errorf("message too long")))
+			break
+		}
+
+		message := &ChatMessage{
+			ID:        cuid.New(),
+			UID:       p.UID,
+			Name:      p.Name,
+			Text:      text,
+			Timestamp: time.Now().UnixMilli(),
+		}
+		room.Chat(message)
+
+		if err := conn.Reply(ctx, req.ID, message); err != nil {
+			log.Error().Err(err).Msg("chatSend")
+		}
+
+	case "chatHistory":
+		if p.UID == "" {
+			err := rpcerror.ErrNotJoined
+			replyError(err)
+			break
+		}
+		var room *Room
+		if ival, ok := Rooms.Load(p.SID); ok {
+			room, _ = ival.(*Room)
+		} else {
+			err := rpcerror.ErrRoomNotFound
+			replyError(err)
+			break
+		}
+
+		if err := conn.Reply(ctx, req.ID, room.GetChatHistory()); err != nil {
+			log.Error().Err(err).Msg("chatHistory")
+		}
+
+	case "subscribe", "unsubscribe":
+		if p.UID == "" {
+			err := rpcerror.ErrNotJoined
+			replyError(err)
+			break
+		}
+		var room *Room
+		if ival, ok := Rooms.Load(p.SID); ok {
+			room, _ = ival.(*Room)
+		} else {
+			err := rpcerror.ErrRoomNotFound
+			replyError(err)
+			break
+		}
+
+		var subscribeRequest SubscribeRequest
+		err := json.Unmarshal(*req.Params, &subscribeRequest)
+		if err != nil {
+			replyError(err)
+			break
+		}
+
+		streamIDs, err := room.ResolveStreamIDs(subscribeRequest.StreamIDs, subscribeRequest.UIDs)
+		if err != nil {
+			replyError(err)
+			break
+		}
+
+		if req.Method == "subscribe" {
+			err = p.Peer.Subscribe(streamIDs)
+		} else {
+			err = p.Peer.Unsubscribe(streamIDs)
+		}
+		if err != nil {
+			replyError(err)
+			break
+		}
+
+		if err := conn.Reply(ctx, req.ID, streamIDs); err != nil {
+			log.Error().Err(err).Msg(req.Method)
+		}
+
+	case "startRecording", "stopRecording":
+		if p.UID == "" {
+			err := rpcerror.ErrNotJoined
+			replyError(err)
+			break
+		}
+		if !p.IsHost {
+			err := rpcerror.ErrNotHost
+			replyError(err)
+			break
+		}
+		var room *Room
+		if ival, ok := Rooms.Load(p.SID); ok {
+			room, _ = ival.(*Room)
+		} else {
+			err := rpcerror.ErrRoomNotFound
+			replyError(err)
+			break
+		}
+
+		var result interface{}
+		var err error
+		if req.Method == "startRecording" {
+			result, err = room.StartRecording(p)
+		} else {
+			result, err = room.StopRecording(p)
+		}
+		if err != nil {
+			replyError(err)
+			break
+		}
+
+		if err := conn.Reply(ctx, req.ID, result); err != nil {
+			log.Error().Err(err).Msg(req.Method)
+		}
+
+	case "kick", "forceMute", "ban", "promote", "demote":
+		if p.UID == "" {
+			err := rpcerror.ErrNotJoined
+			replyError(err)
+			break
+		}
+		if p.IsHost == false {
+			err := rpcerror.ErrNotHost
+			replyError(err)
+			break
+		}
+		var room *Room
+		if ival, ok := Rooms.Load(p.SID); ok {
+			room, _ = ival.(*Room)
+		} else {
+			err := rpcerror.ErrRoomNotFound
+			replyError(err)
+			break
+		}
+
+		var moderationRequest ModerationRequest
+		err := json.Unmarshal(*req.Params, &moderationRequest)
+		if err != nil {
+			replyError(err)
+			break
+		}
+		if moderationRequest.UID == p.UID {
+			err := rpcerror.ErrBadRequest.Wrap(fmt.Errorf("can't moderate yourself"))
+			replyError(err)
+			break
+		}
+		if req.Method == "forceMute" && moderationRequest.Kind != "audio" && moderationRequest.Kind != "video" {
+			err := rpcerror.ErrBadRequest.Wrap(fmt.Errorf("unknown kind: %v", moderationRequest.Kind))
+			replyError(err)
+			break
+		}
+
+		err = room.Moderate(&ModerationCommand{
+			Action: req.Method,
+			UID:    moderationRequest.UID,
+			Kind:   moderationRequest.Kind,
+			Muted:  moderationRequest.Muted,
+		})
+		if err != nil {
+			replyError(err)
+			break
+		}
+
+		if err := conn.Reply(ctx, req.ID, true); err != nil {
+			log.Error().Err(err).Msg(req.Method)
+		}
+	}
+}
+
+// abortJoin of a participant whose join failed after SetToken, its peer
+// leaves the session and the connection may join again with a fresh one
+func (p *Participant) abortJoin() {
+	provider := p.Peer.Provider()
+	p.Peer.Close()
+	p.Peer = sfu.NewPeer(provider)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.UID, p.SID, p.Name, p.Host = "", "", "", ""
+	p.IsHost, p.NoPublish = false, false
+	p.ResumeSecret = ""
+}
+
+// SetToken identifies a participant joining with a verified token
+func (p *Participant) SetToken(token *Token) {
+	p.UID = token.UID
+	p.SID = token.SID
+	p.Name = token.Name
+	p.IsHost = token.IsHost
+	p.Host = p.Node.ID().Pretty()
+	p.AddedAt = time.Now()
+	p.roleAt = p.AddedAt
+	p.NoPublish = token.NoPublish
+	p.AudioMuted = true
+	p.VideoMuted = true
+}
+
+// EnterRoom adds a joined participant to its room, the room is created by its
+// first participant. A participant closed meanwhile does not enter
+func (p *Participant) EnterRoom(token *Token, room *Room, clientPk ed25519.PublicKey) *Room {
+	p.roomMu.Lock()
+	defer p.roomMu.Unlock()
+
+	if room == nil {
+		created := &Room{
+			SID:           token.SID,
+			Session:       p.Peer.Session(),
+			Node:          p.Node,
+			ClientAddress: token.ClientAddress,
+			ClientPk:      clientPk,
+			URL:           token.URL,
+			CallID:        token.CallID,
+			NoBilling:     LoadTestClientKey != nil,
+			call:          call.NewState(),
+			stateEpoch:    cuid.New(),
+			hostStates:    roomstate.NewTracker(),
+		}
+		// the first participants of a room may join concurrently, only one creates it
+		if ival, loaded := Rooms.LoadOrStore(token.SID, created); loaded {
+			room, _ = ival.(*Room)
+		} else {
+			room = created
+			room.start()
+		}
+	}
+
+	p.bindPublisher(room)
+
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		return room
+	}
+	room.OnlineParticipants.Store(token.UID, p)
+	room.OnJoin(p)
+	p.entered = true
+	return room
+}
+
+// bindPublisher announces the publications of participant as its publisher transport gets tracks
+func (p *Participant) bindPublisher(room *Room) {
+	if p.Peer.Publisher() == nil {
+		return
+	}
+
+	router := p.Peer.Publisher().GetRouter()
+	router.OnAddReceiverTrack(func(receiver sfu.Receiver) {
+		room.OnTrackPublished(p, receiver)
+	})
+	router.OnDelReceiverTrack(func(receiver sfu.Receiver) {
+		if receiver != nil {
+			room.OnTrackUnpublished(p, receiver)
+		}
+	})
+}
+
+// MarshalJSON under the lock, the state of a participant is written by its
+// signaling and transport goroutines while rooms publish it
+func (p *Participant) MarshalJSON() ([]byte, error) {
+	type participant Participant
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return json.Marshal((*participant)(p))
+}
+
+// markRelayed to host, returns false if the participant was already relayed there
+func (p *Participant) markRelayed(host string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.relayed[host] {
+		return false
+	}
+	p.relayed[host] = true
+	return true
+}
+
+// IsViewer joined or demoted without publishing
+func (p *Participant) IsViewer() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.NoPublish
+}
+
+// role of the participant and the time it got it
+func (p *Participant) role() (noPublish bool, since time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.NoPublish, p.roleAt
+}
+
+// changeRole of a promoted or demoted participant at, ok is false if it
+// already had the role. since is the time it got the role it leaves
+func (p *Participant) changeRole(noPublish bool, at time.Time) (since time.Time, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.NoPublish == noPublish {
+		return time.Time{}, false
+	}
+	since = p.roleAt
+	p.NoPublish = noPublish
+	p.HandRaised = false
+	p.roleAt = at
+	return since, true
+}
+
+// IsMuted returns true if this kind of media is muted
+func (p *Participant) IsMuted(kind string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch kind {
+	case "audio":
+		return p.AudioMuted
+	case "video":
+		return p.VideoMuted
+	}
+	return false
+}
+
+// SetMuted mutes or unmutes this kind of media, media muted by the host
+// stays muted
+func (p *Participant) SetMuted(kind string, muted bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch kind {
+	case "audio":
+		if !muted && p.ForceAudioMuted {
+			return rpcerror.ErrMutedByHost
+		}
+		p.AudioMuted = muted
+	case "video":
+		if !muted && p.ForceVideoMuted {
+			return rpcerror.ErrMutedByHost
+		}
+		p.VideoMuted = muted
+	}
+	return nil
+}
+
+// IsForceMuted returns true if the host muted this kind of media
+func (p *Participant) IsForceMuted(kind string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch kind {
+	case "audio":
+		return p.ForceAudioMuted
+	case "video":
+		return p.ForceVideoMuted
+	}
+	return false
+}
+
+// SetForceMuted marks this kind of media as muted by the host
+func (p *Participant) SetForceMuted(kind string, muted bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch kind {
+	case "audio":
+		p.ForceAudioMuted = muted
+		if muted {
+			p.AudioMuted = true
+		}
+	case "video":
+		p.ForceVideoMuted = muted
+		if muted {
+			p.VideoMuted = true
+		}
+	}
+}
+
+// Notify participant over its current signaling connection
+func (p *Participant) Notify(method string, params interface{}) error {
+	p.mu.Lock()
+	ctx, conn := p.ctx, p.conn
+	p.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	return conn.Notify(ctx, method, params)
+}
+
+// Disconnect keeps the participant for the resume grace period after its
+// signaling connection dropped, then closes it
+func (p *Participant) Disconnect(conn *jsonrpc2.Conn) {
+	if resumed := p.resumedParticipant(); resumed != nil {
+		resumed.Disconnect(conn)
+		return
+	}
+
+	grace := time.Duration(conf.Signal.ResumeGracePeriod) * time.Second
+
+	p.mu.Lock()
+	// participants of a draining node migrate instead of resuming
+	if p.UID == "" || grace <= 0 || IsDraining() {
+		p.mu.Unlock()
+		p.Close()
+		return
+	}
+	if p.conn != conn || p.closed {
+		// already resumed on another connection
+		p.mu.Unlock()
+		return
+	}
+	p.conn = nil
+	p.disconnectedAt = time.Now()
+	p.resumeTimer = time.AfterFunc(grace, p.Close)
+	p.mu.Unlock()
+
+	log.Info().Str("uid", p.UID).Dur("grace", grace).Msg("participant disconnected")
+}
+
+// resumedParticipant the participant this connection resumed, nil for a connection
+// which joined or did not resume yet
+func (p *Participant) resumedParticipant() *Participant {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.resumed
+}
+
+// Resume rebinds the participant to a new signaling connection, the previous
+// one is closed. Notifies use a context of their own which ends with conn,
+// not the context of the request which resumed
+func (p *Participant) Resume(conn *jsonrpc2.Conn) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return rpcerror.ErrSessionNotFound.Wrap(fmt.Errorf("session closed"))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-conn.DisconnectNotify()
+		cancel()
+	}()
+	if p.resumeTimer != nil {
+		p.resumeTimer.Stop()
+		p.resumeTimer = nil
+	}
+	previous := p.conn
+	p.ctx = ctx
+	p.conn = conn
+	p.disconnectedAt = time.Time{}
+	p.mu.Unlock()
+
+	// a connection still open, e.g. after a network flap or a second
+	// resume, must not signal for the participant anymore
+	if previous != nil && previous != conn {
+		_ = previous.Close()
+	}
+
+	log.Info().Str("uid", p.UID).Msg("participant resumed")
+	return nil
+}
+
+// CloseConn closes the signaling connection of the participant
+func (p *Participant) CloseConn() {
+	p.mu.Lock()
+	conn := p.conn
+	p.conn = nil
+	p.mu.Unlock()
+
+	if conn != nil {
+		_ = conn.Close()
 	}
 }
 
 // Close ws close
 func (p *Participant) Close() {
-	if ival, ok := Rooms.Load(p.SID); ok {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	if p.resumeTimer != nil {
+		p.resumeTimer.Stop()
+	}
+	removedAt := time.Now()
+	if !p.disconnectedAt.IsZero() {
+		// don't bill the grace period
+		removedAt = p.disconnectedAt
+	}
+	p.mu.Unlock()
+
+	p.roomMu.Lock()
+	entered := p.entered
+	p.entered = false
+	p.roomMu.Unlock()
+
+	if ival, ok := Rooms.Load(p.SID); ok && entered {
 		room, _ := ival.(*Room)
 		room.OnlineParticipants.Delete(p.UID)
 		Rooms.Store(p.SID, room)
-		p.RemovedAt = time.Now()
+		p.RemovedAt = removedAt
 		room.OnLeave(p)
 	}
 
@@ -361,3 +1016,11 @@ func (p *Participant) Close() {
 
 	log.Info().Msg("close ws")
 }
+
+func newResumeSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
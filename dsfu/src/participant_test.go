@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/jsonrpc2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/rpcerror"
+)
+
+// newTestConn of a signaling connection whose requests are ignored, the
+// messages sent to the client are discarded
+func newTestConn(t *testing.T) *jsonrpc2.Conn {
+	client, server := net.Pipe()
+	go func() {
+		_, _ = io.Copy(io.Discard, client)
+	}()
+	conn := jsonrpc2.NewConn(context.Background(), jsonrpc2.NewBufferedStream(server, jsonrpc2.VSCodeObjectCodec{}),
+		jsonrpc2.HandlerWithError(func(context.Context, *jsonrpc2.Conn, *jsonrpc2.Request) (interface{}, error) {
+			return nil, nil
+		}))
+	t.Cleanup(func() {
+		_ = conn.Close()
+		_ = client.Close()
+	})
+	return conn
+}
+
+// withResumeGracePeriod of seconds for the test
+func withResumeGracePeriod(t *testing.T, seconds int) {
+	grace := conf.Signal.ResumeGracePeriod
+	conf.Signal.ResumeGracePeriod = seconds
+	t.Cleanup(func() {
+		conf.Signal.ResumeGracePeriod = grace
+	})
+}
+
+func TestResumeClosesPreviousConn(t *testing.T) {
+	withResumeGracePeriod(t, 30)
+	room := newTestRoom(t, "resume")
+	p := newTestParticipant(room, "uid", false, time.Now())
+	previous, conn := newTestConn(t), newTestConn(t)
+	p.conn = previous
+
+	require.NoError(t, p.Resume(conn))
+	select {
+	case <-previous.DisconnectNotify():
+	case <-time.After(time.Second):
+		t.Fatal("the previous connection is still open")
+	}
+
+	// the previous connection dropping does not disconnect the participant
+	p.Disconnect(previous)
+	p.mu.Lock()
+	assert.Equal(t, conn, p.conn)
+	assert.Nil(t, p.resumeTimer)
+	p.mu.Unlock()
+}
+
+func TestAbortJoin(t *testing.T) {
+	withResumeGracePeriod(t, 30)
+	room := newTestRoom(t, "abort")
+	p := newTestParticipant(room, "uid", false, time.Now())
+	p.IsHost = true
+	p.ResumeSecret = "secret"
+	conn := newTestConn(t)
+	p.conn = conn
+	peer := p.Peer
+
+	p.abortJoin()
+	assert.Empty(t, p.UID)
+	assert.Empty(t, p.ResumeSecret)
+	assert.False(t, p.IsHost)
+	assert.NotSame(t, peer, p.Peer, "a fresh peer joins again")
+
+	// the participant is closed right away instead of waiting for a resume
+	p.Disconnect(conn)
+	p.mu.Lock()
+	assert.True(t, p.closed)
+	assert.Nil(t, p.resumeTimer)
+	p.mu.Unlock()
+}
+
+func TestSetSources(t *testing.T) {
+	p := NewParticipant(nil, nil)
+	require.NoError(t, p.SetSources(map[string]string{"stream": SourceScreen}))
+	assert.Equal(t, SourceScreen, p.sources["stream"])
+
+	err := p.SetSources(map[string]string{"stream": "window"})
+	assert.ErrorIs(t, err, rpcerror.ErrBadRequest, "clients get a bad request")
+	assert.Equal(t, SourceScreen, p.sources["stream"])
+}
+
+func TestMuteEvent(t *testing.T) {
+	room := newTestRoom(t, "mute")
+	n := &sentNode{}
+	room.Node = n
+	p := newTestParticipant(room, "uid", false, time.Now())
+	p.EnterRoom(&Token{SID: p.SID, UID: p.UID}, room, nil)
+	conn := newTestConn(t)
+
+	params := json.RawMessage(`{"kind":"audio","muted":true}`)
+	p.Handle(context.Background(), conn, &jsonrpc2.Request{Method: "muteEvent", Params: &params})
+	assert.True(t, p.IsMuted("audio"))
+
+	// the state delta carries the participant muted
+	var delta *StateDelta
+	for _, pubMessage := range n.sent {
+		if pubMessage.Method == "stateDelta" {
+			delta = &StateDelta{}
+			require.NoError(t, json.Unmarshal(pubMessage.Payload, delta))
+		}
+	}
+	require.NotNil(t, delta)
+	assert.Equal(t, "muteEvent", delta.Method)
+	assert.True(t, delta.Participant.AudioMuted)
+
+	// a participant who did not join is not muted
+	other := NewParticipant(nil, room.Node)
+	other.Handle(context.Background(), conn, &jsonrpc2.Request{Method: "muteEvent", Params: &params})
+	assert.False(t, other.IsMuted("audio"))
+}
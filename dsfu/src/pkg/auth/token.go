@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ClockSkew tolerated between the client backend and this node
+const ClockSkew = 30 * time.Second
+
+// MaxLifetime bounds exp - nbf so used nonces are not kept forever
+const MaxLifetime = 24 * time.Hour
+
+var (
+	// ErrMalformed token without nonce or validity window
+	ErrMalformed = errors.New("token malformed")
+	// ErrLifetime of a token beyond MaxLifetime
+	ErrLifetime = errors.New("token lifetime too long")
+	// ErrNotYetValid token before its not before time
+	ErrNotYetValid = errors.New("token not valid yet")
+	// ErrExpired token after its expiry
+	ErrExpired = errors.New("token expired")
+	// ErrUsed nonce of a token used before, or maybe used before a restart
+	ErrUsed = errors.New("token already used")
+)
+
+// Validity window and single use nonce of a token signed by a client backend
+type Validity struct {
+	NotBefore int64  `json:"nbf"`
+	ExpiresAt int64  `json:"exp"`
+	Nonce     string `json:"jti"`
+}
+
+// Validate the validity window at now, clocks may be ClockSkew apart
+func (v *Validity) Validate(now time.Time) error {
+	if v.Nonce == "" {
+		return fmt.Errorf("%w: nonce missing", ErrMalformed)
+	}
+	if v.ExpiresAt == 0 || v.NotBefore == 0 {
+		return fmt.Errorf("%w: validity missing", ErrMalformed)
+	}
+
+	nbf := time.Unix(v.NotBefore, 0)
+	exp := time.Unix(v.ExpiresAt, 0)
+	if exp.Sub(nbf) > MaxLifetime {
+		return ErrLifetime
+	}
+	if now.Add(ClockSkew).Before(nbf) {
+		return ErrNotYetValid
+	}
+	if now.Add(-ClockSkew).After(exp) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// Until the nonce has to be remembered, the token is rejected after it
+func (v *Validity) Until() time.Time {
+	return time.Unix(v.ExpiresAt, 0).Add(ClockSkew)
+}
+
+// NonceCache remembers nonces until their expiry. It is only kept in memory,
+// so the nonces used before a restart are lost: Use rejects the tokens issued
+// before the cache started, a token can only be used within the life of the
+// cache which remembers its nonce. Clocks ClockSkew apart leave that window
+// open for ClockSkew
+type NonceCache struct {
+	mu      sync.Mutex
+	nonces  map[string]time.Time
+	purged  time.Time
+	started time.Time
+}
+
+// NewNonceCache creates an empty cache, started now
+func NewNonceCache() *NonceCache {
+	return &NonceCache{nonces: make(map[string]time.Time), started: time.Now()}
+}
+
+// Use the nonce of a token validated at now. It fails with ErrUsed when the
+// nonce was used or the token was issued before the cache started
+func (c *NonceCache) Use(v *Validity, now time.Time) error {
+	if time.Unix(v.NotBefore, 0).Before(c.started.Truncate(time.Second)) {
+		return fmt.Errorf("%w: issued before the node started", ErrUsed)
+	}
+	if !c.Add(v.Nonce, v.Until(), now) {
+		return ErrUsed
+	}
+	return nil
+}
+
+// Release a nonce used by a failed join, the token can be used again
+func (c *NonceCache) Release(nonce string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.nonces, nonce)
+}
+
+// Used tells if nonce was added and did not expire at now
+func (c *NonceCache) Used(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiry, ok := c.nonces[nonce]
+	return ok && !now.After(expiry)
+}
+
+// Add nonce until expiry, returns false if the nonce was already used
+func (c *NonceCache) Add(nonce string, until time.Time, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.purged) > time.Minute {
+		for n, expiry := range c.nonces {
+			if now.After(expiry) {
+				delete(c.nonces, n)
+			}
+		}
+		c.purged = now
+	}
+
+	if expiry, ok := c.nonces[nonce]; ok && !now.After(expiry) {
+		return false
+	}
+	c.nonces[nonce] = until
+	return true
+}
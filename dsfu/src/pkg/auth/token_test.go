@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name      string
+		notBefore time.Time
+		expiresAt time.Time
+		nonce     string
+		err       error
+	}{
+		{"valid", now, now.Add(time.Hour), "nonce", nil},
+		{"nonce missing", now, now.Add(time.Hour), "", ErrMalformed},
+		{"expiry missing", now, time.Unix(0, 0), "nonce", ErrMalformed},
+		{"not before missing", time.Unix(0, 0), now.Add(time.Hour), "nonce", ErrMalformed},
+		{"lifetime too long", now, now.Add(MaxLifetime + time.Second), "nonce", ErrLifetime},
+		{"not yet valid", now.Add(ClockSkew + time.Second), now.Add(time.Hour), "nonce", ErrNotYetValid},
+		{"not yet valid within skew", now.Add(ClockSkew), now.Add(time.Hour), "nonce", nil},
+		{"expired", now.Add(-time.Hour), now.Add(-ClockSkew - time.Second), "nonce", ErrExpired},
+		{"expired within skew", now.Add(-time.Hour), now.Add(-ClockSkew), "nonce", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := &Validity{NotBefore: test.notBefore.Unix(), ExpiresAt: test.expiresAt.Unix(), Nonce: test.nonce}
+			err := v.Validate(now)
+			if test.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, test.err)
+			}
+		})
+	}
+}
+
+func TestNonceCacheReplay(t *testing.T) {
+	c := NewNonceCache()
+	now := time.Now()
+	until := now.Add(time.Hour)
+
+	assert.False(t, c.Used("nonce", now))
+	assert.True(t, c.Add("nonce", until, now))
+	assert.True(t, c.Used("nonce", now))
+	assert.False(t, c.Add("nonce", until, now), "a nonce is used once")
+	assert.True(t, c.Add("other", until, now))
+}
+
+func TestNonceCacheExpiry(t *testing.T) {
+	c := NewNonceCache()
+	now := time.Now()
+
+	assert.True(t, c.Add("nonce", now.Add(time.Minute), now))
+	later := now.Add(2 * time.Minute)
+	assert.False(t, c.Used("nonce", later))
+	assert.True(t, c.Add("nonce", later.Add(time.Minute), later), "expired nonces are forgotten")
+
+	// expired nonces are purged by the first add a minute after the last purge
+	assert.True(t, c.Add("other", later.Add(time.Hour), later.Add(3*time.Minute)))
+	c.mu.Lock()
+	_, kept := c.nonces["nonce"]
+	c.mu.Unlock()
+	assert.False(t, kept)
+}
+
+func TestUntil(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	v := &Validity{NotBefore: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix(), Nonce: "nonce"}
+
+	// a token accepted within the clock skew after its expiry can't be replayed
+	assert.Equal(t, now.Add(time.Hour+ClockSkew), v.Until())
+	assert.NoError(t, v.Validate(v.Until()))
+	assert.ErrorIs(t, v.Validate(v.Until().Add(time.Second)), ErrExpired)
+}
+
+func TestNonceCacheUse(t *testing.T) {
+	c := NewNonceCache()
+	now := time.Now()
+	v := &Validity{NotBefore: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix(), Nonce: "nonce"}
+
+	assert.NoError(t, c.Use(v, now))
+	assert.ErrorIs(t, c.Use(v, now), ErrUsed)
+
+	// a failed join releases the nonce for a retry
+	c.Release("nonce")
+	assert.NoError(t, c.Use(v, now))
+}
+
+func TestNonceCacheReplayAcrossInstances(t *testing.T) {
+	now := time.Now()
+	v := &Validity{NotBefore: now.Add(-time.Second).Unix(), ExpiresAt: now.Add(time.Minute).Unix(), Nonce: "nonce"}
+
+	c := NewNonceCache()
+	c.started = now.Add(-time.Hour)
+	assert.NoError(t, c.Use(v, now))
+
+	// the nonces of the cache are lost by a restart, tokens issued before the
+	// new cache started are rejected
+	restarted := NewNonceCache()
+	restarted.started = now
+	assert.ErrorIs(t, restarted.Use(v, now), ErrUsed)
+
+	issued := &Validity{NotBefore: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix(), Nonce: "issued"}
+	assert.NoError(t, restarted.Use(issued, now), "tokens issued since the start are used once")
+	assert.ErrorIs(t, restarted.Use(issued, now), ErrUsed)
+}
@@ -0,0 +1,343 @@
+package billing
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Entry types of a journal
+const (
+	EntryOpen     = "open"
+	EntryJoin     = "join"
+	EntryLeave    = "leave"
+	EntryResponse = "response"
+	EntryCreate   = "create"
+	EntryEnd      = "end"
+	EntryTick     = "tick"
+)
+
+// journalExt of the journal files, one per room
+const journalExt = ".jsonl"
+
+// ErrClosed journal
+var ErrClosed = errors.New("journal closed")
+
+// Call billed by a node, written by the open entry
+type Call struct {
+	SID           string `json:"sid"`
+	CallID        string `json:"callID"`
+	ClientAddress string `json:"clientAddress"`
+	URL           string `json:"url"`
+}
+
+// Response signed by the client backend for a notify of the node
+type Response struct {
+	Action    string `json:"action"`
+	Message   []byte `json:"message"`
+	Signature []byte `json:"signature"`
+	Duration  int    `json:"duration"`
+}
+
+// Entry of a journal, one json line
+type Entry struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Call      *Call     `json:"call,omitempty"`
+	UID       string    `json:"uid,omitempty"`
+	NoPublish bool      `json:"noPublish,omitempty"`
+	Response  *Response `json:"response,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Journal of the billing state of a call on this node. Every entry is synced
+// to disk before the call goes on, so a node restarting after a crash can
+// settle the calls it did not end
+type Journal struct {
+	sync.Mutex
+	path   string
+	file   *os.File
+	closed bool
+}
+
+// New journal ID of call in dir. ID is unique to the room of the call on
+// this node, a call reopened after a restart gets a journal of its own
+func New(dir string, ID string, call Call) (*Journal, error) {
+	if ID == "" || strings.ContainsAny(ID, `/\.`) {
+		return nil, fmt.Errorf("journal: invalid id %q", ID)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, ID+journalExt)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{path: path, file: file}
+	if err := j.write(&Entry{Type: EntryOpen, Call: &call}); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// Join of a participant
+func (j *Journal) Join(UID string, noPublish bool, at time.Time) error {
+	return j.write(&Entry{Type: EntryJoin, Time: at, UID: UID, NoPublish: noPublish})
+}
+
+// Leave of a participant, noPublish when it left as a viewer
+func (j *Journal) Leave(UID string, noPublish bool, at time.Time) error {
+	return j.write(&Entry{Type: EntryLeave, Time: at, UID: UID, NoPublish: noPublish})
+}
+
+// Response signed by the client backend
+func (j *Journal) Response(response Response) error {
+	return j.write(&Entry{Type: EntryResponse, Response: &response})
+}
+
+// Created after the create call transaction was sent, err if it failed
+func (j *Journal) Created(err error) error {
+	return j.write(&Entry{Type: EntryCreate, Error: errorString(err)})
+}
+
+// Ended after the end call transaction was sent, err if it failed. The
+// journal of a settled call is removed
+func (j *Journal) Ended(err error) error {
+	if err != nil {
+		return j.write(&Entry{Type: EntryEnd, Error: errorString(err)})
+	}
+	return j.Remove()
+}
+
+// Tick records the node is alive, participants without leave are billed up
+// to the last entry after a crash
+func (j *Journal) Tick() error {
+	return j.write(&Entry{Type: EntryTick})
+}
+
+// Close the journal and keep it for recovery
+func (j *Journal) Close() error {
+	j.Lock()
+	defer j.Unlock()
+	if j.closed {
+		return nil
+	}
+	j.closed = true
+	return j.file.Close()
+}
+
+// Remove the journal of a settled call
+func (j *Journal) Remove() error {
+	if err := j.Close(); err != nil {
+		return err
+	}
+	return os.Remove(j.path)
+}
+
+func (j *Journal) write(entry *Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	j.Lock()
+	defer j.Unlock()
+	if j.closed {
+		return ErrClosed
+	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// interval a participant was in the call
+type interval struct {
+	noPublish bool
+	joined    time.Time
+	left      time.Time
+}
+
+// Replay of a journal, the billing state of its call when the node stopped
+type Replay struct {
+	Call
+	// Created when the create call transaction was sent
+	Created bool
+	// CreateMessage signed by the client backend for the first join, a
+	// create call transaction which failed or was cut by a crash may have
+	// been processed with it
+	CreateMessage []byte
+	// Ended when the end call transaction was sent
+	Ended bool
+	// LastResponse the end call message with the most spent minutes
+	LastResponse *Response
+	// Opened time of the first entry and LastSeen of the last one
+	Opened    time.Time
+	LastSeen  time.Time
+	intervals map[string][]*interval
+	path      string
+}
+
+// Settled calls need no end call transaction, they were ended or never
+// created. Calls whose creation is uncertain are only known by the chain
+func (r *Replay) Settled() bool {
+	return !r.Created || r.Ended
+}
+
+// CreateUncertain when the create call transaction may have been processed
+// without a successful create entry, the user contract tells if the call is open
+func (r *Replay) CreateUncertain() bool {
+	return !r.Created && !r.Ended && r.CreateMessage != nil
+}
+
+// Minutes spent by the publishers, participants without leave are counted
+// up to the last entry of the journal
+func (r *Replay) Minutes() int {
+	duration := 0.0
+	for _, intervals := range r.intervals {
+		for _, i := range intervals {
+			if i.noPublish {
+				continue
+			}
+			left := i.left
+			if left.IsZero() {
+				left = r.LastSeen
+			}
+			duration += left.Sub(i.joined).Seconds()
+		}
+	}
+	return int(math.Ceil(duration / 60.0))
+}
+
+// Remove the journal after the call was settled
+func (r *Replay) Remove() error {
+	return os.Remove(r.path)
+}
+
+// corruptExt of the journals which could not be loaded, kept for inspection
+const corruptExt = ".corrupt"
+
+// Load the journals of dir, sorted by their first entry. Journals which can't
+// be loaded are logged and renamed with corruptExt, the others are loaded
+func Load(dir string) ([]*Replay, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+journalExt))
+	if err != nil {
+		return nil, err
+	}
+
+	var replays []*Replay
+	for _, path := range paths {
+		replay, err := load(path)
+		if err != nil {
+			log.Error().Err(err).Str("journal", filepath.Base(path)).Msg("journal corrupt")
+			if err := os.Rename(path, path+corruptExt); err != nil {
+				log.Error().Err(err).Str("journal", filepath.Base(path)).Msg("journal")
+			}
+			continue
+		}
+		replays = append(replays, replay)
+	}
+	sort.Slice(replays, func(i, j int) bool {
+		return replays[i].Opened.Before(replays[j].Opened)
+	})
+	return replays, nil
+}
+
+func load(path string) (*Replay, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	replay := &Replay{intervals: make(map[string][]*interval), path: path}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// a crash may cut the last line, the entries before it are synced
+			break
+		}
+		replay.apply(&entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if replay.CallID == "" {
+		return nil, fmt.Errorf("no open entry")
+	}
+	return replay, nil
+}
+
+func (r *Replay) apply(entry *Entry) {
+	if r.Opened.IsZero() {
+		r.Opened = entry.Time
+	}
+	if entry.Time.After(r.LastSeen) {
+		r.LastSeen = entry.Time
+	}
+
+	switch entry.Type {
+	case EntryOpen:
+		if entry.Call != nil {
+			r.Call = *entry.Call
+		}
+	case EntryJoin:
+		r.intervals[entry.UID] = append(r.intervals[entry.UID], &interval{
+			noPublish: entry.NoPublish,
+			joined:    entry.Time,
+		})
+	case EntryLeave:
+		intervals := r.intervals[entry.UID]
+		if len(intervals) > 0 && intervals[len(intervals)-1].left.IsZero() {
+			// promotions and demotions leave and join again, journals
+			// written before bill the whole interval by the role at the leave
+			intervals[len(intervals)-1].noPublish = entry.NoPublish
+			intervals[len(intervals)-1].left = entry.Time
+		}
+	case EntryResponse:
+		if entry.Response == nil {
+			return
+		}
+		// join responses carry the create call message
+		if entry.Response.Action == "join" && r.CreateMessage == nil {
+			r.CreateMessage = entry.Response.Message
+		}
+		if entry.Response.Duration == 0 {
+			return
+		}
+		if r.LastResponse == nil || entry.Response.Duration >= r.LastResponse.Duration {
+			r.LastResponse = entry.Response
+		}
+	case EntryCreate:
+		r.Created = r.Created || entry.Error == ""
+	case EntryEnd:
+		r.Ended = r.Ended || entry.Error == ""
+	}
+}
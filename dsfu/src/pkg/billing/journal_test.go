@@ -0,0 +1,161 @@
+package billing
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testCall = Call{SID: "room", CallID: "call", ClientAddress: "EQclient", URL: "https://client/callback"}
+
+func TestReplay(t *testing.T) {
+	dir := t.TempDir()
+	j, err := New(dir, "room1", testCall)
+	require.NoError(t, err)
+
+	start := time.Now().Add(-10*time.Minute + 30*time.Second)
+	require.NoError(t, j.Join("a", false, start))
+	require.NoError(t, j.Join("b", false, start.Add(time.Minute)))
+	require.NoError(t, j.Join("viewer", true, start))
+	require.NoError(t, j.Response(Response{Action: "join", Message: []byte("create")}))
+	require.NoError(t, j.Created(nil))
+	require.NoError(t, j.Leave("b", false, start.Add(3*time.Minute)))
+	require.NoError(t, j.Response(Response{Action: "leave", Message: []byte("end"), Duration: 2}))
+	require.NoError(t, j.Close())
+
+	replays, err := Load(dir)
+	require.NoError(t, err)
+	require.Len(t, replays, 1)
+
+	r := replays[0]
+	assert.Equal(t, testCall, r.Call)
+	assert.True(t, r.Created)
+	assert.False(t, r.Settled())
+	require.NotNil(t, r.LastResponse)
+	assert.Equal(t, []byte("end"), r.LastResponse.Message)
+	// a is billed up to the last entry, b for its two minutes, viewers are free
+	assert.Equal(t, 12, r.Minutes())
+}
+
+func TestReplayRoles(t *testing.T) {
+	dir := t.TempDir()
+	j, err := New(dir, "room1", testCall)
+	require.NoError(t, err)
+
+	start := time.Now().Add(-10 * time.Minute)
+	require.NoError(t, j.Join("a", false, start))
+	// demoted after two minutes and promoted again after three more
+	require.NoError(t, j.Leave("a", false, start.Add(2*time.Minute)))
+	require.NoError(t, j.Join("a", true, start.Add(2*time.Minute)))
+	require.NoError(t, j.Leave("a", true, start.Add(5*time.Minute)))
+	require.NoError(t, j.Join("a", false, start.Add(5*time.Minute)))
+	require.NoError(t, j.Leave("a", false, start.Add(6*time.Minute)))
+	require.NoError(t, j.Close())
+
+	replays, err := Load(dir)
+	require.NoError(t, err)
+	require.Len(t, replays, 1)
+	assert.Equal(t, 3, replays[0].Minutes(), "viewer minutes are free")
+}
+
+func TestReplayNotCreated(t *testing.T) {
+	dir := t.TempDir()
+	j, err := New(dir, "room1", testCall)
+	require.NoError(t, err)
+	require.NoError(t, j.Join("a", false, time.Now()))
+	require.NoError(t, j.Created(errors.New("lite server unavailable")))
+	require.NoError(t, j.Close())
+
+	replays, err := Load(dir)
+	require.NoError(t, err)
+	require.Len(t, replays, 1)
+	assert.False(t, replays[0].Created)
+	assert.True(t, replays[0].Settled(), "calls never created are not ended")
+}
+
+func TestReplayCreateUncertain(t *testing.T) {
+	dir := t.TempDir()
+	j, err := New(dir, "room1", testCall)
+	require.NoError(t, err)
+	require.NoError(t, j.Join("a", false, time.Now()))
+	require.NoError(t, j.Response(Response{Action: "join", Message: []byte("create")}))
+	require.NoError(t, j.Response(Response{Action: "join", Message: []byte("second")}))
+	require.NoError(t, j.Created(errors.New("transaction not processed")))
+	require.NoError(t, j.Close())
+
+	replays, err := Load(dir)
+	require.NoError(t, err)
+	require.Len(t, replays, 1)
+	r := replays[0]
+	assert.True(t, r.CreateUncertain(), "the failed create may have been processed")
+	assert.Equal(t, []byte("create"), r.CreateMessage)
+}
+
+func TestEndedRemovesJournal(t *testing.T) {
+	dir := t.TempDir()
+	j, err := New(dir, "room1", testCall)
+	require.NoError(t, err)
+	require.NoError(t, j.Created(nil))
+	require.NoError(t, j.Ended(errors.New("timeout")))
+
+	replays, err := Load(dir)
+	require.NoError(t, err)
+	require.Len(t, replays, 1)
+	assert.False(t, replays[0].Settled(), "a failed end is settled on recovery")
+
+	require.NoError(t, j.Ended(nil))
+	replays, err = Load(dir)
+	require.NoError(t, err)
+	assert.Empty(t, replays)
+	assert.ErrorIs(t, j.Tick(), ErrClosed)
+}
+
+func TestReplayCutLine(t *testing.T) {
+	dir := t.TempDir()
+	j, err := New(dir, "room1", testCall)
+	require.NoError(t, err)
+	require.NoError(t, j.Created(nil))
+	require.NoError(t, j.Close())
+
+	// the node crashed while writing an entry
+	file, err := os.OpenFile(filepath.Join(dir, "room1"+journalExt), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"type":"end","ti`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	replays, err := Load(dir)
+	require.NoError(t, err)
+	require.Len(t, replays, 1)
+	assert.True(t, replays[0].Created)
+	assert.False(t, replays[0].Ended)
+}
+
+func TestLoadSkipsCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	j, err := New(dir, "room1", testCall)
+	require.NoError(t, err)
+	require.NoError(t, j.Created(nil))
+	require.NoError(t, j.Close())
+
+	// the node crashed before the open entry of another journal was written
+	corrupt := filepath.Join(dir, "room2"+journalExt)
+	require.NoError(t, os.WriteFile(corrupt, []byte(`{"type":"op`), 0644))
+
+	replays, err := Load(dir)
+	require.NoError(t, err)
+	require.Len(t, replays, 1, "the other journals are loaded")
+	assert.Equal(t, testCall, replays[0].Call)
+	assert.NoFileExists(t, corrupt)
+	assert.FileExists(t, corrupt+corruptExt, "kept for inspection")
+}
+
+func TestInvalidID(t *testing.T) {
+	_, err := New(t.TempDir(), "../room", testCall)
+	assert.Error(t, err)
+}
@@ -4,8 +4,8 @@ import (
 	"sync"
 	"testing"
 
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/logger"
 	"github.com/pion/rtcp"
-	"main/pkg/logger"
 
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
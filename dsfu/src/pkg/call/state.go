@@ -0,0 +1,169 @@
+package call
+
+import (
+	"sync"
+)
+
+// State of the call of a room on a node. It is shared by the room observer,
+// the notify goroutines and the signaling handlers, every transition is made
+// under its lock so the call is created at most once and counters never go
+// negative
+type State struct {
+	mu       sync.Mutex
+	opened   bool
+	creating bool
+	sent     bool
+	ending   bool
+	closed   bool
+	ended    bool
+	viewers  int
+	created  chan struct{}
+}
+
+// NewState of a room which was not opened yet
+func NewState() *State {
+	return &State{created: make(chan struct{})}
+}
+
+// Open the call by the first join notified to the client backend, only the
+// first caller gets true
+func (s *State) Open() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.opened {
+		return false
+	}
+	s.opened = true
+	return true
+}
+
+// Opened when a join was notified
+func (s *State) Opened() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opened
+}
+
+// BeginCreate claims the creation of an opened call, only one caller gets
+// true. The caller sends the transaction and calls EndCreate. A closed or
+// ended room is not created anymore
+func (s *State) BeginCreate() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.opened || s.creating || s.closed || s.ended {
+		return false
+	}
+	s.creating = true
+	return true
+}
+
+// EndCreate after the create transaction was sent or failed
+func (s *State) EndCreate() {
+	s.mu.Lock()
+	s.sent = true
+	s.mu.Unlock()
+	close(s.created)
+}
+
+// CreateSent when the create transaction was sent or failed
+func (s *State) CreateSent() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sent
+}
+
+// Created when the creation was claimed, sent or in flight
+func (s *State) Created() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.creating
+}
+
+// BeginEnd claims the end of a created call, only one caller gets true. The
+// call is ended when the room closes or settled before when the client
+// backend stops signing its spent minutes
+func (s *State) BeginEnd() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.creating || s.ending {
+		return false
+	}
+	s.ending = true
+	return true
+}
+
+// Ending when the end was claimed
+func (s *State) Ending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ending
+}
+
+// Close the call, only the first caller gets ok. created tells if the call
+// was claimed, Close then waits for its transaction so the end follows it
+func (s *State) Close() (created bool, ok bool) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return false, false
+	}
+	s.closed = true
+	created = s.creating
+	s.mu.Unlock()
+
+	if created {
+		<-s.created
+	}
+	return created, true
+}
+
+// End the call by the host, the room closes with its participants
+func (s *State) End() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ended = true
+}
+
+// Ended by the host
+func (s *State) Ended() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ended
+}
+
+// Closed or ended
+func (s *State) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed || s.ended
+}
+
+// AddViewer joined or demoted
+func (s *State) AddViewer() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.viewers++
+	return s.viewers
+}
+
+// RemoveViewer left or promoted, ok is false and the count is kept when
+// there is no viewer to remove
+func (s *State) RemoveViewer() (count int, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.viewers == 0 {
+		return 0, false
+	}
+	s.viewers--
+	return s.viewers, true
+}
+
+// Viewers count
+func (s *State) Viewers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.viewers
+}
@@ -0,0 +1,178 @@
+package call
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenOnce(t *testing.T) {
+	s := NewState()
+
+	var opened int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if s.Open() {
+				atomic.AddInt32(&opened, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), opened)
+	assert.True(t, s.Opened())
+}
+
+func TestCreateOnce(t *testing.T) {
+	s := NewState()
+	assert.False(t, s.BeginCreate(), "a call is created after the first join was notified")
+	s.Open()
+
+	var creates int32
+	var wg sync.WaitGroup
+	// the observer tries every tick while joins and leaves go on
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if s.BeginCreate() {
+				atomic.AddInt32(&creates, 1)
+				time.Sleep(time.Millisecond)
+				s.EndCreate()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), creates)
+	assert.True(t, s.Created())
+}
+
+func TestCloseWaitsForCreate(t *testing.T) {
+	s := NewState()
+	s.Open()
+	require.True(t, s.BeginCreate())
+
+	closed := make(chan bool)
+	go func() {
+		created, ok := s.Close()
+		assert.True(t, ok)
+		closed <- created
+	}()
+
+	select {
+	case <-closed:
+		t.Fatal("closed before the create transaction was sent")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	s.EndCreate()
+	assert.True(t, <-closed)
+
+	_, ok := s.Close()
+	assert.False(t, ok, "a room closes once")
+	assert.False(t, s.BeginCreate())
+}
+
+func TestEndOnce(t *testing.T) {
+	s := NewState()
+	s.Open()
+	assert.False(t, s.BeginEnd(), "calls never created are not ended")
+
+	require.True(t, s.BeginCreate())
+	assert.False(t, s.CreateSent())
+	s.EndCreate()
+	assert.True(t, s.CreateSent())
+
+	var ends int32
+	var wg sync.WaitGroup
+	// a settlement races the close of the room
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if s.BeginEnd() {
+				atomic.AddInt32(&ends, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), ends)
+	assert.True(t, s.Ending())
+}
+
+func TestCloseWithoutCreate(t *testing.T) {
+	s := NewState()
+	s.Open()
+
+	created, ok := s.Close()
+	assert.True(t, ok)
+	assert.False(t, created)
+	assert.False(t, s.BeginCreate(), "a closed room is not created anymore")
+	assert.True(t, s.Closed())
+}
+
+func TestConcurrentJoinLeaveEnd(t *testing.T) {
+	s := NewState()
+	s.Open()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			s.AddViewer()
+		}()
+		go func() {
+			defer wg.Done()
+			// leaves racing their joins are rejected
+			count, _ := s.RemoveViewer()
+			assert.GreaterOrEqual(t, count, 0)
+		}()
+		go func() {
+			defer wg.Done()
+			if s.BeginCreate() {
+				s.EndCreate()
+			}
+			_ = s.Viewers()
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.End()
+	}()
+	wg.Wait()
+
+	assert.GreaterOrEqual(t, s.Viewers(), 0)
+	assert.True(t, s.Ended())
+	assert.True(t, s.Closed())
+
+	for s.Viewers() > 0 {
+		s.RemoveViewer()
+	}
+	count, ok := s.RemoveViewer()
+	assert.Equal(t, 0, count)
+	assert.False(t, ok)
+}
+
+func TestRemoveViewerUnderflow(t *testing.T) {
+	s := NewState()
+
+	count, ok := s.RemoveViewer()
+	assert.False(t, ok, "a leave without join is reported")
+	assert.Equal(t, 0, count)
+
+	s.AddViewer()
+	count, ok = s.RemoveViewer()
+	assert.True(t, ok)
+	assert.Equal(t, 0, count)
+}
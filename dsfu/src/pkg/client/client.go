@@ -0,0 +1,583 @@
+// Package client joins dTelecom rooms from Go, e.g. for bots, recorders and
+// integration tests. It speaks the JSON-RPC signaling of dsfu over a websocket
+// with a publisher transport offered by the client and a subscriber transport
+// offered by the node.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/interceptor"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+	"github.com/sourcegraph/jsonrpc2"
+	websocketjsonrpc2 "github.com/sourcegraph/jsonrpc2/websocket"
+)
+
+// Transport targets of trickled candidates
+const (
+	publisher  = 0
+	subscriber = 1
+)
+
+// APIChannelLabel of the datachannel opened on the publisher transport
+const APIChannelLabel = "ion-sfu"
+
+var (
+	// ErrNotJoined client has not joined a room
+	ErrNotJoined = errors.New("not joined")
+	// ErrNoPublisher client joined as a viewer
+	ErrNoPublisher = errors.New("no publisher transport")
+)
+
+// Config of a Client
+type Config struct {
+	WebRTC webrtc.Configuration
+	// SettingEngine of both transports, optional
+	SettingEngine *webrtc.SettingEngine
+	// NoAutoSubscribe streams are pulled with Subscribe
+	NoAutoSubscribe bool
+}
+
+// Participant of a room as sent by dsfu
+type Participant struct {
+	SID          string         `json:"sid"`
+	UID          string         `json:"uid"`
+	Name         string         `json:"name"`
+	StreamID     string         `json:"streamID"`
+	IsHost       bool           `json:"isHost"`
+	Host         string         `json:"host"`
+	NoPublish    bool           `json:"noPublish"`
+	AudioMuted   bool           `json:"audioMuted"`
+	VideoMuted   bool           `json:"videoMuted"`
+	HandRaised   bool           `json:"handRaised"`
+	Publications []*Publication `json:"publications"`
+}
+
+// Publication of a participant, tracks of one kind in one stream
+type Publication struct {
+	StreamID string   `json:"streamID"`
+	TrackIDs []string `json:"trackIDs"`
+	Kind     string   `json:"kind"`
+	Source   string   `json:"source"`
+}
+
+// ParticipantsCount of a room
+type ParticipantsCount struct {
+	ParticipantsCount int `json:"participantsCount"`
+	ViewersCount      int `json:"viewersCount"`
+}
+
+// MuteEvent of a participant
+type MuteEvent struct {
+	Kind  string `json:"kind"`
+	Muted bool   `json:"muted"`
+}
+
+// roomMessage wraps events about a participant
+type roomMessage struct {
+	Participant *Participant    `json:"participant"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
+type joinRequest struct {
+	Token           string                     `json:"token"`
+	Signature       string                     `json:"signature"`
+	Offer           *webrtc.SessionDescription `json:"offer,omitempty"`
+	NoAutoSubscribe bool                       `json:"noAutoSubscribe"`
+}
+
+type joinResponse struct {
+	*webrtc.SessionDescription
+	ResumeSecret string `json:"resumeSecret"`
+}
+
+type negotiation struct {
+	Desc webrtc.SessionDescription `json:"desc"`
+}
+
+type trickle struct {
+	Target    int                     `json:"target"`
+	Candidate webrtc.ICECandidateInit `json:"candidate"`
+}
+
+type subscribeRequest struct {
+	StreamIDs []string `json:"streamIds"`
+	UIDs      []string `json:"uids"`
+}
+
+// Client of a dTelecom room
+type Client struct {
+	config Config
+	api    *webrtc.API
+
+	mu         sync.Mutex
+	negotiate  sync.Mutex
+	conn       *jsonrpc2.Conn
+	pub        *webrtc.PeerConnection
+	sub        *webrtc.PeerConnection
+	candidates [2][]webrtc.ICECandidateInit
+	local      []*trickle
+	joined     bool
+	closed     bool
+	events     chan func()
+	done       chan struct{}
+
+	// UID of the joined participant
+	UID string
+	// ResumeSecret returned by join
+	ResumeSecret string
+
+	OnParticipants      func(participants map[string]*Participant)
+	OnJoin              func(participant *Participant)
+	OnLeave             func(participant *Participant)
+	OnStream            func(participant *Participant)
+	OnParticipantsCount func(count ParticipantsCount)
+	OnMuteEvent         func(participant *Participant, event MuteEvent)
+	OnTrack             func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver)
+	// OnNotification of methods without a typed callback
+	OnNotification func(method string, params json.RawMessage)
+	// OnClose of the signaling connection
+	OnClose func()
+}
+
+// New client, callbacks have to be set before Join. They are called in order
+// on a goroutine of the client, so they can call the node
+func New(config Config) (*Client, error) {
+	me := &webrtc.MediaEngine{}
+	if err := me.RegisterDefaultCodecs(); err != nil {
+		return nil, err
+	}
+	// mid and rid identify the layers of simulcast tracks
+	for _, extension := range []string{sdp.SDESMidURI, sdp.SDESRTPStreamIDURI} {
+		if err := me.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: extension}, webrtc.RTPCodecTypeVideo); err != nil {
+			return nil, err
+		}
+	}
+
+	ir := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(me, ir); err != nil {
+		return nil, err
+	}
+
+	options := []func(*webrtc.API){webrtc.WithMediaEngine(me), webrtc.WithInterceptorRegistry(ir)}
+	if config.SettingEngine != nil {
+		options = append(options, webrtc.WithSettingEngine(*config.SettingEngine))
+	}
+
+	c := &Client{
+		config: config,
+		api:    webrtc.NewAPI(options...),
+		events: make(chan func(), 64),
+		done:   make(chan struct{}),
+	}
+	go c.dispatch()
+
+	return c, nil
+}
+
+// Join the room of token, viewers only get a subscriber transport
+func (c *Client) Join(ctx context.Context, token *TokenView) error {
+	sub, err := c.api.NewPeerConnection(c.config.WebRTC)
+	if err != nil {
+		return err
+	}
+	c.sub = sub
+	c.bindTransport(sub, subscriber)
+	sub.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		if c.OnTrack != nil {
+			c.OnTrack(track, receiver)
+		}
+	})
+
+	request := &joinRequest{
+		Token:           token.Token,
+		Signature:       token.Signature,
+		NoAutoSubscribe: c.config.NoAutoSubscribe,
+	}
+
+	if !token.NoPublish {
+		pub, err := c.api.NewPeerConnection(c.config.WebRTC)
+		if err != nil {
+			return err
+		}
+		c.pub = pub
+		c.bindTransport(pub, publisher)
+
+		// an offer needs at least one media section or datachannel
+		if _, err := pub.CreateDataChannel(APIChannelLabel, nil); err != nil {
+			return err
+		}
+		offer, err := pub.CreateOffer(nil)
+		if err != nil {
+			return err
+		}
+		if err := pub.SetLocalDescription(offer); err != nil {
+			return err
+		}
+		request.Offer = &offer
+	}
+
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, signalURL(token.URL), nil)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.conn = jsonrpc2.NewConn(context.Background(), websocketjsonrpc2.NewObjectStream(ws), c)
+	c.mu.Unlock()
+
+	go func() {
+		<-c.conn.DisconnectNotify()
+		if c.OnClose != nil {
+			c.emit(c.OnClose)
+		}
+	}()
+
+	var response joinResponse
+	if err := c.conn.Call(ctx, "join", request, &response); err != nil {
+		return err
+	}
+	c.UID = token.UID
+	c.ResumeSecret = response.ResumeSecret
+
+	// candidates gathered before the node joined the transports
+	c.mu.Lock()
+	c.joined = true
+	local := c.local
+	c.local = nil
+	c.mu.Unlock()
+	for _, t := range local {
+		if err := c.notify(ctx, "trickle", t); err != nil {
+			return err
+		}
+	}
+
+	if c.pub != nil && response.SessionDescription != nil {
+		return c.setRemoteDescription(publisher, *response.SessionDescription)
+	}
+	return nil
+}
+
+// Publish a local track and renegotiate the publisher transport
+func (c *Client) Publish(ctx context.Context, tracks ...webrtc.TrackLocal) ([]*webrtc.RTPSender, error) {
+	if c.pub == nil {
+		return nil, ErrNoPublisher
+	}
+
+	senders := make([]*webrtc.RTPSender, 0, len(tracks))
+	for _, track := range tracks {
+		sender, err := c.pub.AddTrack(track)
+		if err != nil {
+			return nil, err
+		}
+		senders = append(senders, sender)
+
+		// read incoming RTCP so interceptors like NACK work
+		go func() {
+			buf := make([]byte, 1500)
+			for {
+				if _, _, err := sender.Read(buf); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	return senders, c.Renegotiate(ctx)
+}
+
+// Renegotiate the publisher transport after its tracks changed
+func (c *Client) Renegotiate(ctx context.Context) error {
+	if c.pub == nil {
+		return ErrNoPublisher
+	}
+
+	c.negotiate.Lock()
+	defer c.negotiate.Unlock()
+
+	offer, err := c.pub.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	if err := c.pub.SetLocalDescription(offer); err != nil {
+		return err
+	}
+
+	var answer webrtc.SessionDescription
+	if err := c.call(ctx, "offer", &negotiation{Desc: offer}, &answer); err != nil {
+		return err
+	}
+	return c.setRemoteDescription(publisher, answer)
+}
+
+// Mute sends a muteEvent for kind audio or video
+func (c *Client) Mute(ctx context.Context, kind string, muted bool) error {
+	return c.notify(ctx, "muteEvent", &MuteEvent{Kind: kind, Muted: muted})
+}
+
+// Subscribe to streams by ID or participant UID, for clients joined with NoAutoSubscribe
+func (c *Client) Subscribe(ctx context.Context, streamIDs []string, UIDs []string) ([]string, error) {
+	var subscribed []string
+	err := c.call(ctx, "subscribe", &subscribeRequest{StreamIDs: streamIDs, UIDs: UIDs}, &subscribed)
+	return subscribed, err
+}
+
+// Unsubscribe from streams by ID or participant UID
+func (c *Client) Unsubscribe(ctx context.Context, streamIDs []string, UIDs []string) ([]string, error) {
+	var unsubscribed []string
+	err := c.call(ctx, "unsubscribe", &subscribeRequest{StreamIDs: streamIDs, UIDs: UIDs}, &unsubscribed)
+	return unsubscribed, err
+}
+
+// Call any JSON-RPC method of the node
+func (c *Client) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	return c.call(ctx, method, params, result)
+}
+
+// End leaves the room, a host ends it for everyone
+func (c *Client) End(ctx context.Context) error {
+	return c.notify(ctx, "end", struct{}{})
+}
+
+// Publisher transport, nil for viewers
+func (c *Client) Publisher() *webrtc.PeerConnection {
+	return c.pub
+}
+
+// Subscriber transport
+func (c *Client) Subscriber() *webrtc.PeerConnection {
+	return c.sub
+}
+
+// Close signaling and both transports
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	conn := c.conn
+	c.mu.Unlock()
+
+	var errs []string
+	if conn != nil {
+		if err := conn.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	for _, pc := range []*webrtc.PeerConnection{c.pub, c.sub} {
+		if pc == nil {
+			continue
+		}
+		if err := pc.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("close: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// Handle notifications from the node, negotiation is handled in order on the
+// signaling goroutine and callbacks are emitted to the dispatch goroutine
+func (c *Client) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+	if req.Params == nil {
+		return
+	}
+	params := *req.Params
+
+	switch req.Method {
+	case "offer":
+		var offer webrtc.SessionDescription
+		if err := json.Unmarshal(params, &offer); err != nil {
+			return
+		}
+		answer, err := c.answer(offer)
+		if err != nil {
+			return
+		}
+		_ = conn.Notify(ctx, "answer", &negotiation{Desc: *answer})
+
+	case "trickle":
+		var t trickle
+		if err := json.Unmarshal(params, &t); err != nil {
+			return
+		}
+		_ = c.addICECandidate(t.Target, t.Candidate)
+
+	case "participants":
+		var participants map[string]*Participant
+		if err := json.Unmarshal(params, &participants); err == nil && c.OnParticipants != nil {
+			c.emit(func() { c.OnParticipants(participants) })
+		}
+
+	case "onJoin", "onLeave", "onStream", "muteEvent":
+		var message roomMessage
+		if err := json.Unmarshal(params, &message); err != nil || message.Participant == nil {
+			return
+		}
+		participant := message.Participant
+		switch {
+		case req.Method == "onJoin" && c.OnJoin != nil:
+			c.emit(func() { c.OnJoin(participant) })
+		case req.Method == "onLeave" && c.OnLeave != nil:
+			c.emit(func() { c.OnLeave(participant) })
+		case req.Method == "onStream" && c.OnStream != nil:
+			c.emit(func() { c.OnStream(participant) })
+		case req.Method == "muteEvent" && c.OnMuteEvent != nil:
+			var event MuteEvent
+			if err := json.Unmarshal(message.Payload, &event); err == nil {
+				c.emit(func() { c.OnMuteEvent(participant, event) })
+			}
+		}
+
+	case "participantsCount":
+		var message roomMessage
+		if err := json.Unmarshal(params, &message); err != nil {
+			return
+		}
+		var count ParticipantsCount
+		if err := json.Unmarshal(message.Payload, &count); err == nil && c.OnParticipantsCount != nil {
+			c.emit(func() { c.OnParticipantsCount(count) })
+		}
+
+	default:
+		if c.OnNotification != nil {
+			c.emit(func() { c.OnNotification(req.Method, params) })
+		}
+	}
+}
+
+// emit a callback to the dispatch goroutine
+func (c *Client) emit(f func()) {
+	select {
+	case c.events <- f:
+	case <-c.done:
+	}
+}
+
+func (c *Client) dispatch() {
+	for {
+		select {
+		case f := <-c.events:
+			f()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) bindTransport(pc *webrtc.PeerConnection, target int) {
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil {
+			return
+		}
+		t := &trickle{Target: target, Candidate: candidate.ToJSON()}
+
+		c.mu.Lock()
+		if !c.joined {
+			c.local = append(c.local, t)
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Unlock()
+
+		_ = c.notify(context.Background(), "trickle", t)
+	})
+}
+
+// answer an offer of the node on the subscriber transport
+func (c *Client) answer(offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	if err := c.setRemoteDescription(subscriber, offer); err != nil {
+		return nil, err
+	}
+	answer, err := c.sub.CreateAnswer(nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.sub.SetLocalDescription(answer); err != nil {
+		return nil, err
+	}
+	return &answer, nil
+}
+
+// setRemoteDescription and add the candidates trickled before it
+func (c *Client) setRemoteDescription(target int, desc webrtc.SessionDescription) error {
+	pc := c.transport(target)
+	if err := pc.SetRemoteDescription(desc); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	candidates := c.candidates[target]
+	c.candidates[target] = nil
+	c.mu.Unlock()
+
+	for _, candidate := range candidates {
+		if err := pc.AddICECandidate(candidate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) addICECandidate(target int, candidate webrtc.ICECandidateInit) error {
+	pc := c.transport(target)
+	if pc == nil {
+		return ErrNoPublisher
+	}
+
+	c.mu.Lock()
+	if pc.RemoteDescription() == nil {
+		c.candidates[target] = append(c.candidates[target], candidate)
+		c.mu.Unlock()
+		return nil
+	}
+	c.mu.Unlock()
+
+	return pc.AddICECandidate(candidate)
+}
+
+func (c *Client) transport(target int) *webrtc.PeerConnection {
+	if target == publisher {
+		return c.pub
+	}
+	return c.sub
+}
+
+func (c *Client) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return ErrNotJoined
+	}
+	return conn.Call(ctx, method, params, result)
+}
+
+func (c *Client) notify(ctx context.Context, method string, params interface{}) error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return ErrNotJoined
+	}
+	return conn.Notify(ctx, method, params)
+}
+
+// signalURL of a node host, the client backend returns hosts without scheme
+func signalURL(host string) string {
+	if strings.HasPrefix(host, "ws://") || strings.HasPrefix(host, "wss://") {
+		return host
+	}
+	return "wss://" + host
+}
@@ -0,0 +1,208 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+	"github.com/sourcegraph/jsonrpc2"
+	websocketjsonrpc2 "github.com/sourcegraph/jsonrpc2/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signalHandler answers like the Participant of a node: join answers the
+// publisher offer and the subscriber transport is offered by the node. It
+// runs on the goroutines of the connection, so failures don't stop the test
+type signalHandler struct {
+	t        *testing.T
+	pub      *webrtc.PeerConnection
+	sub      *webrtc.PeerConnection
+	answered chan struct{}
+	muted    chan MuteEvent
+}
+
+func (h *signalHandler) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+	switch req.Method {
+	case "join":
+		var request joinRequest
+		if !assert.NoError(h.t, json.Unmarshal(*req.Params, &request)) {
+			return
+		}
+		if request.Token != "token" {
+			rpcErr := &jsonrpc2.Error{Code: CodeTokenExpired, Message: "token already used"}
+			rpcErr.SetError(&ErrorData{Reason: "token_used"})
+			_ = conn.ReplyWithError(ctx, req.ID, rpcErr)
+			return
+		}
+		if !assert.NotNil(h.t, request.Offer) {
+			return
+		}
+		if !assert.NoError(h.t, h.pub.SetRemoteDescription(*request.Offer)) {
+			return
+		}
+		answer, err := h.pub.CreateAnswer(nil)
+		if !assert.NoError(h.t, err) {
+			return
+		}
+		if !assert.NoError(h.t, h.pub.SetLocalDescription(answer)) {
+			return
+		}
+		_ = conn.Reply(ctx, req.ID, &joinResponse{SessionDescription: &answer, ResumeSecret: "secret"})
+
+		participant := &Participant{SID: "room", UID: "other", Name: "other"}
+		_ = conn.Notify(ctx, "participants", map[string]*Participant{"other": participant})
+		_ = conn.Notify(ctx, "onJoin", &roomMessage{Participant: participant})
+		_ = conn.Notify(ctx, "participantsCount", &roomMessage{Payload: json.RawMessage(`{"participantsCount":2,"viewersCount":1}`)})
+
+		_, err = h.sub.CreateDataChannel("node", nil)
+		if !assert.NoError(h.t, err) {
+			return
+		}
+		offer, err := h.sub.CreateOffer(nil)
+		if !assert.NoError(h.t, err) {
+			return
+		}
+		if !assert.NoError(h.t, h.sub.SetLocalDescription(offer)) {
+			return
+		}
+		_ = conn.Notify(ctx, "offer", &offer)
+
+	case "answer":
+		var negotiation negotiation
+		if !assert.NoError(h.t, json.Unmarshal(*req.Params, &negotiation)) {
+			return
+		}
+		if !assert.NoError(h.t, h.sub.SetRemoteDescription(negotiation.Desc)) {
+			return
+		}
+		close(h.answered)
+
+	case "subscribe":
+		var request subscribeRequest
+		if !assert.NoError(h.t, json.Unmarshal(*req.Params, &request)) {
+			return
+		}
+		_ = conn.Reply(ctx, req.ID, append(request.StreamIDs, "stream-of-"+request.UIDs[0]))
+
+	case "muteEvent":
+		var event MuteEvent
+		if !assert.NoError(h.t, json.Unmarshal(*req.Params, &event)) {
+			return
+		}
+		h.muted <- event
+	}
+}
+
+// newSignalServer serving one signaling connection with handler
+func newSignalServer(t *testing.T) (*httptest.Server, *signalHandler) {
+	pub, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	sub, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	handler := &signalHandler{t: t, pub: pub, sub: sub, answered: make(chan struct{}), muted: make(chan MuteEvent, 1)}
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer ws.Close()
+
+		conn := jsonrpc2.NewConn(r.Context(), websocketjsonrpc2.NewObjectStream(ws), jsonrpc2.AsyncHandler(handler))
+		<-conn.DisconnectNotify()
+	}))
+	t.Cleanup(func() {
+		server.Close()
+		_ = pub.Close()
+		_ = sub.Close()
+	})
+	return server, handler
+}
+
+func TestJoin(t *testing.T) {
+	server, handler := newSignalServer(t)
+
+	c, err := New(Config{})
+	require.NoError(t, err)
+	defer c.Close()
+
+	participants := make(chan map[string]*Participant, 1)
+	joined := make(chan *Participant, 1)
+	counts := make(chan ParticipantsCount, 1)
+	c.OnParticipants = func(p map[string]*Participant) { participants <- p }
+	c.OnJoin = func(p *Participant) { joined <- p }
+	c.OnParticipantsCount = func(count ParticipantsCount) { counts <- count }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	URL := "ws://" + strings.TrimPrefix(server.URL, "http://")
+	require.NoError(t, c.Join(ctx, &TokenView{Token: "token", Signature: "signature", URL: URL, UID: "uid"}))
+	assert.Equal(t, "uid", c.UID)
+	assert.Equal(t, "secret", c.ResumeSecret)
+	assert.NotNil(t, c.Publisher().RemoteDescription(), "the publisher offer was answered")
+
+	select {
+	case p := <-participants:
+		assert.Contains(t, p, "other")
+	case <-ctx.Done():
+		t.Fatal("participants not received")
+	}
+	select {
+	case p := <-joined:
+		assert.Equal(t, "other", p.UID)
+	case <-ctx.Done():
+		t.Fatal("onJoin not received")
+	}
+	select {
+	case count := <-counts:
+		assert.Equal(t, ParticipantsCount{ParticipantsCount: 2, ViewersCount: 1}, count)
+	case <-ctx.Done():
+		t.Fatal("participantsCount not received")
+	}
+	select {
+	case <-handler.answered:
+		assert.NotNil(t, c.Subscriber().LocalDescription(), "the subscriber offer was answered")
+	case <-ctx.Done():
+		t.Fatal("subscriber offer not answered")
+	}
+
+	streamIDs, err := c.Subscribe(ctx, []string{"stream"}, []string{"other"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"stream", "stream-of-other"}, streamIDs)
+
+	require.NoError(t, c.Mute(ctx, "audio", false))
+	select {
+	case event := <-handler.muted:
+		assert.Equal(t, MuteEvent{Kind: "audio", Muted: false}, event)
+	case <-ctx.Done():
+		t.Fatal("muteEvent not received")
+	}
+}
+
+func TestJoinError(t *testing.T) {
+	server, _ := newSignalServer(t)
+
+	c, err := New(Config{})
+	require.NoError(t, err)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	URL := "ws://" + strings.TrimPrefix(server.URL, "http://")
+	err = c.Join(ctx, &TokenView{Token: "used", Signature: "signature", URL: URL, UID: "uid"})
+	require.Error(t, err)
+	assert.True(t, IsTokenExpired(err))
+	e, ok := AsError(err)
+	require.True(t, ok)
+	assert.Equal(t, "token_used", e.Reason)
+}
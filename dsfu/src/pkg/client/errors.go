@@ -0,0 +1,77 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/sourcegraph/jsonrpc2"
+)
+
+// Codes of the errors replied by the node, the reason of the data tells the cause
+const (
+	CodeBadRequest    = 400
+	CodeTokenInvalid  = 401
+	CodeForbidden     = 403
+	CodeNotFound      = 404
+	CodeConflict      = 409
+	CodeRoomEnded     = 410
+	CodeTokenExpired  = 440
+	CodeInternal      = 500
+	CodeMisconfigured = 502
+	CodeRetry         = 503
+)
+
+// ErrorData of an error replied by the node
+type ErrorData struct {
+	Reason string `json:"reason"`
+	Retry  bool   `json:"retry"`
+}
+
+// Error replied by the node to a request
+type Error struct {
+	Code    int64
+	Message string
+	ErrorData
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d %s)", e.Message, e.Code, e.Reason)
+}
+
+// AsError replied by the node, false for errors of the connection
+func AsError(err error) (*Error, bool) {
+	var rpcErr *jsonrpc2.Error
+	if !errors.As(err, &rpcErr) {
+		return nil, false
+	}
+	e := &Error{Code: rpcErr.Code, Message: rpcErr.Message}
+	if rpcErr.Data != nil {
+		_ = json.Unmarshal(*rpcErr.Data, &e.ErrorData)
+	}
+	return e, true
+}
+
+// IsRetry the request later, possibly with a token for another node
+func IsRetry(err error) bool {
+	e, ok := AsError(err)
+	return ok && e.Retry
+}
+
+// IsTokenExpired or already used, a new token is needed
+func IsTokenExpired(err error) bool {
+	e, ok := AsError(err)
+	return ok && e.Code == CodeTokenExpired
+}
+
+// IsRoomEnded the room was closed and can't be joined again
+func IsRoomEnded(err error) bool {
+	e, ok := AsError(err)
+	return ok && e.Code == CodeRoomEnded
+}
+
+// IsMisconfigured node, another node should be joined
+func IsMisconfigured(err error) bool {
+	e, ok := AsError(err)
+	return ok && e.Code == CodeMisconfigured
+}
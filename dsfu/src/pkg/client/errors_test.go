@@ -0,0 +1,36 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/sourcegraph/jsonrpc2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsError(t *testing.T) {
+	rpcErr := &jsonrpc2.Error{Code: CodeTokenExpired, Message: "token already used"}
+	rpcErr.SetError(&ErrorData{Reason: "token_used"})
+
+	e, ok := AsError(fmt.Errorf("join: %w", rpcErr))
+	require.True(t, ok)
+	assert.Equal(t, int64(CodeTokenExpired), e.Code)
+	assert.Equal(t, "token_used", e.Reason)
+	assert.False(t, e.Retry)
+	assert.True(t, IsTokenExpired(rpcErr))
+	assert.False(t, IsRoomEnded(rpcErr))
+
+	_, ok = AsError(errors.New("websocket closed"))
+	assert.False(t, ok)
+}
+
+func TestIsRetry(t *testing.T) {
+	rpcErr := &jsonrpc2.Error{Code: CodeRetry, Message: "node draining"}
+	rpcErr.SetError(&ErrorData{Reason: "draining", Retry: true})
+	assert.True(t, IsRetry(rpcErr))
+
+	// nodes before the error catalogue reply without data
+	assert.False(t, IsRetry(&jsonrpc2.Error{Code: 500, Message: "room closed"}))
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// JoinRequest to the client backend for a signed token
+type JoinRequest struct {
+	SID       string `json:"sid"`
+	Name      string `json:"name"`
+	NoPublish bool   `json:"noPublish"`
+}
+
+// TokenView returned by the client backend, URL is the host of the node to join
+type TokenView struct {
+	Token     string `json:"token"`
+	Signature string `json:"signature"`
+	URL       string `json:"url"`
+	SID       string `json:"sid"`
+	UID       string `json:"uid"`
+	Key       string `json:"key"`
+	// NoPublish of the request, viewers join without a publisher transport
+	NoPublish bool `json:"-"`
+}
+
+// GetToken of a room from the client backend at backendURL
+func GetToken(ctx context.Context, backendURL string, request *JoinRequest) (*TokenView, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(backendURL, "/")+"/api/room/join", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get token: %s", res.Status)
+	}
+
+	var token TokenView
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+	token.NoPublish = request.NoPublish
+
+	return &token, nil
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/room/join", r.URL.Path)
+		assert.Equal(t, http.MethodPost, r.Method)
+
+		var request JoinRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.Equal(t, "room", request.SID)
+		assert.Equal(t, "bot", request.Name)
+
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"token":     "dG9rZW4=",
+			"signature": "c2lnbmF0dXJl",
+			"url":       "node.dtelecom.org",
+			"sid":       request.SID,
+			"uid":       "uid",
+		})
+	}))
+	defer server.Close()
+
+	token, err := GetToken(context.Background(), server.URL+"/", &JoinRequest{SID: "room", Name: "bot", NoPublish: true})
+	require.NoError(t, err)
+	assert.Equal(t, "node.dtelecom.org", token.URL)
+	assert.Equal(t, "uid", token.UID)
+	assert.True(t, token.NoPublish)
+}
+
+func TestSignalURL(t *testing.T) {
+	assert.Equal(t, "wss://node.dtelecom.org", signalURL("node.dtelecom.org"))
+	assert.Equal(t, "ws://127.0.0.1:8080", signalURL("ws://127.0.0.1:8080"))
+}
@@ -5,7 +5,7 @@ import (
 	"context"
 	"time"
 
-	"main/pkg/sfu"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/sfu"
 )
 
 func KeepAlive(timeout time.Duration) func(next sfu.MessageProcessor) sfu.MessageProcessor {
@@ -5,8 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/sfu"
 	"github.com/pion/webrtc/v3"
-	"main/pkg/sfu"
 )
 
 const (
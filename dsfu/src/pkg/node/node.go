@@ -41,6 +41,9 @@ type Node interface {
 
 	JoinRoom(roomName string, nickname string, onMessage OnMessage) error
 	LeaveRoom(roomName string) error
+
+	Peers() []peer.ID
+	TopicPeers(roomName string) []peer.ID
 }
 
 type node struct {
@@ -204,6 +207,22 @@ func (n *node) LeaveRoom(roomName string) error {
 	return nil
 }
 
+// Peers the host is connected to
+func (n *node) Peers() []peer.ID {
+	if n.host == nil {
+		return nil
+	}
+	return n.host.Network().Peers()
+}
+
+// TopicPeers of a joined room
+func (n *node) TopicPeers(roomName string) []peer.ID {
+	if n.roomManager == nil {
+		return nil
+	}
+	return n.roomManager.TopicPeers(roomName)
+}
+
 func (n *node) getPrivateKey() (crypto.PrivKey, error) {
 
 	var generate bool
@@ -124,6 +124,15 @@ func (r *RoomManager) HasJoined(roomName string) bool {
 	return found
 }
 
+// TopicPeers returns the peers subscribed to the topic of a joined room.
+func (r *RoomManager) TopicPeers(roomName string) []peer.ID {
+	room, found := r.getRoom(roomName)
+	if !found {
+		return nil
+	}
+	return room.topic.ListPeers()
+}
+
 // TopicName builds a string containing the name of the pubsub topic for a given room name.
 func (r *RoomManager) TopicName(roomName string) string {
 	return fmt.Sprintf("webrtc/room/%s", roomName)
@@ -0,0 +1,68 @@
+package recorder
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+const ivfHeaderSize = 32
+
+// IVFWriter writes VP8 or VP9 frames to an IVF file
+type IVFWriter struct {
+	file       *os.File
+	frameCount uint32
+}
+
+// NewIVFWriter creates the file and writes the IVF header for fourcc, e.g. VP80 or VP90
+func NewIVFWriter(fileName string, fourcc string, clockRate uint32) (*IVFWriter, error) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, ivfHeaderSize)
+	copy(header[0:], "DKIF")
+	binary.LittleEndian.PutUint16(header[4:], 0)             // version
+	binary.LittleEndian.PutUint16(header[6:], ivfHeaderSize) // header size
+	copy(header[8:], fourcc)
+	// width and height are left to the decoder, they are in the key frames
+	binary.LittleEndian.PutUint32(header[16:], clockRate) // time base denominator
+	binary.LittleEndian.PutUint32(header[20:], 1)         // time base numerator
+
+	if _, err := file.Write(header); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &IVFWriter{file: file}, nil
+}
+
+// WriteFrame with its timestamp in clock rate units
+func (w *IVFWriter) WriteFrame(frame []byte, timestamp uint64) error {
+	header := make([]byte, 12)
+	binary.LittleEndian.PutUint32(header[0:], uint32(len(frame)))
+	binary.LittleEndian.PutUint64(header[4:], timestamp)
+	if _, err := w.file.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.file.Write(frame); err != nil {
+		return err
+	}
+	w.frameCount++
+	return nil
+}
+
+// Close updates the frame count in the header and closes the file
+func (w *IVFWriter) Close() error {
+	count := make([]byte, 4)
+	binary.LittleEndian.PutUint32(count, w.frameCount)
+	if _, err := w.file.Seek(24, io.SeekStart); err != nil {
+		_ = w.file.Close()
+		return err
+	}
+	if _, err := w.file.Write(count); err != nil {
+		_ = w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
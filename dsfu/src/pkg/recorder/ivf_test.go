@@ -0,0 +1,33 @@
+package recorder
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIVFWriter(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "track.ivf")
+
+	w, err := NewIVFWriter(fileName, "VP80", 90000)
+	assert.NoError(t, err)
+	assert.NoError(t, w.WriteFrame([]byte{1, 2, 3}, 0))
+	assert.NoError(t, w.WriteFrame([]byte{4, 5}, 3000))
+	assert.NoError(t, w.Close())
+
+	data, err := os.ReadFile(fileName)
+	assert.NoError(t, err)
+	assert.Equal(t, ivfHeaderSize+12+3+12+2, len(data))
+	assert.Equal(t, "DKIF", string(data[0:4]))
+	assert.Equal(t, "VP80", string(data[8:12]))
+	assert.Equal(t, uint32(90000), binary.LittleEndian.Uint32(data[16:]))
+	assert.Equal(t, uint32(2), binary.LittleEndian.Uint32(data[24:]))
+
+	second := data[ivfHeaderSize+12+3:]
+	assert.Equal(t, uint32(2), binary.LittleEndian.Uint32(second[0:]))
+	assert.Equal(t, uint64(3000), binary.LittleEndian.Uint64(second[4:]))
+	assert.Equal(t, []byte{4, 5}, second[12:])
+}
@@ -0,0 +1,359 @@
+package recorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/sfu"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+	"github.com/pion/webrtc/v3/pkg/media/samplebuilder"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrUnsupportedCodec track codec can't be recorded
+var ErrUnsupportedCodec = errors.New("unsupported codec")
+
+// maxLate packets kept to rebuild video frames
+const maxLate = 256
+
+// Manifest of a recording, written next to the track files
+type Manifest struct {
+	ID        string           `json:"id"`
+	SID       string           `json:"sid"`
+	StartedAt time.Time        `json:"startedAt"`
+	StoppedAt time.Time        `json:"stoppedAt"`
+	Tracks    []*TrackManifest `json:"tracks"`
+}
+
+// TrackManifest of a recorded track. Time zero of the file is FirstTimestamp,
+// SenderReports map RTP timestamps of the file to NTP wallclock so tracks
+// can be synchronised
+type TrackManifest struct {
+	UID            string         `json:"uid"`
+	StreamID       string         `json:"streamID"`
+	TrackID        string         `json:"trackID"`
+	Kind           string         `json:"kind"`
+	MimeType       string         `json:"mimeType"`
+	ClockRate      uint32         `json:"clockRate"`
+	File           string         `json:"file"`
+	StartedAt      time.Time      `json:"startedAt"`
+	StoppedAt      time.Time      `json:"stoppedAt"`
+	FirstTimestamp uint32         `json:"firstTimestamp"`
+	SenderReports  []SenderReport `json:"senderReports"`
+}
+
+// SenderReport maps an RTP timestamp of the file to NTP wallclock
+type SenderReport struct {
+	RTPTimestamp uint32 `json:"rtpTimestamp"`
+	NTPTime      uint64 `json:"ntpTime"`
+}
+
+// Recorder writes the tracks of a session to files, Opus to Ogg and VP8/VP9 to IVF
+type Recorder struct {
+	sync.Mutex
+
+	dir      string
+	manifest Manifest
+	tracks   map[string]*track
+	stopped  bool
+	stopCh   chan struct{}
+}
+
+// New recorder writing to a new directory in dir
+func New(dir string, SID string) (*Recorder, error) {
+	startedAt := time.Now()
+	ID := fmt.Sprintf("%s-%d", SID, startedAt.Unix())
+
+	dir = filepath.Join(dir, ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	r := &Recorder{
+		dir: dir,
+		manifest: Manifest{
+			ID:        ID,
+			SID:       SID,
+			StartedAt: startedAt,
+			Tracks:    make([]*TrackManifest, 0),
+		},
+		tracks: make(map[string]*track),
+		stopCh: make(chan struct{}),
+	}
+
+	go r.senderReports()
+
+	return r, nil
+}
+
+// ID of the recording
+func (r *Recorder) ID() string {
+	return r.manifest.ID
+}
+
+// Dir the recording is written to
+func (r *Recorder) Dir() string {
+	return r.dir
+}
+
+// Sync records the receivers of routers not recorded yet and stops tracks
+// whose receiver is gone. Routers are keyed by participant UID
+func (r *Recorder) Sync(routers map[string]sfu.Router) {
+	receivers := make(map[string]bool)
+	for UID, router := range routers {
+		for _, receiver := range router.GetReceiver() {
+			receivers[receiver.TrackID()] = true
+			if err := r.AddTrack(UID, router, receiver); err != nil && err != ErrUnsupportedCodec {
+				log.Error().Err(err).Msg("recorder")
+			}
+		}
+	}
+
+	r.Lock()
+	removed := make([]string, 0)
+	for trackID := range r.tracks {
+		if !receivers[trackID] {
+			removed = append(removed, trackID)
+		}
+	}
+	r.Unlock()
+
+	for _, trackID := range removed {
+		r.RemoveTrack(trackID)
+	}
+}
+
+// AddTrack records a receiver through a local DownTrack of its router
+func (r *Recorder) AddTrack(UID string, router sfu.Router, receiver sfu.Receiver) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.stopped {
+		return io.ErrClosedPipe
+	}
+	if _, ok := r.tracks[receiver.TrackID()]; ok {
+		return nil
+	}
+
+	codec := receiver.Codec()
+	t := &track{
+		receiver: receiver,
+		manifest: &TrackManifest{
+			UID:           UID,
+			StreamID:      receiver.StreamID(),
+			TrackID:       receiver.TrackID(),
+			Kind:          receiver.Kind().String(),
+			MimeType:      codec.MimeType,
+			ClockRate:     codec.ClockRate,
+			SenderReports: make([]SenderReport, 0),
+		},
+	}
+
+	var err error
+	fileName := fmt.Sprintf("%s-%s", UID, receiver.TrackID())
+	switch strings.ToLower(codec.MimeType) {
+	case strings.ToLower(webrtc.MimeTypeOpus):
+		t.manifest.File = fileName + ".ogg"
+		t.ogg, err = oggwriter.New(filepath.Join(r.dir, t.manifest.File), codec.ClockRate, codec.Channels)
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		t.manifest.File = fileName + ".ivf"
+		t.builder = samplebuilder.New(maxLate, &codecs.VP8Packet{}, codec.ClockRate)
+		t.ivf, err = NewIVFWriter(filepath.Join(r.dir, t.manifest.File), "VP80", codec.ClockRate)
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		t.manifest.File = fileName + ".ivf"
+		t.builder = samplebuilder.New(maxLate, &codecs.VP9Packet{}, codec.ClockRate)
+		t.ivf, err = NewIVFWriter(filepath.Join(r.dir, t.manifest.File), "VP90", codec.ClockRate)
+	default:
+		return ErrUnsupportedCodec
+	}
+	if err != nil {
+		return err
+	}
+
+	t.downTrack, err = router.AddLocalDownTrack(receiver, "recorder-"+r.manifest.ID, t)
+	if err != nil {
+		_ = t.close()
+		return err
+	}
+
+	r.tracks[receiver.TrackID()] = t
+	r.manifest.Tracks = append(r.manifest.Tracks, t.manifest)
+
+	log.Printf("recording track: %v %v %v", UID, receiver.TrackID(), codec.MimeType)
+
+	return nil
+}
+
+// RemoveTrack stops recording a track, its file is kept in the manifest
+func (r *Recorder) RemoveTrack(trackID string) {
+	r.Lock()
+	t, ok := r.tracks[trackID]
+	delete(r.tracks, trackID)
+	r.Unlock()
+
+	if ok {
+		if err := t.close(); err != nil {
+			log.Error().Err(err).Msg("recorder")
+		}
+	}
+}
+
+// Stop recording all tracks and write the manifest
+func (r *Recorder) Stop() (*Manifest, error) {
+	r.Lock()
+	if r.stopped {
+		r.Unlock()
+		return &r.manifest, nil
+	}
+	r.stopped = true
+	close(r.stopCh)
+	tracks := r.tracks
+	r.tracks = make(map[string]*track)
+	r.Unlock()
+
+	for _, t := range tracks {
+		if err := t.close(); err != nil {
+			log.Error().Err(err).Msg("recorder")
+		}
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.manifest.StoppedAt = time.Now()
+	data, err := json.MarshalIndent(&r.manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(r.dir, "manifest.json"), data, 0644); err != nil {
+		return nil, err
+	}
+	return &r.manifest, nil
+}
+
+// senderReports keeps the RTP to wallclock mapping of every track
+func (r *Recorder) senderReports() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case <-ticker.C:
+			r.Lock()
+			for _, t := range r.tracks {
+				t.senderReport()
+			}
+			r.Unlock()
+		}
+	}
+}
+
+// track writes the packets of a local DownTrack to a file
+type track struct {
+	sync.Mutex
+
+	receiver  sfu.Receiver
+	downTrack *sfu.DownTrack
+	manifest  *TrackManifest
+	ogg       *oggwriter.OggWriter
+	ivf       *IVFWriter
+	builder   *samplebuilder.SampleBuilder
+	started   bool
+	closed    bool
+}
+
+// WriteRTP implements webrtc.TrackLocalWriter
+func (t *track) WriteRTP(header *rtp.Header, payload []byte) (int, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if !t.started {
+		t.started = true
+		t.manifest.StartedAt = time.Now()
+		t.manifest.FirstTimestamp = header.Timestamp
+	}
+
+	// the payload buffer is reused by the DownTrack
+	packet := &rtp.Packet{Header: *header, Payload: append([]byte{}, payload...)}
+
+	if t.ogg != nil {
+		if err := t.ogg.WriteRTP(packet); err != nil {
+			return 0, err
+		}
+		return len(payload), nil
+	}
+
+	t.builder.Push(packet)
+	for sample := t.builder.Pop(); sample != nil; sample = t.builder.Pop() {
+		timestamp := uint64(sample.PacketTimestamp - t.manifest.FirstTimestamp)
+		if err := t.ivf.WriteFrame(sample.Data, timestamp); err != nil {
+			return 0, err
+		}
+	}
+	return len(payload), nil
+}
+
+// Write implements webrtc.TrackLocalWriter
+func (t *track) Write(b []byte) (int, error) {
+	packet := &rtp.Packet{}
+	if err := packet.Unmarshal(b); err != nil {
+		return 0, err
+	}
+	return t.WriteRTP(&packet.Header, packet.Payload)
+}
+
+func (t *track) senderReport() {
+	if t.downTrack == nil {
+		return
+	}
+	rtpTS, ntpTS := t.receiver.GetSenderReportTime(t.downTrack.CurrentSpatialLayer())
+	if ntpTS == 0 {
+		return
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	reports := t.manifest.SenderReports
+	if len(reports) > 0 && reports[len(reports)-1].NTPTime == ntpTS {
+		return
+	}
+	t.manifest.SenderReports = append(reports, SenderReport{
+		RTPTimestamp: rtpTS - t.downTrack.TimestampOffset(),
+		NTPTime:      ntpTS,
+	})
+}
+
+func (t *track) close() error {
+	if t.downTrack != nil {
+		t.receiver.RemoveDownTrack(t.downTrack)
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	t.manifest.StoppedAt = time.Now()
+
+	if t.ogg != nil {
+		return t.ogg.Close()
+	}
+	return t.ivf.Close()
+}
@@ -0,0 +1,279 @@
+// Package roomstate tracks the versions of the room state published by the
+// other nodes, deciding which deltas apply and when a snapshot is needed
+package roomstate
+
+import (
+	"sync"
+	"time"
+)
+
+// DigestInterval in observer ticks between the state digests of a node
+const DigestInterval = 10
+
+// HostTimeout after which the participants of a silent node are dropped
+const HostTimeout = 3 * DigestInterval * time.Second
+
+// GapTimeout a missing delta may be in flight before a snapshot is requested
+const GapTimeout = time.Second
+
+// MaxGap of missing deltas tracked, a snapshot is requested right away beyond it
+const MaxGap = 64
+
+// Version of the local participants of a node in a room. Seq is
+// incremented by every delta, Epoch changes when the node recreates the room
+type Version struct {
+	Epoch string `json:"epoch"`
+	Seq   uint64 `json:"seq"`
+}
+
+// Delta of a participant, UID is empty for deltas carrying the viewers count only
+type Delta struct {
+	Version
+	Method string
+	UID    string
+}
+
+// host state of a remote node in a room
+type host struct {
+	version Version
+	// missing deltas by sequence, with the time the gap was seen
+	missing map[uint64]time.Time
+	// applied sequence by UID, kept after leaves so older deltas are ignored
+	applied map[string]uint64
+	// sequences of the last join and leave by UID, a participant who left
+	// stays left whatever older deltas arrive until it joins again
+	joined map[string]uint64
+	left   map[string]uint64
+	// sequence of the viewers count applied, every delta carries it
+	viewersSeq uint64
+	// sequence of the last snapshot applied, older deltas are part of it
+	snapshotSeq uint64
+	// resync until a snapshot of at least resyncSeq arrives, deltas were
+	// missed before the first seen or beyond MaxGap
+	resyncSeq   uint64
+	resyncAt    time.Time
+	seenAt      time.Time
+	requestedAt time.Time
+}
+
+// Tracker of the remote nodes of a room
+type Tracker struct {
+	mu    sync.Mutex
+	hosts map[string]*host
+	now   func() time.Time
+}
+
+// NewTracker without known nodes
+func NewTracker() *Tracker {
+	return &Tracker{hosts: make(map[string]*host), now: time.Now}
+}
+
+// Delta tracks the version of a delta of hostID. Deltas arrive out of
+// order, a leave is applied unless a newer delta of UID was applied or UID
+// joined again after it, other deltas when they are newer than the last
+// applied to UID and its last leave. Deltas older than the last snapshot are
+// part of it. The viewers count is applied when it is the newest. Resync
+// asks for a snapshot
+func (t *Tracker) Delta(hostID string, delta *Delta) (apply bool, viewers bool, resync bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	version := delta.Version
+	state, fresh := t.hostOf(hostID, version.Epoch)
+	resync = fresh && version.Seq != 1
+
+	if version.Seq > state.version.Seq {
+		gap := version.Seq - state.version.Seq - 1
+		if gap > MaxGap {
+			resync = true
+		} else if !fresh {
+			now := t.now()
+			for seq := state.version.Seq + 1; seq < version.Seq; seq++ {
+				state.missing[seq] = now
+			}
+		}
+		state.version.Seq = version.Seq
+	} else {
+		delete(state.missing, version.Seq)
+	}
+
+	if resync && version.Seq > state.resyncSeq {
+		state.resyncSeq = version.Seq
+		state.resyncAt = t.now()
+	}
+
+	if version.Seq > state.viewersSeq {
+		state.viewersSeq = version.Seq
+		viewers = true
+	}
+
+	UID := delta.UID
+	seq := version.Seq
+	if UID == "" || seq <= state.snapshotSeq {
+		return false, viewers, resync
+	}
+	switch delta.Method {
+	case "onLeave":
+		if seq < state.joined[UID] || seq < state.applied[UID] {
+			return false, viewers, resync
+		}
+		if seq > state.left[UID] {
+			state.left[UID] = seq
+		}
+		if seq > state.applied[UID] {
+			state.applied[UID] = seq
+		}
+		return true, viewers, resync
+	case "onJoin":
+		if seq > state.joined[UID] {
+			state.joined[UID] = seq
+		}
+	}
+	if seq <= state.applied[UID] || seq < state.left[UID] {
+		return false, viewers, resync
+	}
+	// newer than the last leave, UID joined again even if its join is in flight
+	state.applied[UID] = seq
+	return true, viewers, resync
+}
+
+// Snapshot tracks the snapshot of hostID with the participants UIDs, online
+// are the participants of hostID known by this node. Participants updated by
+// newer deltas are returned in newer and kept, apply is false for snapshots
+// answering other nodes which bring nothing new
+func (t *Tracker) Snapshot(hostID string, version Version, UIDs []string, online []string) (apply bool, newer map[string]bool, viewers bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, fresh := t.hostOf(hostID, version.Epoch)
+	if !fresh && state.resyncSeq == 0 && version.Seq <= state.version.Seq && len(state.missing) == 0 {
+		return false, nil, false
+	}
+	if version.Seq >= state.resyncSeq {
+		state.resyncSeq = 0
+	}
+	if version.Seq > state.version.Seq {
+		state.version.Seq = version.Seq
+	}
+	if version.Seq > state.snapshotSeq {
+		state.snapshotSeq = version.Seq
+	}
+	for seq := range state.missing {
+		if seq <= version.Seq {
+			delete(state.missing, seq)
+		}
+	}
+	newer = make(map[string]bool)
+	for UID, seq := range state.applied {
+		if seq > version.Seq {
+			newer[UID] = true
+		}
+	}
+	included := make(map[string]bool, len(UIDs))
+	for _, UID := range UIDs {
+		included[UID] = true
+		if !newer[UID] {
+			state.applied[UID] = version.Seq
+			state.joined[UID] = version.Seq
+		}
+	}
+	for _, UID := range online {
+		if !included[UID] && !newer[UID] {
+			state.left[UID] = version.Seq
+		}
+	}
+	viewers = version.Seq > state.viewersSeq
+	if viewers {
+		state.viewersSeq = version.Seq
+	}
+	return true, newer, viewers
+}
+
+// Check the version of hostID published by a digest or a request, true if
+// its snapshot is needed because this node does not know it yet or missed deltas
+func (t *Tracker) Check(hostID string, version Version) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, fresh := t.hostOf(hostID, version.Epoch)
+	behind := version.Seq > state.version.Seq
+	return (fresh && version.Seq > 0) || behind
+}
+
+// Expire the nodes silent for HostTimeout, behind are the nodes whose
+// missing deltas or snapshot did not arrive within GapTimeout. Tombstones of participants
+// who left are dropped once no delta is missing, online tells if a
+// participant is still known
+func (t *Tracker) Expire(online func(UID string) bool) (expired []string, behind []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	for hostID, state := range t.hosts {
+		if now.Sub(state.seenAt) > HostTimeout {
+			expired = append(expired, hostID)
+			delete(t.hosts, hostID)
+			continue
+		}
+		late := state.resyncSeq > 0 && now.Sub(state.resyncAt) > GapTimeout
+		for _, seenAt := range state.missing {
+			late = late || now.Sub(seenAt) > GapTimeout
+		}
+		if late {
+			behind = append(behind, hostID)
+		}
+		if len(state.missing) == 0 && state.resyncSeq == 0 {
+			for UID := range state.applied {
+				if !online(UID) {
+					delete(state.applied, UID)
+					delete(state.joined, UID)
+					delete(state.left, UID)
+				}
+			}
+		}
+	}
+	return expired, behind
+}
+
+// Request the snapshot of hostID, false if it was requested within GapTimeout
+func (t *Tracker) Request(hostID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.hosts[hostID]
+	if !ok {
+		return true
+	}
+	now := t.now()
+	if now.Sub(state.requestedAt) < GapTimeout {
+		return false
+	}
+	state.requestedAt = now
+	return true
+}
+
+// Known when other nodes answered or published
+func (t *Tracker) Known() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.hosts) > 0
+}
+
+// hostOf hostID in epoch, the state is reset when the node recreated the
+// room. Callers hold mu
+func (t *Tracker) hostOf(hostID string, epoch string) (*host, bool) {
+	state, ok := t.hosts[hostID]
+	fresh := !ok || state.version.Epoch != epoch
+	if fresh {
+		state = &host{
+			version: Version{Epoch: epoch},
+			missing: make(map[uint64]time.Time),
+			applied: make(map[string]uint64),
+			joined:  make(map[string]uint64),
+			left:    make(map[string]uint64),
+		}
+		t.hosts[hostID] = state
+	}
+	state.seenAt = t.now()
+	return state, fresh
+}
@@ -0,0 +1,371 @@
+package roomstate
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clock of a tracker advanced by the tests
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestTracker() (*Tracker, *clock) {
+	c := &clock{now: time.Unix(1700000000, 0)}
+	t := NewTracker()
+	t.now = func() time.Time { return c.now }
+	return t, c
+}
+
+func delta(seq uint64, method string, UID string) *Delta {
+	return &Delta{Version: Version{Epoch: "epoch", Seq: seq}, Method: method, UID: UID}
+}
+
+func TestDeltaInOrder(t *testing.T) {
+	tracker, _ := newTestTracker()
+
+	apply, viewers, resync := tracker.Delta("host", delta(1, "onJoin", "a"))
+	assert.True(t, apply)
+	assert.True(t, viewers)
+	assert.False(t, resync)
+	assert.True(t, tracker.Known())
+
+	apply, _, _ = tracker.Delta("host", delta(2, "onStream", "a"))
+	assert.True(t, apply)
+	apply, _, _ = tracker.Delta("host", delta(3, "onLeave", "a"))
+	assert.True(t, apply)
+}
+
+func TestDeltaDuplicate(t *testing.T) {
+	tracker, _ := newTestTracker()
+
+	tracker.Delta("host", delta(1, "onJoin", "a"))
+	tracker.Delta("host", delta(2, "onStream", "a"))
+	apply, viewers, resync := tracker.Delta("host", delta(2, "onStream", "a"))
+	assert.False(t, apply, "a duplicate is applied once")
+	assert.False(t, viewers)
+	assert.False(t, resync)
+}
+
+func TestDeltaOutOfOrder(t *testing.T) {
+	tracker, _ := newTestTracker()
+
+	tracker.Delta("host", delta(1, "onJoin", "a"))
+	apply, viewers, _ := tracker.Delta("host", delta(3, "onLeave", "a"))
+	assert.True(t, apply)
+	assert.True(t, viewers)
+
+	// the update sent before the leave arrives after it
+	apply, viewers, _ = tracker.Delta("host", delta(2, "onStream", "a"))
+	assert.False(t, apply, "a participant who left stays left")
+	assert.False(t, viewers, "the viewers count of seq 3 is newer")
+
+	apply, _, _ = tracker.Delta("host", delta(4, "onJoin", "a"))
+	assert.True(t, apply, "a participant joining again is applied")
+}
+
+func TestDeltaLeaveBeforeJoin(t *testing.T) {
+	tracker, _ := newTestTracker()
+
+	tracker.Delta("host", delta(1, "onJoin", "a"))
+	tracker.Delta("host", delta(4, "onJoin", "a"))
+	apply, _, _ := tracker.Delta("host", delta(3, "onLeave", "a"))
+	assert.False(t, apply, "a leave older than the last join is ignored")
+}
+
+func TestDeltaViewersOnly(t *testing.T) {
+	tracker, _ := newTestTracker()
+
+	apply, viewers, _ := tracker.Delta("host", delta(1, "", ""))
+	assert.False(t, apply)
+	assert.True(t, viewers)
+}
+
+func TestDeltaGapRequestsSnapshot(t *testing.T) {
+	tracker, clock := newTestTracker()
+	online := func(string) bool { return true }
+
+	tracker.Delta("host", delta(1, "onJoin", "a"))
+	_, _, resync := tracker.Delta("host", delta(3, "onJoin", "b"))
+	assert.False(t, resync, "a missing delta may still be in flight")
+
+	expired, behind := tracker.Expire(online)
+	assert.Empty(t, expired)
+	assert.Empty(t, behind)
+
+	clock.advance(GapTimeout + time.Millisecond)
+	_, behind = tracker.Expire(online)
+	assert.Equal(t, []string{"host"}, behind)
+
+	assert.True(t, tracker.Request("host"))
+	assert.False(t, tracker.Request("host"), "requests are throttled")
+	clock.advance(GapTimeout)
+	assert.True(t, tracker.Request("host"))
+
+	// the missing delta arrives late
+	apply, _, _ := tracker.Delta("host", delta(2, "onStream", "a"))
+	assert.True(t, apply)
+	clock.advance(GapTimeout + time.Millisecond)
+	_, behind = tracker.Expire(online)
+	assert.Empty(t, behind)
+}
+
+func TestDeltaLargeGapResyncs(t *testing.T) {
+	tracker, _ := newTestTracker()
+
+	tracker.Delta("host", delta(1, "onJoin", "a"))
+	_, _, resync := tracker.Delta("host", delta(MaxGap+3, "onJoin", "b"))
+	assert.True(t, resync)
+}
+
+func TestDeltaUnknownHostResyncs(t *testing.T) {
+	tracker, _ := newTestTracker()
+
+	_, _, resync := tracker.Delta("host", delta(5, "onStream", "a"))
+	assert.True(t, resync, "deltas before the first seen are missed")
+
+	// the node recreated the room
+	_, _, resync = tracker.Delta("host", &Delta{Version: Version{Epoch: "other", Seq: 1}, Method: "onJoin", UID: "a"})
+	assert.False(t, resync)
+}
+
+func TestCheckDigest(t *testing.T) {
+	tracker, _ := newTestTracker()
+
+	assert.False(t, tracker.Check("host", Version{Epoch: "epoch", Seq: 0}), "a node without deltas")
+	assert.True(t, tracker.Check("other", Version{Epoch: "epoch", Seq: 2}), "an unknown node")
+
+	tracker.Delta("host", delta(1, "onJoin", "a"))
+	assert.False(t, tracker.Check("host", Version{Epoch: "epoch", Seq: 1}))
+	assert.True(t, tracker.Check("host", Version{Epoch: "epoch", Seq: 2}), "a missed delta")
+	assert.True(t, tracker.Check("host", Version{Epoch: "recreated", Seq: 1}), "a recreated room")
+}
+
+func TestHostTimeout(t *testing.T) {
+	tracker, clock := newTestTracker()
+	online := func(string) bool { return true }
+
+	tracker.Delta("host", delta(1, "onJoin", "a"))
+	clock.advance(HostTimeout / 2)
+	tracker.Delta("other", delta(1, "onJoin", "b"))
+
+	clock.advance(HostTimeout/2 + time.Second)
+	expired, _ := tracker.Expire(online)
+	assert.Equal(t, []string{"host"}, expired)
+	assert.True(t, tracker.Known())
+
+	clock.advance(HostTimeout)
+	expired, _ = tracker.Expire(online)
+	assert.Equal(t, []string{"other"}, expired)
+	assert.False(t, tracker.Known())
+
+	// a node seen again after its timeout is synced again
+	_, _, resync := tracker.Delta("host", delta(2, "onStream", "a"))
+	assert.True(t, resync)
+}
+
+func TestExpireTombstones(t *testing.T) {
+	tracker, _ := newTestTracker()
+
+	tracker.Delta("host", delta(1, "onJoin", "a"))
+	tracker.Delta("host", delta(2, "onLeave", "a"))
+	tracker.Expire(func(string) bool { return false })
+
+	// without its tombstone an older delta is no longer known as older, the
+	// tombstone is only dropped once nothing is in flight
+	apply, _, _ := tracker.Delta("host", delta(3, "onJoin", "a"))
+	assert.True(t, apply)
+}
+
+func TestSnapshot(t *testing.T) {
+	tracker, _ := newTestTracker()
+
+	tracker.Delta("host", delta(1, "onJoin", "a"))
+	tracker.Delta("host", delta(4, "onJoin", "c"))
+
+	// the snapshot of seq 3 answers the gap, c joined after it
+	apply, newer, viewers := tracker.Snapshot("host", Version{Epoch: "epoch", Seq: 3}, []string{"b"}, []string{"a", "c"})
+	require.True(t, apply)
+	assert.Equal(t, map[string]bool{"c": true}, newer)
+	assert.False(t, viewers, "the viewers count of seq 4 is newer")
+
+	// deltas covered by the snapshot are ignored
+	apply, _, _ = tracker.Delta("host", delta(2, "onStream", "a"))
+	assert.False(t, apply, "a left with the snapshot")
+	apply, _, _ = tracker.Delta("host", delta(3, "onStream", "b"))
+	assert.False(t, apply)
+	apply, _, _ = tracker.Delta("host", delta(5, "onStream", "b"))
+	assert.True(t, apply)
+
+	apply, _, _ = tracker.Snapshot("host", Version{Epoch: "epoch", Seq: 5}, []string{"b", "c"}, []string{"b", "c"})
+	assert.False(t, apply, "a snapshot answering another node brings nothing new")
+}
+
+func TestSnapshotResync(t *testing.T) {
+	tracker, clock := newTestTracker()
+	online := func(string) bool { return true }
+
+	_, _, resync := tracker.Delta("host", delta(5, "onJoin", "a"))
+	require.True(t, resync)
+
+	// an older snapshot answering another node is applied but does not
+	// cover the deltas missed up to seq 5
+	apply, _, _ := tracker.Snapshot("host", Version{Epoch: "epoch", Seq: 3}, []string{"b"}, nil)
+	assert.True(t, apply)
+	clock.advance(GapTimeout + time.Millisecond)
+	_, behind := tracker.Expire(online)
+	assert.Equal(t, []string{"host"}, behind)
+
+	apply, _, _ = tracker.Snapshot("host", Version{Epoch: "epoch", Seq: 5}, []string{"a", "b"}, []string{"a", "b"})
+	assert.True(t, apply)
+	clock.advance(GapTimeout + time.Millisecond)
+	_, behind = tracker.Expire(online)
+	assert.Empty(t, behind)
+}
+
+// event of the source node, its deltas and snapshots are replayed by a replica
+type event struct {
+	method string
+	UID    string
+}
+
+// source node publishing deltas of events
+var history = []event{
+	{"onJoin", "a"},
+	{"onJoin", "b"},
+	{"onStream", "a"},
+	{"onLeave", "b"},
+	{"onJoin", "c"},
+	{"onMute", "c"},
+	{"onLeave", "a"},
+	{"onJoin", "b"},
+	{"onMute", "b"},
+	{"onJoin", "d"},
+	{"onLeave", "d"},
+}
+
+// online participants of the source after seq deltas
+func sourceOnline(seq uint64) []string {
+	online := make(map[string]bool)
+	for _, e := range history[:seq] {
+		if e.method == "onLeave" {
+			delete(online, e.UID)
+		} else {
+			online[e.UID] = true
+		}
+	}
+	return keys(online)
+}
+
+func keys(m map[string]bool) []string {
+	UIDs := make([]string, 0, len(m))
+	for UID := range m {
+		UIDs = append(UIDs, UID)
+	}
+	sort.Strings(UIDs)
+	return UIDs
+}
+
+// replica of the participants of the source applying deltas and snapshots
+// the way rooms do
+type replica struct {
+	tracker *Tracker
+	online  map[string]bool
+	resync  bool
+}
+
+func (r *replica) delta(seq uint64) {
+	e := history[seq-1]
+	apply, _, resync := r.tracker.Delta("host", delta(seq, e.method, e.UID))
+	r.resync = r.resync || resync
+	if !apply {
+		return
+	}
+	if e.method == "onLeave" {
+		delete(r.online, e.UID)
+	} else {
+		r.online[e.UID] = true
+	}
+}
+
+func (r *replica) snapshot(seq uint64) {
+	UIDs := sourceOnline(seq)
+	apply, newer, _ := r.tracker.Snapshot("host", Version{Epoch: "epoch", Seq: seq}, UIDs, keys(r.online))
+	if !apply {
+		return
+	}
+	included := make(map[string]bool)
+	for _, UID := range UIDs {
+		included[UID] = true
+		if !newer[UID] {
+			r.online[UID] = true
+		}
+	}
+	for UID := range r.online {
+		if !included[UID] && !newer[UID] {
+			delete(r.online, UID)
+		}
+	}
+}
+
+func TestConverge(t *testing.T) {
+	last := uint64(len(history))
+	expected := sourceOnline(last)
+
+	for seed := int64(0); seed < 500; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		tracker, clock := newTestTracker()
+		r := &replica{tracker: tracker, online: make(map[string]bool)}
+
+		// deltas reordered and duplicated, one of them lost
+		var deliveries []uint64
+		for seq := uint64(1); seq <= last; seq++ {
+			deliveries = append(deliveries, seq)
+			if rng.Intn(3) == 0 {
+				deliveries = append(deliveries, seq)
+			}
+		}
+		rng.Shuffle(len(deliveries), func(i, j int) {
+			deliveries[i], deliveries[j] = deliveries[j], deliveries[i]
+		})
+		lost := uint64(0)
+		if rng.Intn(2) == 0 {
+			lost = uint64(rng.Intn(int(last))) + 1
+		}
+
+		// a snapshot answering another node arrives among them, the
+		// source took it after seq deltas
+		answered, answer := rng.Intn(len(deliveries)+1), uint64(rng.Intn(int(last)))+1
+
+		for i, seq := range deliveries {
+			if i == answered {
+				r.snapshot(answer)
+			}
+			if seq != lost {
+				r.delta(seq)
+			}
+		}
+
+		// the next digest finds the gap and the snapshot of the source fills it
+		clock.advance(GapTimeout + time.Millisecond)
+		_, behind := tracker.Expire(func(UID string) bool { return r.online[UID] })
+		if lost != 0 && lost != last && lost > answer {
+			assert.True(t, len(behind) > 0 || r.resync, "seed %d lost %d is requested", seed, lost)
+		}
+		if len(behind) > 0 || r.resync || tracker.Check("host", Version{Epoch: "epoch", Seq: last}) {
+			r.snapshot(last)
+		}
+
+		assert.Equal(t, expected, keys(r.online), "seed %d lost %d deliveries %v snapshot %d at %d",
+			seed, lost, deliveries, answer, answered)
+	}
+}
@@ -0,0 +1,162 @@
+// Package rpcerror defines the JSON-RPC errors replied to participants
+package rpcerror
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/sourcegraph/jsonrpc2"
+
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/auth"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/sfu"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/ton"
+)
+
+// ErrorCode of a JSON-RPC error reply. Codes and reasons are stable, the
+// message is free text for logs
+type ErrorCode int64
+
+const (
+	// ErrorBadRequest malformed params or a request not valid before join
+	ErrorBadRequest ErrorCode = 400
+	// ErrorTokenInvalid token or signature which can't be verified, request a new token
+	ErrorTokenInvalid ErrorCode = 401
+	// ErrorForbidden by the room, not host, banned or muted by a host
+	ErrorForbidden ErrorCode = 403
+	// ErrorNotFound room, session or participant
+	ErrorNotFound ErrorCode = 404
+	// ErrorConflict with the current state, already joined or recording
+	ErrorConflict ErrorCode = 409
+	// ErrorRoomEnded the room was closed, don't rejoin it
+	ErrorRoomEnded ErrorCode = 410
+	// ErrorTokenExpired outside of its validity window or already used, request a new token
+	ErrorTokenExpired ErrorCode = 440
+	// ErrorInternal unexpected failure of the node
+	ErrorInternal ErrorCode = 500
+	// ErrorMisconfigured node, join another node
+	ErrorMisconfigured ErrorCode = 502
+	// ErrorRetry transient failure, retry the request later
+	ErrorRetry ErrorCode = 503
+)
+
+// ErrorData of a JSON-RPC error reply
+type ErrorData struct {
+	// Reason of the error within its code, e.g. token_used for ErrorTokenExpired
+	Reason string `json:"reason"`
+	// Retry the same request later, possibly on another node
+	Retry bool `json:"retry"`
+}
+
+// RequestError replied with its code and reason
+type RequestError struct {
+	Code   ErrorCode
+	Reason string
+	Err    error
+}
+
+func (e *RequestError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches request errors of the same reason
+func (e *RequestError) Is(target error) bool {
+	t, ok := target.(*RequestError)
+	return ok && t.Reason == e.Reason
+}
+
+// Wrap err with the code and reason of e
+func (e *RequestError) Wrap(err error) *RequestError {
+	return &RequestError{Code: e.Code, Reason: e.Reason, Err: err}
+}
+
+func newRequestError(code ErrorCode, reason string, message string) *RequestError {
+	return &RequestError{Code: code, Reason: reason, Err: errors.New(message)}
+}
+
+var (
+	ErrBadRequest          = newRequestError(ErrorBadRequest, "bad_request", "bad request")
+	ErrNotJoined           = newRequestError(ErrorBadRequest, "not_joined", "not joined")
+	ErrTokenInvalid        = newRequestError(ErrorTokenInvalid, "token_invalid", "not verified signature")
+	ErrTokenMalformed      = newRequestError(ErrorTokenInvalid, "token_malformed", "malformed token")
+	ErrClientNotRegistered = newRequestError(ErrorTokenInvalid, "client_not_registered", "client not registered")
+	ErrNotHost             = newRequestError(ErrorForbidden, "not_host", "not host")
+	ErrMutedByHost         = newRequestError(ErrorForbidden, "muted_by_host", "muted by host")
+	ErrBanned              = newRequestError(ErrorForbidden, "banned", "banned")
+	ErrRoomNotFound        = newRequestError(ErrorNotFound, "room_not_found", "room not found")
+	ErrSessionNotFound     = newRequestError(ErrorNotFound, "session_not_found", "session not found")
+	ErrParticipantNotFound = newRequestError(ErrorNotFound, "participant_not_found", "participant not found")
+	ErrAlreadyJoined       = newRequestError(ErrorConflict, "already_joined", "already joined")
+	ErrAlreadyRecording    = newRequestError(ErrorConflict, "already_recording", "already recording")
+	ErrNotRecording        = newRequestError(ErrorConflict, "not_recording", "not recording")
+	ErrRoomEnded           = newRequestError(ErrorRoomEnded, "room_ended", "room closed")
+	ErrTokenExpired        = newRequestError(ErrorTokenExpired, "token_expired", "token expired")
+	ErrTokenNotYetValid    = newRequestError(ErrorTokenExpired, "token_not_yet_valid", "token not valid yet")
+	ErrTokenUsed           = newRequestError(ErrorTokenExpired, "token_used", "token already used")
+	ErrInternal            = newRequestError(ErrorInternal, "internal", "internal error")
+	ErrMisconfigured       = newRequestError(ErrorMisconfigured, "node_misconfigured", "node misconfigured")
+	ErrDraining            = newRequestError(ErrorRetry, "draining", "node draining")
+	ErrNegotiation         = newRequestError(ErrorRetry, "negotiation", "negotiation in progress")
+	ErrChainUnavailable    = newRequestError(ErrorRetry, "chain_unavailable", "chain unavailable")
+)
+
+// Classify maps the errors of the auth, sfu, TON and json packages onto the
+// request errors, unknown errors are internal
+func Classify(err error) *RequestError {
+	var requestError *RequestError
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &requestError):
+		if error(requestError) == err {
+			return requestError
+		}
+		// keep the context wrapped around the request error in the message
+		return requestError.Wrap(err)
+	case errors.As(err, &syntaxError), errors.As(err, &typeError),
+		errors.Is(err, sfu.ErrSpatialNotSupported):
+		return ErrBadRequest.Wrap(err)
+	case errors.Is(err, auth.ErrMalformed):
+		return ErrTokenMalformed.Wrap(err)
+	case errors.Is(err, auth.ErrLifetime):
+		return ErrTokenInvalid.Wrap(err)
+	case errors.Is(err, auth.ErrNotYetValid):
+		return ErrTokenNotYetValid.Wrap(err)
+	case errors.Is(err, auth.ErrExpired):
+		return ErrTokenExpired.Wrap(err)
+	case errors.Is(err, auth.ErrUsed):
+		return ErrTokenUsed.Wrap(err)
+	case errors.Is(err, sfu.ErrTransportExists):
+		return ErrAlreadyJoined.Wrap(err)
+	case errors.Is(err, sfu.ErrOfferIgnored), errors.Is(err, sfu.ErrNoTransportEstablished),
+		errors.Is(err, sfu.ErrSpatialLayerBusy):
+		return ErrNegotiation.Wrap(err)
+	// a node contract missing is also not registered, check the node first
+	case errors.Is(err, ton.ErrMisconfigured):
+		return ErrMisconfigured.Wrap(err)
+	case errors.Is(err, ton.ErrNotRegistered), errors.Is(err, ton.ErrInvalidAddress):
+		return ErrClientNotRegistered.Wrap(err)
+	case errors.Is(err, ton.ErrUnavailable):
+		return ErrChainUnavailable.Wrap(err)
+	default:
+		return ErrInternal.Wrap(err)
+	}
+}
+
+// Reply of err with its code and data
+func Reply(err error) *jsonrpc2.Error {
+	requestError := Classify(err)
+	rpcErr := &jsonrpc2.Error{
+		Code:    int64(requestError.Code),
+		Message: requestError.Error(),
+	}
+	rpcErr.SetError(&ErrorData{
+		Reason: requestError.Reason,
+		Retry:  requestError.Code == ErrorRetry,
+	})
+	return rpcErr
+}
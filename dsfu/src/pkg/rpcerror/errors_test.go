@@ -0,0 +1,76 @@
+package rpcerror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/auth"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/sfu"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/ton"
+)
+
+func TestClassify(t *testing.T) {
+	var params struct {
+		Name string `json:"name"`
+	}
+	syntaxErr := json.Unmarshal([]byte("{"), &params)
+	typeErr := json.Unmarshal([]byte(`{"name": 1}`), &params)
+	tonErr := func(kind error) error {
+		return fmt.Errorf("call: %w", &ton.Error{Kind: kind, Err: errors.New("lite server")})
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want *RequestError
+	}{
+		{"request error", ErrNotHost, ErrNotHost},
+		{"wrapped request error", fmt.Errorf("kick: %w", ErrBanned), ErrBanned},
+		{"json syntax", syntaxErr, ErrBadRequest},
+		{"json type", typeErr, ErrBadRequest},
+		{"spatial not supported", sfu.ErrSpatialNotSupported, ErrBadRequest},
+		{"token malformed", fmt.Errorf("%w: nonce missing", auth.ErrMalformed), ErrTokenMalformed},
+		{"token lifetime", auth.ErrLifetime, ErrTokenInvalid},
+		{"token not yet valid", auth.ErrNotYetValid, ErrTokenNotYetValid},
+		{"token expired", auth.ErrExpired, ErrTokenExpired},
+		{"token used", auth.ErrUsed, ErrTokenUsed},
+		{"transport exists", sfu.ErrTransportExists, ErrAlreadyJoined},
+		{"offer ignored", sfu.ErrOfferIgnored, ErrNegotiation},
+		{"no transport", sfu.ErrNoTransportEstablished, ErrNegotiation},
+		{"spatial layer busy", sfu.ErrSpatialLayerBusy, ErrNegotiation},
+		{"ton misconfigured", tonErr(ton.ErrMisconfigured), ErrMisconfigured},
+		{"ton not registered", tonErr(ton.ErrNotRegistered), ErrClientNotRegistered},
+		{"ton invalid address", tonErr(ton.ErrInvalidAddress), ErrClientNotRegistered},
+		{"ton unavailable", tonErr(ton.ErrUnavailable), ErrChainUnavailable},
+		{"unknown", errors.New("disk full"), ErrInternal},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Classify(test.err)
+			assert.Equal(t, test.want.Code, got.Code)
+			assert.Equal(t, test.want.Reason, got.Reason)
+			assert.ErrorIs(t, got, test.err, "the cause is kept")
+		})
+	}
+}
+
+func TestReply(t *testing.T) {
+	rpcErr := Reply(fmt.Errorf("join: %w", ErrTokenUsed))
+	assert.Equal(t, int64(ErrorTokenExpired), rpcErr.Code)
+	assert.Equal(t, "join: token already used", rpcErr.Message)
+	require.NotNil(t, rpcErr.Data)
+	var data ErrorData
+	require.NoError(t, json.Unmarshal(*rpcErr.Data, &data))
+	assert.Equal(t, ErrorData{Reason: "token_used"}, data)
+
+	rpcErr = Reply(&ton.Error{Kind: ton.ErrUnavailable, Err: errors.New("timeout")})
+	assert.Equal(t, int64(ErrorRetry), rpcErr.Code)
+	require.NoError(t, json.Unmarshal(*rpcErr.Data, &data))
+	assert.Equal(t, ErrorData{Reason: "chain_unavailable", Retry: true}, data)
+}
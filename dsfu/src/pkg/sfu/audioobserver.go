@@ -74,7 +74,18 @@ func (a *AudioObserver) observe(streamID string, dBov uint8) {
 	}
 }
 
-func (a *AudioObserver) Calc() []string {
+// AudioLevel of a stream over an interval
+type AudioLevel struct {
+	StreamID string
+	// Level average of the packets above the threshold in -dBov, lower is louder
+	Level uint8
+	// Active packets above the threshold
+	Active int
+}
+
+// CalcLevels of the streams active over the interval, the most active and
+// loudest first, and starts a new interval
+func (a *AudioObserver) CalcLevels() []AudioLevel {
 	a.Lock()
 	defer a.Unlock()
 
@@ -88,14 +99,35 @@ func (a *AudioObserver) Calc() []string {
 		}
 	})
 
-	streamIDs := make([]string, 0, len(a.streams))
+	levels := make([]AudioLevel, 0, len(a.streams))
 	for _, s := range a.streams {
 		if s.total >= a.expected {
-			streamIDs = append(streamIDs, s.id)
+			level := AudioLevel{StreamID: s.id, Level: 127, Active: s.total}
+			if s.total > 0 {
+				level.Level = uint8(s.sum / s.total)
+			}
+			levels = append(levels, level)
 		}
 		s.total = 0
 		s.sum = 0
 	}
+	return levels
+}
+
+// Calc the active stream IDs, nil if they did not change since the previous interval
+func (a *AudioObserver) Calc() []string {
+	return a.changed(a.CalcLevels())
+}
+
+// changed stream IDs of levels, nil if they are the previous ones
+func (a *AudioObserver) changed(levels []AudioLevel) []string {
+	a.Lock()
+	defer a.Unlock()
+
+	streamIDs := make([]string, 0, len(levels))
+	for _, level := range levels {
+		streamIDs = append(streamIDs, level.StreamID)
+	}
 
 	if len(a.previous) == len(streamIDs) {
 		for i, s := range a.previous {
@@ -113,6 +113,25 @@ func Test_audioLevel_calc(t *testing.T) {
 	}
 }
 
+func Test_audioLevel_calcLevels(t *testing.T) {
+	a := &AudioObserver{
+		streams: []*audioStream{
+			{id: "a", sum: 20, total: 2},
+			{id: "b", sum: 30, total: 5},
+			{id: "c", sum: 2, total: 1},
+		},
+		expected: 2,
+	}
+
+	want := []AudioLevel{
+		{StreamID: "b", Level: 6, Active: 5},
+		{StreamID: "a", Level: 10, Active: 2},
+	}
+	assert.Equal(t, want, a.CalcLevels())
+	// levels are reported every interval, the interval is reset
+	assert.Equal(t, []AudioLevel{}, a.CalcLevels())
+}
+
 func Test_audioLevel_observe(t *testing.T) {
 	type fields struct {
 		streams   []*audioStream
@@ -7,10 +7,10 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/buffer"
 	"github.com/pion/rtcp"
 	"github.com/pion/transport/packetio"
 	"github.com/pion/webrtc/v3"
-	"main/pkg/buffer"
 )
 
 // DownTrackType determines the type of track
@@ -66,6 +66,11 @@ type DownTrack struct {
 	octetCount  uint32
 	packetCount uint32
 	maxPacketTs uint32
+
+	// Receiver report helpers
+	fractionLost uint32
+	jitter       uint32
+	remb         uint64
 }
 
 // NewDownTrack returns a DownTrack.
@@ -110,6 +115,26 @@ func (d *DownTrack) Bind(t webrtc.TrackLocalContext) (webrtc.RTPCodecParameters,
 	return webrtc.RTPCodecParameters{}, webrtc.ErrUnsupportedCodec
 }
 
+// BindLocal binds the DownTrack to a local writer instead of a PeerConnection,
+// packets keep the payload type of the Receiver codec
+func (d *DownTrack) BindLocal(ssrc uint32, payloadType uint8, writer webrtc.TrackLocalWriter) {
+	d.ssrc = ssrc
+	d.payloadType = payloadType
+	d.writeStream = writer
+	d.mime = strings.ToLower(d.codec.MimeType)
+	d.reSync.set(true)
+	d.enabled.set(true)
+	if strings.HasPrefix(d.codec.MimeType, "video/") {
+		d.sequencer = newSequencer(d.maxTrack)
+	}
+	d.bound.set(true)
+}
+
+// TimestampOffset subtracted from the Receiver RTP timestamps of written packets
+func (d *DownTrack) TimestampOffset() uint32 {
+	return atomic.LoadUint32(&d.tsOffset)
+}
+
 // Unbind implements the teardown logic when the track is no longer needed. This happens
 // because a track has been stopped.
 func (d *DownTrack) Unbind(_ webrtc.TrackLocalContext) error {
@@ -206,6 +231,26 @@ func (d *DownTrack) CurrentSpatialLayer() int {
 	return int(atomic.LoadInt32(&d.currentSpatialLayer))
 }
 
+// TargetSpatialLayer the down track is switching to
+func (d *DownTrack) TargetSpatialLayer() int {
+	return int(atomic.LoadInt32(&d.targetSpatialLayer))
+}
+
+// CurrentTemporalLayer of a simulcast down track
+func (d *DownTrack) CurrentTemporalLayer() int {
+	return int(uint16(atomic.LoadInt32(&d.temporalLayer)))
+}
+
+// Simulcast down track switching between the layers of its receiver
+func (d *DownTrack) Simulcast() bool {
+	return d.trackType == SimulcastDownTrack
+}
+
+// PeerID of the subscriber
+func (d *DownTrack) PeerID() string {
+	return d.peerID
+}
+
 func (d *DownTrack) SwitchSpatialLayer(targetLayer int32, setAsMax bool) error {
 	if d.trackType == SimulcastDownTrack {
 		// Don't switch until previous switch is done or canceled
@@ -356,7 +401,7 @@ func (d *DownTrack) writeSimpleRTP(extPkt *buffer.ExtPacket) error {
 
 		if d.lastSN != 0 {
 			d.snOffset = extPkt.Packet.SequenceNumber - d.lastSN - 1
-			d.tsOffset = extPkt.Packet.Timestamp - d.lastTS - 1
+			atomic.StoreUint32(&d.tsOffset, extPkt.Packet.Timestamp-d.lastTS-1)
 		}
 		atomic.StoreUint32(&d.lastSSRC, extPkt.Packet.SSRC)
 		d.reSync.set(false)
@@ -428,7 +473,7 @@ func (d *DownTrack) writeSimulcastRTP(extPkt *buffer.ExtPacket, layer int) error
 		if td == 0 {
 			td = 1
 		}
-		d.tsOffset = extPkt.Packet.Timestamp - (d.lastTS + td)
+		atomic.StoreUint32(&d.tsOffset, extPkt.Packet.Timestamp-(d.lastTS+td))
 		d.snOffset = extPkt.Packet.SequenceNumber - d.lastSN - 1
 	} else if d.simulcast.lTSCalc == 0 {
 		d.lastTS = extPkt.Packet.Timestamp
@@ -528,11 +573,16 @@ func (d *DownTrack) handleRTCP(bytes []byte) {
 			if expectedMinBitrate == 0 || expectedMinBitrate > uint64(p.Bitrate) {
 				expectedMinBitrate = uint64(p.Bitrate)
 			}
+			atomic.StoreUint64(&d.remb, uint64(p.Bitrate))
 		case *rtcp.ReceiverReport:
 			for _, r := range p.Reports {
 				if maxRatePacketLoss == 0 || maxRatePacketLoss < r.FractionLost {
 					maxRatePacketLoss = r.FractionLost
 				}
+				if r.SSRC == d.ssrc {
+					atomic.StoreUint32(&d.fractionLost, uint32(r.FractionLost))
+					atomic.StoreUint32(&d.jitter, r.Jitter)
+				}
 			}
 		case *rtcp.TransportLayerNack:
 			if d.sequencer != nil {
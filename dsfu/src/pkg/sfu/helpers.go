@@ -6,8 +6,8 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/buffer"
 	"github.com/pion/webrtc/v3"
-	"main/pkg/buffer"
 )
 
 var (
@@ -64,6 +64,7 @@ type PeerLocal struct {
 	closed   atomicBool
 	session  Session
 	provider SessionProvider
+	cfg      WebRTCTransportConfig
 
 	publisher  *Publisher
 	subscriber *Subscriber
@@ -103,6 +104,7 @@ func (p *PeerLocal) Join(sid, uid string, config ...JoinConfig) error {
 
 	s, cfg := p.provider.GetSession(sid)
 	p.session = s
+	p.cfg = cfg
 
 	if !conf.NoSubscribe {
 		p.subscriber, err = NewSubscriber(uid, cfg)
@@ -121,6 +123,17 @@ func (p *PeerLocal) Join(sid, uid string, config ...JoinConfig) error {
 				return
 			}
 
+			// peers without an offer handler only answer remote offers, e.g. WHEP players
+			if p.OnOffer == nil {
+				return
+			}
+
+			// an offer without media or datachannel has no ICE credentials,
+			// e.g. viewers joining before anything is published
+			if p.subscriber.empty() {
+				return
+			}
+
 			Logger.V(1).Info("Negotiation needed", "peer_id", p.id)
 			offer, err := p.subscriber.CreateOffer()
 			if err != nil {
@@ -149,35 +162,9 @@ func (p *PeerLocal) Join(sid, uid string, config ...JoinConfig) error {
 	}
 
 	if !conf.NoPublish {
-		p.publisher, err = NewPublisher(uid, p.session, &cfg)
-		if err != nil {
-			return fmt.Errorf("error creating transport: %v", err)
-		}
-		if !conf.NoSubscribe {
-			for _, dc := range p.session.GetDCMiddlewares() {
-				if err := p.subscriber.AddDatachannel(p, dc); err != nil {
-					return fmt.Errorf("setting subscriber default dc datachannel: %w", err)
-				}
-			}
+		if err := p.newPublisher(); err != nil {
+			return err
 		}
-
-		p.publisher.OnICECandidate(func(c *webrtc.ICECandidate) {
-			Logger.V(1).Info("on publisher ice candidate called for peer", "peer_id", p.id)
-			if c == nil {
-				return
-			}
-
-			if p.OnIceCandidate != nil && !p.closed.get() {
-				json := c.ToJSON()
-				p.OnIceCandidate(&json, publisher)
-			}
-		})
-
-		p.publisher.OnICEConnectionStateChange(func(s webrtc.ICEConnectionState) {
-			if p.OnICEConnectionStateChange != nil && !p.closed.get() {
-				p.OnICEConnectionStateChange(s)
-			}
-		})
 	}
 
 	p.session.AddPeer(p)
@@ -190,19 +177,84 @@ func (p *PeerLocal) Join(sid, uid string, config ...JoinConfig) error {
 	return nil
 }
 
-// Answer an offer from remote
+// AddPublisher creates the publisher transport for a peer joined with
+// NoPublish, the remote starts publishing by sending an offer
+func (p *PeerLocal) AddPublisher() error {
+	if p.session == nil || p.closed.get() {
+		return ErrNoTransportEstablished
+	}
+	if p.Publisher() != nil {
+		return ErrTransportExists
+	}
+	if err := p.newPublisher(); err != nil {
+		return err
+	}
+	if p.subscriber != nil {
+		p.subscriber.negotiate()
+	}
+	return nil
+}
+
+func (p *PeerLocal) newPublisher() error {
+	pub, err := NewPublisher(p.id, p.session, &p.cfg)
+	if err != nil {
+		return fmt.Errorf("error creating transport: %v", err)
+	}
+	if p.subscriber != nil {
+		for _, dc := range p.session.GetDCMiddlewares() {
+			if err := p.subscriber.AddDatachannel(p, dc); err != nil {
+				return fmt.Errorf("setting subscriber default dc datachannel: %w", err)
+			}
+		}
+	}
+
+	pub.OnICECandidate(func(c *webrtc.ICECandidate) {
+		Logger.V(1).Info("on publisher ice candidate called for peer", "peer_id", p.id)
+		if c == nil {
+			return
+		}
+
+		if p.OnIceCandidate != nil && !p.closed.get() {
+			json := c.ToJSON()
+			p.OnIceCandidate(&json, publisher)
+		}
+	})
+
+	pub.OnICEConnectionStateChange(func(s webrtc.ICEConnectionState) {
+		if p.OnICEConnectionStateChange != nil && !p.closed.get() {
+			p.OnICEConnectionStateChange(s)
+		}
+	})
+
+	// signaling and stats goroutines read the publisher of a joined peer
+	p.Lock()
+	defer p.Unlock()
+	if p.publisher != nil {
+		pub.Close()
+		return ErrTransportExists
+	}
+	p.publisher = pub
+	return nil
+}
+
+// Answer an offer from remote, peers without a publisher transport
+// get the offer answered on their subscriber transport
 func (p *PeerLocal) Answer(sdp webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
-	if p.publisher == nil {
-		return nil, ErrNoTransportEstablished
+	pub := p.Publisher()
+	if pub == nil {
+		if p.subscriber == nil {
+			return nil, ErrNoTransportEstablished
+		}
+		return p.answerSubscriber(sdp)
 	}
 
 	Logger.V(0).Info("PeerLocal got offer", "peer_id", p.id)
 
-	if p.publisher.SignalingState() != webrtc.SignalingStateStable {
+	if pub.SignalingState() != webrtc.SignalingStateStable {
 		return nil, ErrOfferIgnored
 	}
 
-	answer, err := p.publisher.Answer(sdp)
+	answer, err := pub.Answer(sdp)
 	if err != nil {
 		return nil, fmt.Errorf("error creating answer: %v", err)
 	}
@@ -212,6 +264,76 @@ func (p *PeerLocal) Answer(sdp webrtc.SessionDescription) (*webrtc.SessionDescri
 	return &answer, nil
 }
 
+func (p *PeerLocal) answerSubscriber(sdp webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	Logger.V(0).Info("PeerLocal got subscriber offer", "peer_id", p.id)
+
+	if p.remoteAnswerPending || p.subscriber.SignalingState() != webrtc.SignalingStateStable {
+		return nil, ErrOfferIgnored
+	}
+
+	answer, err := p.subscriber.Answer(sdp)
+	if err != nil {
+		return nil, fmt.Errorf("error creating answer: %v", err)
+	}
+
+	if p.negotiationPending {
+		p.negotiationPending = false
+		p.subscriber.negotiate()
+	}
+
+	Logger.V(0).Info("PeerLocal send subscriber answer", "peer_id", p.id)
+
+	return &answer, nil
+}
+
+// Subscribe to streams of local publishers and relay peers, tracks arriving
+// later on these streams are forwarded as well
+func (p *PeerLocal) Subscribe(streamIDs []string) error {
+	if p.subscriber == nil || p.session == nil {
+		return ErrNoTransportEstablished
+	}
+
+	for _, streamID := range streamIDs {
+		p.subscriber.Subscribe(streamID)
+	}
+
+	routers := make([]Router, 0)
+	for _, peer := range p.session.Peers() {
+		if peer == p || peer.Publisher() == nil {
+			continue
+		}
+		routers = append(routers, peer.Publisher().GetRouter())
+	}
+	for _, rp := range p.session.RelayPeers() {
+		routers = append(routers, rp.GetRouter())
+	}
+
+	for _, router := range routers {
+		if err := router.AddDownTracks(p.subscriber, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unsubscribe from streams, closing their down tracks renegotiates the subscriber
+func (p *PeerLocal) Unsubscribe(streamIDs []string) error {
+	if p.subscriber == nil {
+		return ErrNoTransportEstablished
+	}
+
+	for _, streamID := range streamIDs {
+		p.subscriber.Unsubscribe(streamID)
+		for _, dt := range p.subscriber.GetDownTracks(streamID) {
+			dt.receiver.RemoveDownTrack(dt)
+		}
+	}
+	return nil
+}
+
 // SetRemoteDescription when receiving an answer from remote
 func (p *PeerLocal) SetRemoteDescription(sdp webrtc.SessionDescription) error {
 	if p.subscriber == nil {
@@ -235,18 +357,47 @@ func (p *PeerLocal) SetRemoteDescription(sdp webrtc.SessionDescription) error {
 	return nil
 }
 
-// Trickle candidates available for this peer
-func (p *PeerLocal) Trickle(candidate webrtc.ICECandidateInit, target int) error {
-	if p.subscriber == nil || p.publisher == nil {
+// RestartICE sends a new subscriber offer with fresh ICE credentials. An offer
+// still waiting for an answer is replaced, as it was likely lost with the old
+// signaling connection. The publisher transport is restarted by the remote
+// sending an offer of its own.
+func (p *PeerLocal) RestartICE() error {
+	if p.subscriber == nil {
 		return ErrNoTransportEstablished
 	}
+	p.Lock()
+	defer p.Unlock()
+
+	Logger.V(0).Info("PeerLocal restart ice", "peer_id", p.id)
+	offer, err := p.subscriber.CreateICERestartOffer()
+	if err != nil {
+		return fmt.Errorf("creating ice restart offer: %w", err)
+	}
+
+	p.remoteAnswerPending = true
+	p.negotiationPending = false
+	if p.OnOffer != nil && !p.closed.get() {
+		p.OnOffer(&offer)
+	}
+	return nil
+}
+
+// Trickle candidates available for this peer
+func (p *PeerLocal) Trickle(candidate webrtc.ICECandidateInit, target int) error {
 	Logger.V(0).Info("PeerLocal trickle", "peer_id", p.id)
 	switch target {
 	case publisher:
-		if err := p.publisher.AddICECandidate(candidate); err != nil {
+		pub := p.Publisher()
+		if pub == nil {
+			return ErrNoTransportEstablished
+		}
+		if err := pub.AddICECandidate(candidate); err != nil {
 			return fmt.Errorf("setting ice candidate: %w", err)
 		}
 	case subscriber:
+		if p.subscriber == nil {
+			return ErrNoTransportEstablished
+		}
 		if err := p.subscriber.AddICECandidate(candidate); err != nil {
 			return fmt.Errorf("setting ice candidate: %w", err)
 		}
@@ -298,6 +449,8 @@ func (p *PeerLocal) Subscriber() *Subscriber {
 }
 
 func (p *PeerLocal) Publisher() *Publisher {
+	p.Lock()
+	defer p.Unlock()
 	return p.publisher
 }
 
@@ -305,6 +458,11 @@ func (p *PeerLocal) Session() Session {
 	return p.session
 }
 
+// Provider of the sessions of the peer
+func (p *PeerLocal) Provider() SessionProvider {
+	return p.provider
+}
+
 // ID return the peer id
 func (p *PeerLocal) ID() string {
 	return p.id
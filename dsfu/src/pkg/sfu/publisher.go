@@ -7,12 +7,12 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/buffer"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/relay"
 	"github.com/pion/rtcp"
 	"github.com/pion/transport/packetio"
 	"github.com/pion/webrtc/v3"
 	"log"
-	"main/pkg/buffer"
-	"main/pkg/relay"
 )
 
 type Publisher struct {
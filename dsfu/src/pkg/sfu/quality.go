@@ -0,0 +1,138 @@
+package sfu
+
+import (
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ConnectionQuality of a peer
+type ConnectionQuality string
+
+const (
+	ConnectionQualityExcellent ConnectionQuality = "excellent"
+	ConnectionQualityGood      ConnectionQuality = "good"
+	ConnectionQualityPoor      ConnectionQuality = "poor"
+)
+
+const (
+	// Fraction of lost packets up to which a stream is excellent or good
+	excellentLoss = 0.02
+	goodLoss      = 0.1
+
+	// Interarrival jitter up to which a stream is excellent or good
+	excellentJitter = 30 * time.Millisecond
+	goodJitter      = 100 * time.Millisecond
+
+	// Estimated bitrate of a subscriber from which video is excellent or good,
+	// the lowest simulcast layers are about 150kbps
+	excellentBitrate = 500_000
+	goodBitrate      = 150_000
+)
+
+// StreamQuality measures of a stream, zero values are unknown
+type StreamQuality struct {
+	// Loss fraction of the packets
+	Loss float64
+	// Jitter of the packet interarrival
+	Jitter time.Duration
+	// Bitrate estimated by the receiver in bps
+	Bitrate uint64
+}
+
+// Quality of the stream
+func (s StreamQuality) Quality() ConnectionQuality {
+	switch {
+	case s.Loss > goodLoss || s.Jitter > goodJitter || (s.Bitrate > 0 && s.Bitrate < goodBitrate):
+		return ConnectionQualityPoor
+	case s.Loss > excellentLoss || s.Jitter > excellentJitter || (s.Bitrate > 0 && s.Bitrate < excellentBitrate):
+		return ConnectionQualityGood
+	default:
+		return ConnectionQualityExcellent
+	}
+}
+
+// rank orders qualities from the worst
+func (q ConnectionQuality) rank() int {
+	switch q {
+	case ConnectionQualityPoor:
+		return 0
+	case ConnectionQualityGood:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Worse of the qualities
+func (q ConnectionQuality) Worse(o ConnectionQuality) ConnectionQuality {
+	if o.rank() < q.rank() {
+		return o
+	}
+	return q
+}
+
+// jitterDuration of a jitter in units of clockRate
+func jitterDuration(jitter float64, clockRate uint32) time.Duration {
+	if clockRate == 0 {
+		return 0
+	}
+	return time.Duration(jitter / float64(clockRate) * float64(time.Second))
+}
+
+// StreamQuality of the upstream measured by the buffers of the receiver,
+// the worst layer counts
+func (w *WebRTCReceiver) StreamQuality() StreamQuality {
+	var quality StreamQuality
+	for i, buff := range w.buffers {
+		if buff == nil || !w.available[i].get() {
+			continue
+		}
+		stats := buff.GetStats()
+		// LostRate is undefined until the buffer reported an interval
+		loss := float64(stats.LostRate)
+		if math.IsNaN(loss) || loss < 0 || loss > 1 {
+			loss = 0
+		}
+		if loss > quality.Loss {
+			quality.Loss = loss
+		}
+		if jitter := jitterDuration(stats.Jitter, w.codec.ClockRate); jitter > quality.Jitter {
+			quality.Jitter = jitter
+		}
+	}
+	return quality
+}
+
+// StreamQuality of the downstream from the last receiver report and REMB of
+// the subscriber, the bitrate only counts for video
+func (d *DownTrack) StreamQuality() StreamQuality {
+	quality := StreamQuality{
+		Loss:   float64(atomic.LoadUint32(&d.fractionLost)) / 256,
+		Jitter: jitterDuration(float64(atomic.LoadUint32(&d.jitter)), d.codec.ClockRate),
+	}
+	if strings.HasPrefix(d.codec.MimeType, "video/") {
+		quality.Bitrate = atomic.LoadUint64(&d.remb)
+	}
+	return quality
+}
+
+// ConnectionQuality of a peer, the worst of the streams it publishes and
+// the streams it is forwarded. Peers without measures are excellent
+func (p *PeerLocal) ConnectionQuality() ConnectionQuality {
+	quality := ConnectionQualityExcellent
+	if publisher := p.Publisher(); publisher != nil {
+		for _, receiver := range publisher.GetRouter().GetReceiver() {
+			quality = quality.Worse(receiver.StreamQuality().Quality())
+		}
+	}
+	if subscriber := p.Subscriber(); subscriber != nil {
+		for _, dt := range subscriber.DownTracks() {
+			if dt.Enabled() {
+				quality = quality.Worse(dt.StreamQuality().Quality())
+			}
+		}
+	}
+	return quality
+}
@@ -0,0 +1,76 @@
+package sfu
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestStreamQuality_Quality(t *testing.T) {
+	tests := []struct {
+		name    string
+		quality StreamQuality
+		want    ConnectionQuality
+	}{
+		{
+			name: "Must be excellent without measures",
+			want: ConnectionQualityExcellent,
+		},
+		{
+			name:    "Must be good with some loss",
+			quality: StreamQuality{Loss: 0.05},
+			want:    ConnectionQualityGood,
+		},
+		{
+			name:    "Must be poor with high jitter",
+			quality: StreamQuality{Loss: 0.01, Jitter: 150 * time.Millisecond},
+			want:    ConnectionQualityPoor,
+		},
+		{
+			name:    "Must be poor below the lowest layer bitrate",
+			quality: StreamQuality{Bitrate: 100_000},
+			want:    ConnectionQualityPoor,
+		},
+		{
+			name:    "Must be excellent with enough bitrate",
+			quality: StreamQuality{Loss: 0.01, Jitter: 10 * time.Millisecond, Bitrate: 2_000_000},
+			want:    ConnectionQualityExcellent,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.quality.Quality(); got != tt.want {
+				t.Errorf("Quality() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnectionQuality_Worse(t *testing.T) {
+	if got := ConnectionQualityExcellent.Worse(ConnectionQualityGood); got != ConnectionQualityGood {
+		t.Errorf("Worse() = %v, want %v", got, ConnectionQualityGood)
+	}
+	if got := ConnectionQualityPoor.Worse(ConnectionQualityGood); got != ConnectionQualityPoor {
+		t.Errorf("Worse() = %v, want %v", got, ConnectionQualityPoor)
+	}
+}
+
+func TestDownTrack_StreamQuality(t *testing.T) {
+	d := &DownTrack{
+		codec:        webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+		fractionLost: 64,
+		jitter:       4500,
+		remb:         300_000,
+	}
+	got := d.StreamQuality()
+	if got.Loss != 0.25 || got.Jitter != 50*time.Millisecond || got.Bitrate != 300_000 {
+		t.Errorf("StreamQuality() = %+v", got)
+	}
+
+	d.codec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000}
+	if got := d.StreamQuality(); got.Bitrate != 0 {
+		t.Errorf("StreamQuality() audio bitrate = %v, want 0", got.Bitrate)
+	}
+}
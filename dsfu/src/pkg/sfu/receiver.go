@@ -7,12 +7,12 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/buffer"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/stats"
 	"github.com/gammazero/workerpool"
 	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
-	"main/pkg/buffer"
-	"main/pkg/stats"
 )
 
 // Receiver defines a interface for a track receivers
@@ -28,8 +28,11 @@ type Receiver interface {
 	SwitchDownTrack(track *DownTrack, layer int) error
 	GetBitrate() [3]uint64
 	GetMaxTemporalLayer() [3]int32
+	AvailableLayers() []uint16
+	StreamQuality() StreamQuality
 	RetransmitPackets(track *DownTrack, packets []packetMeta) error
 	DeleteDownTrack(layer int, id string)
+	RemoveDownTrack(track *DownTrack)
 	OnCloseHandler(fn func())
 	SendRTCP(p []rtcp.Packet)
 	SetRTCPCh(ch chan []rtcp.Packet)
@@ -233,6 +236,17 @@ func (w *WebRTCReceiver) GetMaxTemporalLayer() [3]int32 {
 	return tls
 }
 
+// AvailableLayers of the up tracks, only layer 0 if not simulcast
+func (w *WebRTCReceiver) AvailableLayers() []uint16 {
+	layers := make([]uint16, 0, 3)
+	for i, a := range w.available {
+		if a.get() {
+			layers = append(layers, uint16(i))
+		}
+	}
+	return layers
+}
+
 // OnCloseHandler method to be called on remote tracked removed
 func (w *WebRTCReceiver) OnCloseHandler(fn func()) {
 	w.onCloseHandler = fn
@@ -248,6 +262,31 @@ func (w *WebRTCReceiver) DeleteDownTrack(layer int, id string) {
 	w.Unlock()
 }
 
+// RemoveDownTrack removes a single DownTrack from every layer of a Receiver and closes it
+func (w *WebRTCReceiver) RemoveDownTrack(track *DownTrack) {
+	w.Lock()
+	for layer := range w.downTracks {
+		dts, _ := w.downTracks[layer].Load().([]*DownTrack)
+		ndts := make([]*DownTrack, 0, len(dts))
+		for _, dt := range dts {
+			if dt != track {
+				ndts = append(ndts, dt)
+			}
+		}
+		w.downTracks[layer].Store(ndts)
+
+		pts := w.pendingTracks[layer][:0]
+		for _, dt := range w.pendingTracks[layer] {
+			if dt != track {
+				pts = append(pts, dt)
+			}
+		}
+		w.pendingTracks[layer] = pts
+	}
+	w.Unlock()
+	track.Close()
+}
+
 func (w *WebRTCReceiver) deleteDownTrack(layer int, id string) {
 	dts := w.downTracks[layer].Load().([]*DownTrack)
 	ndts := make([]*DownTrack, 0, len(dts))
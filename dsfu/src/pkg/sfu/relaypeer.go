@@ -6,12 +6,12 @@ import (
 	"sync"
 	"time"
 
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/buffer"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/relay"
 	"github.com/pion/rtcp"
 	"github.com/pion/transport/packetio"
 	"github.com/pion/webrtc/v3"
 	"log"
-	"main/pkg/buffer"
-	"main/pkg/relay"
 )
 
 type RelayPeer struct {
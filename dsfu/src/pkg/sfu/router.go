@@ -4,11 +4,11 @@ import (
 	"sync"
 	"sync/atomic"
 
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/buffer"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/stats"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/twcc"
 	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
-	"main/pkg/buffer"
-	"main/pkg/stats"
-	"main/pkg/twcc"
 )
 
 // Router defines a track rtp/rtcp Router
@@ -18,6 +18,7 @@ type Router interface {
 	AddDownTracks(s *Subscriber, r Receiver) error
 	SetRTCPWriter(func([]rtcp.Packet) error)
 	AddDownTrack(s *Subscriber, r Receiver) (*DownTrack, error)
+	AddLocalDownTrack(r Receiver, peerID string, writer webrtc.TrackLocalWriter) (*DownTrack, error)
 	Stop()
 	GetReceiver() map[string]Receiver
 	OnAddReceiverTrack(f func(receiver Receiver))
@@ -207,12 +208,11 @@ func (r *router) AddDownTracks(s *Subscriber, recv Receiver) error {
 	r.Lock()
 	defer r.Unlock()
 
-	if s.noAutoSubscribe {
-		Logger.Info("peer turns off automatic subscription, skip tracks add")
-		return nil
-	}
-
 	if recv != nil {
+		if !s.wants(recv.StreamID()) {
+			Logger.Info("peer is not subscribed to stream, skip track add", "stream_id", recv.StreamID())
+			return nil
+		}
 		if _, err := r.AddDownTrack(s, recv); err != nil {
 			return err
 		}
@@ -220,12 +220,17 @@ func (r *router) AddDownTracks(s *Subscriber, recv Receiver) error {
 		return nil
 	}
 
-	if len(r.receivers) > 0 {
-		for _, rcv := range r.receivers {
-			if _, err := r.AddDownTrack(s, rcv); err != nil {
-				return err
-			}
+	added := false
+	for _, rcv := range r.receivers {
+		if !s.wants(rcv.StreamID()) || s.hasDownTrack(rcv) {
+			continue
+		}
+		if _, err := r.AddDownTrack(s, rcv); err != nil {
+			return err
 		}
+		added = true
+	}
+	if added {
 		s.negotiate()
 	}
 	return nil
@@ -289,6 +294,19 @@ func (r *router) AddDownTrack(sub *Subscriber, recv Receiver) (*DownTrack, error
 	return downTrack, nil
 }
 
+// AddLocalDownTrack forwards the packets of a Receiver to a local writer
+// rather than a Subscriber, e.g. a recorder. Remove it with Receiver.RemoveDownTrack
+func (r *router) AddLocalDownTrack(recv Receiver, peerID string, writer webrtc.TrackLocalWriter) (*DownTrack, error) {
+	codec := recv.Codec()
+	downTrack, err := NewDownTrack(codec.RTPCodecCapability, recv, r.bufferFactory, peerID, r.config.MaxPacketTrack)
+	if err != nil {
+		return nil, err
+	}
+	downTrack.BindLocal(recv.SSRC(0), uint8(codec.PayloadType), writer)
+	recv.AddDownTrack(downTrack, r.config.Simulcast.BestQualityFirst)
+	return downTrack, nil
+}
+
 func (r *router) deleteReceiver(track string, ssrc uint32) {
 	r.Lock()
 	if handler, ok := r.onDelTrack.Load().(func(Receiver)); ok && handler != nil {
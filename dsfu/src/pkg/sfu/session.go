@@ -5,11 +5,11 @@ import (
 	"sync"
 	"time"
 
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/relay"
 	"github.com/pion/webrtc/v3"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"log"
-	"main/pkg/relay"
 )
 
 // Session represents a set of peers. Transports inside a SessionLocal
@@ -23,6 +23,7 @@ type Session interface {
 	RemovePeer(peer Peer)
 	AddRelayPeer(peerID string, signalData []byte) ([]byte, error)
 	AudioObserver() *AudioObserver
+	OnAudioLevels(f func(levels []AudioLevel))
 	AddDatachannel(owner string, dc *webrtc.DataChannel)
 	GetDCMiddlewares() []*Datachannel
 	GetFanOutDataChannelLabels() []string
@@ -40,6 +41,7 @@ type SessionLocal struct {
 	relayPeers     map[string]*RelayPeer
 	closed         atomicBool
 	audioObs       *AudioObserver
+	onAudioLevels  func(levels []AudioLevel)
 	fanOutDCs      []string
 	datachannels   []*Datachannel
 	onCloseHandler func()
@@ -72,6 +74,14 @@ func (s *SessionLocal) AudioObserver() *AudioObserver {
 	return s.audioObs
 }
 
+// OnAudioLevels sets the handler of the audio levels of every interval,
+// unlike the audioLevels API message it is called when nothing changed
+func (s *SessionLocal) OnAudioLevels(f func(levels []AudioLevel)) {
+	s.mu.Lock()
+	s.onAudioLevels = f
+	s.mu.Unlock()
+}
+
 func (s *SessionLocal) GetDCMiddlewares() []*Datachannel {
 	return s.datachannels
 }
@@ -199,7 +209,7 @@ func (s *SessionLocal) AddDatachannel(owner string, dc *webrtc.DataChannel) {
 		ndc.OnMessage(func(msg webrtc.DataChannelMessage) {
 			s.FanOutMessage(pid, label, msg)
 
-			if peer.Publisher().Relayed() {
+			if peer.Publisher() != nil && peer.Publisher().Relayed() {
 				for _, rdc := range peer.Publisher().GetRelayedDataChannels(label) {
 					if msg.IsString {
 						if err = rdc.SendText(string(msg.Data)); err != nil {
@@ -261,7 +271,7 @@ func (s *SessionLocal) Subscribe(peer Peer) {
 		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
 			s.FanOutMessage(peer.ID(), l, msg)
 
-			if peer.Publisher().Relayed() {
+			if peer.Publisher() != nil && peer.Publisher().Relayed() {
 				for _, rdc := range peer.Publisher().GetRelayedDataChannels(l) {
 					if msg.IsString {
 						if err = rdc.SendText(string(msg.Data)); err != nil {
@@ -387,15 +397,23 @@ func (s *SessionLocal) audioLevelObserver(audioLevelInterval int) {
 		if s.closed.get() {
 			return
 		}
-		levels := s.audioObs.Calc()
+		levels := s.audioObs.CalcLevels()
+
+		s.mu.RLock()
+		onAudioLevels := s.onAudioLevels
+		s.mu.RUnlock()
+		if onAudioLevels != nil {
+			onAudioLevels(levels)
+		}
 
-		if levels == nil {
+		streamIDs := s.audioObs.changed(levels)
+		if streamIDs == nil {
 			continue
 		}
 
 		msg := ChannelAPIMessage{
 			Method: AudioLevelsMethod,
-			Params: levels,
+			Params: streamIDs,
 		}
 
 		l, err := json.Marshal(&msg)
@@ -8,12 +8,12 @@ import (
 	"sync"
 	"time"
 
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/buffer"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/stats"
 	"github.com/go-logr/logr"
 	"github.com/pion/ice/v2"
 	"github.com/pion/turn/v2"
 	"github.com/pion/webrtc/v3"
-	"main/pkg/buffer"
-	"main/pkg/stats"
 )
 
 // Logger is an implementation of logr.Logger. If is not provided - will be turned off.
@@ -7,10 +7,10 @@ import (
 
 	"github.com/lucsky/cuid"
 
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/logger"
 	"github.com/pion/webrtc/v3"
 	med "github.com/pion/webrtc/v3/pkg/media"
 	"github.com/stretchr/testify/assert"
-	"main/pkg/logger"
 )
 
 // Init test helpers
@@ -28,6 +28,9 @@ type Subscriber struct {
 	closeOnce sync.Once
 
 	noAutoSubscribe bool
+	subscriptions   map[string]struct{}
+	// unsubscribed streams are not forwarded by automatic subscription
+	unsubscribed map[string]struct{}
 }
 
 // NewSubscriber creates a new Subscriber
@@ -113,8 +116,24 @@ func (s *Subscriber) OnNegotiationNeeded(f func()) {
 	}
 }
 
+// empty subscriber without transceivers and datachannels
+func (s *Subscriber) empty() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.channels) == 0 && len(s.pc.GetTransceivers()) == 0
+}
+
 func (s *Subscriber) CreateOffer() (webrtc.SessionDescription, error) {
-	offer, err := s.pc.CreateOffer(nil)
+	return s.createOffer(nil)
+}
+
+// CreateICERestartOffer creates an offer with new ICE credentials
+func (s *Subscriber) CreateICERestartOffer() (webrtc.SessionDescription, error) {
+	return s.createOffer(&webrtc.OfferOptions{ICERestart: true})
+}
+
+func (s *Subscriber) createOffer(options *webrtc.OfferOptions) (webrtc.SessionDescription, error) {
+	offer, err := s.pc.CreateOffer(options)
 	if err != nil {
 		return webrtc.SessionDescription{}, err
 	}
@@ -127,6 +146,32 @@ func (s *Subscriber) CreateOffer() (webrtc.SessionDescription, error) {
 	return offer, nil
 }
 
+// Answer an offer from remote on the subscriber transport
+func (s *Subscriber) Answer(offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	if err := s.SetRemoteDescription(offer); err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+
+	answer, err := s.pc.CreateAnswer(nil)
+	if err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+	if err := s.pc.SetLocalDescription(answer); err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+	return answer, nil
+}
+
+// PeerConnection of the subscriber
+func (s *Subscriber) PeerConnection() *webrtc.PeerConnection {
+	return s.pc
+}
+
+// SignalingState of the subscriber peer connection
+func (s *Subscriber) SignalingState() webrtc.SignalingState {
+	return s.pc.SignalingState()
+}
+
 // OnICECandidate handler
 func (s *Subscriber) OnICECandidate(f func(c *webrtc.ICECandidate)) {
 	s.pc.OnICECandidate(f)
@@ -152,6 +197,67 @@ func (s *Subscriber) AddDownTrack(streamID string, downTrack *DownTrack) {
 	}
 }
 
+// Subscribe to a stream when automatic subscription is turned off, or again
+// after an Unsubscribe
+func (s *Subscriber) Subscribe(streamID string) {
+	s.Lock()
+	defer s.Unlock()
+	if s.subscriptions == nil {
+		s.subscriptions = make(map[string]struct{})
+	}
+	s.subscriptions[streamID] = struct{}{}
+	delete(s.unsubscribed, streamID)
+}
+
+// Unsubscribe from a stream until it is subscribed again, also under
+// automatic subscription. Its down tracks have to be removed by the caller
+func (s *Subscriber) Unsubscribe(streamID string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.subscriptions, streamID)
+	if s.unsubscribed == nil {
+		s.unsubscribed = make(map[string]struct{})
+	}
+	s.unsubscribed[streamID] = struct{}{}
+}
+
+// Freeze the subscriptions to the streams forwarded so far, returns their
+// count. Subscribers which can't renegotiate only get the tracks of their
+// first answer
+func (s *Subscriber) Freeze() int {
+	s.Lock()
+	defer s.Unlock()
+	s.subscriptions = make(map[string]struct{})
+	for streamID := range s.tracks {
+		s.subscriptions[streamID] = struct{}{}
+	}
+	s.noAutoSubscribe = true
+	return len(s.subscriptions)
+}
+
+// wants tracks of the stream forwarded
+func (s *Subscriber) wants(streamID string) bool {
+	s.RLock()
+	defer s.RUnlock()
+	if _, ok := s.unsubscribed[streamID]; ok {
+		return false
+	}
+	if !s.noAutoSubscribe {
+		return true
+	}
+	_, ok := s.subscriptions[streamID]
+	return ok
+}
+
+func (s *Subscriber) hasDownTrack(recv Receiver) bool {
+	for _, dt := range s.GetDownTracks(recv.StreamID()) {
+		if dt.ID() == recv.TrackID() {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Subscriber) RemoveDownTrack(streamID string, downTrack *DownTrack) {
 	s.Lock()
 	defer s.Unlock()
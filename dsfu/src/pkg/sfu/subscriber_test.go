@@ -0,0 +1,43 @@
+package sfu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriberWants(t *testing.T) {
+	tests := []struct {
+		name            string
+		noAutoSubscribe bool
+		subscribe       bool
+		unsubscribe     bool
+		resubscribe     bool
+		want            bool
+	}{
+		{"auto subscribed", false, false, false, false, true},
+		{"unsubscribed under auto subscription", false, false, true, false, false},
+		{"subscribed again under auto subscription", false, false, true, true, true},
+		{"not subscribed", true, false, false, false, false},
+		{"subscribed", true, true, false, false, true},
+		{"unsubscribed", true, true, true, false, false},
+		{"subscribed again", true, true, true, true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &Subscriber{tracks: make(map[string][]*DownTrack), noAutoSubscribe: test.noAutoSubscribe}
+			if test.subscribe {
+				s.Subscribe("stream")
+			}
+			if test.unsubscribe {
+				s.Unsubscribe("stream")
+			}
+			if test.resubscribe {
+				s.Subscribe("stream")
+			}
+			assert.Equal(t, test.want, s.wants("stream"))
+			assert.True(t, s.wants("other") != test.noAutoSubscribe, "other streams are not affected")
+		})
+	}
+}
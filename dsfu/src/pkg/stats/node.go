@@ -0,0 +1,70 @@
+package stats
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/ton"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	notifyBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10}
+
+	PubSubMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "dsfu",
+		Name:      "pubsub_messages_total",
+		Help:      "Room pub sub messages by method and direction",
+	}, []string{"method", "direction"})
+
+	JoinFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "dsfu",
+		Name:      "join_failures_total",
+		Help:      "Failed joins by reason",
+	}, []string{"reason"})
+
+	TonTransactions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "dsfu",
+		Name:      "ton_transactions_total",
+		Help:      "TON transactions by call and result, processed, rejected, expired or failed",
+	}, []string{"call", "result"})
+
+	NotifyLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Subsystem: "dsfu",
+		Name:      "notify_latency_seconds",
+		Help:      "Latency of the billing callback of NotifyAndTx by action",
+		Buckets:   notifyBuckets,
+	}, []string{"action"})
+)
+
+// InitNodeStats registers the dsfu node series, the room gauges are
+// collected by the node itself
+func InitNodeStats(collectors ...prometheus.Collector) {
+	prometheus.MustRegister(PubSubMessages)
+	prometheus.MustRegister(JoinFailures)
+	prometheus.MustRegister(TonTransactions)
+	prometheus.MustRegister(NotifyLatency)
+	for _, collector := range collectors {
+		prometheus.MustRegister(collector)
+	}
+}
+
+// TonTransaction counts a processed or failed transaction of call
+func TonTransaction(call string, err error) {
+	result := "processed"
+	switch {
+	case err == nil:
+	case errors.Is(err, ton.ErrRejected):
+		result = "rejected"
+	case errors.Is(err, ton.ErrExpired):
+		result = "expired"
+	default:
+		result = "failed"
+	}
+	TonTransactions.WithLabelValues(call, result).Inc()
+}
+
+// ObserveNotify latency of a billing callback started at start
+func ObserveNotify(action string, start time.Time) {
+	NotifyLatency.WithLabelValues(action).Observe(time.Since(start).Seconds())
+}
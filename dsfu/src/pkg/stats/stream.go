@@ -5,8 +5,8 @@ import (
 	"sync"
 	"sync/atomic"
 
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/buffer"
 	"github.com/prometheus/client_golang/prometheus"
-	"main/pkg/buffer"
 )
 
 var (
@@ -19,3 +19,24 @@ func extractEndCallMessage(msg []byte) (callId uint64, validUntil time.Time, spe
 	spentMinutes = uint32(slice.MustLoadUInt(32))
 	return
 }
+
+// EndCallValidUntil of a signed end call message, the contract rejects the
+// message after it. ok is false for messages too short to be end call messages
+func EndCallValidUntil(msg []byte) (validUntil time.Time, ok bool) {
+	// call id, valid until and spent minutes
+	if len(msg) < 16 {
+		return time.Time{}, false
+	}
+	_, validUntil, _ = extractEndCallMessage(msg)
+	return validUntil, true
+}
+
+// messageValidUntil of a signed create or end call message
+func messageValidUntil(msg []byte) (validUntil time.Time, ok bool) {
+	// call id and valid until
+	if len(msg) < 12 {
+		return time.Time{}, false
+	}
+	_, validUntil = extractCreateCallMessage(msg)
+	return validUntil, true
+}
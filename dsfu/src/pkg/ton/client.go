@@ -0,0 +1,28 @@
+package ton
+
+import (
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/tonclient"
+	"github.com/xssnick/tonutils-go/liteclient"
+	"github.com/xssnick/tonutils-go/ton"
+	"github.com/xssnick/tonutils-go/ton/wallet"
+	"log"
+	"os"
+)
+
+// shared is the node client of the helpers of this package
+var shared = tonclient.NewShared(func() (*NodeToncli, error) {
+	node, err := NewNodeToncli(os.Getenv("TON_SEED"), wallet.V3R2, os.Getenv("TON_MASTER_CONTRACT"))
+	if err != nil {
+		log.Printf("NewNodeToncli %v: %v", os.Getenv("TON_MASTER_CONTRACT"), err)
+	}
+	return node, err
+})
+
+// connect a pool to the lite servers of the global config
+func connect() (*liteclient.ConnectionPool, *ton.APIClient, error) {
+	pool, api, err := tonclient.Connect()
+	if err != nil {
+		return nil, nil, liteServerError(err)
+	}
+	return pool, api, nil
+}
@@ -1,15 +1,22 @@
 package ton
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/xssnick/tonutils-go/address"
 	"github.com/xssnick/tonutils-go/tlb"
 	"github.com/xssnick/tonutils-go/ton"
 	"github.com/xssnick/tonutils-go/ton/wallet"
 	"github.com/xssnick/tonutils-go/tvm/cell"
+	"log"
+	"time"
 )
 
+// processedPollInterval between the lookups of the transaction processing a message
+const processedPollInterval = 2 * time.Second
+
 type Contract struct {
 	api  *ton.APIClient
 	addr *address.Address
@@ -60,3 +67,181 @@ func (c *Contract) GetBalance() (tlb.Coins, error) {
 
 	return acc.State.Balance, nil
 }
+
+// sendTracked sends body and waits until the contract processed it, retried
+// by DefaultTracker while the user message signed into body is valid. A
+// retry first looks for the message of an earlier attempt, processed by the
+// contract or sent by the wallet and on its way, and only sends it again
+// when the wallet did not send it
+func (c *Contract) sendTracked(ctx context.Context, via *wallet.Wallet, body *cell.Cell, userMsg []byte) error {
+	validUntil, ok := messageValidUntil(userMsg)
+	if !ok {
+		return &Error{Kind: ErrRejected, Err: errors.New("malformed user message")}
+	}
+
+	var contractLT, walletLT uint64
+	sent := false
+	err := DefaultTracker.Track(ctx, validUntil, func(ctx context.Context) error {
+		if !sent {
+			var err error
+			if contractLT, err = c.lastTxLT(ctx, c.addr); err != nil {
+				return liteServerError(fmt.Errorf("lastTxLT: %w", err))
+			}
+			if walletLT, err = c.lastTxLT(ctx, via.Address()); err != nil {
+				return liteServerError(fmt.Errorf("lastTxLT: %w", err))
+			}
+			sent = true
+			return c.sendProcessed(ctx, via, body, contractLT)
+		}
+
+		tx, err := c.findTransaction(ctx, via.Address(), body.Hash(), contractLT)
+		if err != nil {
+			return liteServerError(fmt.Errorf("findTransaction: %w", err))
+		}
+		if tx != nil {
+			return processed(tx)
+		}
+		out, err := c.findSent(ctx, via.Address(), body.Hash(), walletLT)
+		if err != nil {
+			return liteServerError(fmt.Errorf("findSent: %w", err))
+		}
+		if out != nil {
+			return c.waitProcessed(ctx, via.Address(), body.Hash(), contractLT)
+		}
+		return c.sendProcessed(ctx, via, body, contractLT)
+	})
+
+	if errors.Is(err, ErrExpired) && sent {
+		// the last attempt may have timed out while its message was processed
+		lookupCtx, cancel := context.WithTimeout(context.Background(), DefaultTracker.Timeout)
+		defer cancel()
+		if tx, findErr := c.findTransaction(lookupCtx, via.Address(), body.Hash(), contractLT); findErr == nil && tx != nil {
+			return processed(tx)
+		}
+	}
+	return err
+}
+
+// sendProcessed sends body and waits for the transaction of the contract
+// processing it, after the transaction afterLT
+func (c *Contract) sendProcessed(ctx context.Context, via *wallet.Wallet, body *cell.Cell, afterLT uint64) error {
+	_, err := via.SendManyWaitTxHash(ctx, []*wallet.Message{{
+		Mode: 1, // pay fees separately (from balance, not from amount)
+		InternalMessage: &tlb.InternalMessage{
+			Bounce:  true,
+			DstAddr: c.addr,
+			Amount:  tlb.MustFromTON("0.1"),
+			Body:    body,
+		},
+	}})
+	if err != nil {
+		return liteServerError(fmt.Errorf("wallet.SendManyWaitTxHash: %w", err))
+	}
+	return c.waitProcessed(ctx, via.Address(), body.Hash(), afterLT)
+}
+
+// waitProcessed polls for the transaction of the contract processing the
+// message of from with the body of bodyHash, after the transaction afterLT
+func (c *Contract) waitProcessed(ctx context.Context, from *address.Address, bodyHash []byte, afterLT uint64) error {
+	// the message of the wallet is processed in a later block
+	for {
+		tx, err := c.findTransaction(ctx, from, bodyHash, afterLT)
+		if err != nil {
+			log.Printf("findTransaction: %v", err)
+		} else if tx != nil {
+			return processed(tx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return liteServerError(fmt.Errorf("transaction not processed: %w", ctx.Err()))
+		case <-time.After(processedPollInterval):
+		}
+	}
+}
+
+// lastTxLT of the account at addr, 0 if it has no transaction
+func (c *Contract) lastTxLT(ctx context.Context, addr *address.Address) (uint64, error) {
+	block, err := c.api.CurrentMasterchainInfo(ctx)
+	if err != nil {
+		return 0, err
+	}
+	acc, err := c.api.GetAccount(ctx, block, addr)
+	if err != nil {
+		return 0, err
+	}
+	return acc.LastTxLT, nil
+}
+
+// findTransaction of the contract after afterLT processing the message of
+// from with the body of bodyHash, nil if it was not processed yet. The first
+// transaction is returned, a duplicate of the message bounces after it
+func (c *Contract) findTransaction(ctx context.Context, from *address.Address, bodyHash []byte, afterLT uint64) (*tlb.Transaction, error) {
+	return c.oldestTransaction(ctx, c.addr, afterLT, func(tx *tlb.Transaction) bool {
+		if tx.IO.In == nil || tx.IO.In.MsgType != tlb.MsgTypeInternal {
+			return false
+		}
+		in := tx.IO.In.AsInternal()
+		return in.SrcAddr.String() == from.String() && bytes.Equal(in.Body.Hash(), bodyHash)
+	})
+}
+
+// findSent transaction of the wallet at from after afterLT sending the body
+// of bodyHash to the contract, nil if the wallet did not send it
+func (c *Contract) findSent(ctx context.Context, from *address.Address, bodyHash []byte, afterLT uint64) (*tlb.Transaction, error) {
+	return c.oldestTransaction(ctx, from, afterLT, func(tx *tlb.Transaction) bool {
+		for _, out := range tx.IO.Out {
+			if out.MsgType != tlb.MsgTypeInternal {
+				continue
+			}
+			msg := out.AsInternal()
+			if msg.DstAddr.String() == c.addr.String() && bytes.Equal(msg.Body.Hash(), bodyHash) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// oldestTransaction of the account at addr after afterLT which matches
+func (c *Contract) oldestTransaction(ctx context.Context, addr *address.Address, afterLT uint64, match func(tx *tlb.Transaction) bool) (*tlb.Transaction, error) {
+	block, err := c.api.CurrentMasterchainInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	acc, err := c.api.GetAccount(ctx, block, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var found *tlb.Transaction
+	lt, hash := acc.LastTxLT, acc.LastTxHash
+	for lt > afterLT {
+		txs, err := c.api.ListTransactions(ctx, addr, 10, lt, hash)
+		if err != nil {
+			return nil, err
+		}
+		if len(txs) == 0 {
+			break
+		}
+		// the oldest transaction is first
+		for _, tx := range txs {
+			if tx.LT > afterLT && match(tx) {
+				found = tx
+				break
+			}
+		}
+		lt, hash = txs[0].PrevTxLT, txs[0].PrevTxHash
+	}
+	return found, nil
+}
+
+// processed transaction of a message, the contract bounces the messages it rejects
+func processed(tx *tlb.Transaction) error {
+	for _, out := range tx.IO.Out {
+		if out.MsgType == tlb.MsgTypeInternal && out.AsInternal().Bounced {
+			return &Error{Kind: ErrRejected, Err: fmt.Errorf("transaction %x bounced", tx.Hash)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package ton
+
+import (
+	"errors"
+	"github.com/xssnick/tonutils-go/ton"
+)
+
+var (
+	// ErrUnavailable lite servers could not be reached or failed, retry later
+	ErrUnavailable = errors.New("ton unavailable")
+	// ErrMisconfigured wallet seed, master contract or node contract of this node
+	ErrMisconfigured = errors.New("ton misconfigured")
+	// ErrNotRegistered no contract deployed by the master contract for the address
+	ErrNotRegistered = errors.New("not registered")
+	// ErrInvalidAddress of a wallet
+	ErrInvalidAddress = errors.New("invalid address")
+	// ErrRejected transaction bounced by the contract
+	ErrRejected = errors.New("transaction rejected")
+	// ErrExpired signed message of a user before its transaction was processed
+	ErrExpired = errors.New("signed message expired")
+)
+
+// Error of the TON client, errors.Is matches its Kind
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// liteServerError classifies err of a lite server request, get methods of
+// contracts that are not deployed fail with an exit code
+func liteServerError(err error) error {
+	var execErr ton.ContractExecError
+	if errors.As(err, &execErr) {
+		return &Error{Kind: ErrNotRegistered, Err: err}
+	}
+	return &Error{Kind: ErrUnavailable, Err: err}
+}
+
+func misconfigured(err error) error {
+	return &Error{Kind: ErrMisconfigured, Err: err}
+}
@@ -1,6 +1,7 @@
 package ton
 
 import (
+	"context"
 	"crypto/ed25519"
 	"github.com/xssnick/tonutils-go/address"
 	"github.com/xssnick/tonutils-go/ton"
@@ -42,22 +43,19 @@ func (c *NodeContract) SendWithdraw(via *wallet.Wallet, amount uint64) error {
 	return c.send(via, body)
 }
 
-func (c *NodeContract) SendCreateCall(via *wallet.Wallet, userAddr *address.Address, userSign []byte, userMsg []byte) error {
-	body := cell.BeginCell().
-		MustStoreUInt(opNodeCreateCall, 32).
-		MustStoreUInt(0, 64).
-		MustStoreAddr(userAddr).
-		MustStoreRef(cell.BeginCell().
-			MustStoreSlice(userSign, 8*uint(len(userSign))).
-			MustStoreSlice(userMsg, 8*uint(len(userMsg))).
-			EndCell()).
-		EndCell()
-	return c.send(via, body)
+// SendCreateCall and wait until the node contract processed it, see sendTracked
+func (c *NodeContract) SendCreateCall(ctx context.Context, via *wallet.Wallet, userAddr *address.Address, userSign []byte, userMsg []byte) error {
+	return c.sendTracked(ctx, via, callBody(opNodeCreateCall, userAddr, userSign, userMsg), userMsg)
 }
 
-func (c *NodeContract) SendEndCall(via *wallet.Wallet, userAddr *address.Address, userSign []byte, userMsg []byte) error {
-	body := cell.BeginCell().
-		MustStoreUInt(opNodeEndCall, 32).
+// SendEndCall and wait until the node contract processed it, see sendTracked
+func (c *NodeContract) SendEndCall(ctx context.Context, via *wallet.Wallet, userAddr *address.Address, userSign []byte, userMsg []byte) error {
+	return c.sendTracked(ctx, via, callBody(opNodeEndCall, userAddr, userSign, userMsg), userMsg)
+}
+
+func callBody(op uint64, userAddr *address.Address, userSign []byte, userMsg []byte) *cell.Cell {
+	return cell.BeginCell().
+		MustStoreUInt(op, 32).
 		MustStoreUInt(0, 64).
 		MustStoreAddr(userAddr).
 		MustStoreRef(cell.BeginCell().
@@ -65,7 +63,6 @@ func (c *NodeContract) SendEndCall(via *wallet.Wallet, userAddr *address.Address
 			MustStoreSlice(userMsg, 8*uint(len(userMsg))).
 			EndCell()).
 		EndCell()
-	return c.send(via, body)
 }
 
 func (c *NodeContract) GetData() (*NodeContractData, error) {
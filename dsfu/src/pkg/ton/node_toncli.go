@@ -14,6 +14,7 @@ import (
 )
 
 type NodeToncli struct {
+	pool           *liteclient.ConnectionPool
 	api            *ton.APIClient
 	wallet         *wallet.Wallet
 	masterContract *MasterContract
@@ -21,57 +22,65 @@ type NodeToncli struct {
 }
 
 func NewNodeToncli(walletSeed string, walletVersion wallet.Version, masterContractAddr string) (*NodeToncli, error) {
-	client := liteclient.NewConnectionPool()
-
-	configUrl := "https://ton-blockchain.github.io/testnet-global.config.json"
-	if err := client.AddConnectionsFromConfigUrl(context.Background(), configUrl); err != nil {
-		return nil, fmt.Errorf("client.AddConnectionsFromConfigUrl: %w", err)
+	pool, api, err := connect()
+	if err != nil {
+		return nil, err
 	}
-	api := ton.NewAPIClient(client)
 
 	w, err := wallet.FromSeed(api, strings.Split(walletSeed, " "), walletVersion)
 	if err != nil {
-		return nil, fmt.Errorf("wallet.FromSeed: %w", err)
+		return nil, misconfigured(fmt.Errorf("wallet.FromSeed: %w", err))
 	}
 
 	block, err := api.CurrentMasterchainInfo(context.Background())
 	if err != nil {
-		return nil, fmt.Errorf("api.CurrentMasterchainInfo: %w", err)
+		return nil, liteServerError(fmt.Errorf("api.CurrentMasterchainInfo: %w", err))
 	}
 
 	if walletBalance, err := w.GetBalance(context.Background(), block); err != nil {
-		return nil, fmt.Errorf("w.GetBalance: %w", err)
+		return nil, liteServerError(fmt.Errorf("w.GetBalance: %w", err))
 	} else {
-		fmt.Printf("node wallet (address = %s, balance = %s)\n", w.Address(), walletBalance)
+		log.Printf("node wallet (address = %s, balance = %s)", w.Address(), walletBalance)
+	}
+
+	masterAddr, err := address.ParseAddr(masterContractAddr)
+	if err != nil {
+		return nil, misconfigured(fmt.Errorf("address.ParseAddr: %w", err))
 	}
 
-	masterContract := OpenMasterContract(api, address.MustParseAddr(masterContractAddr))
+	masterContract := OpenMasterContract(api, masterAddr)
 	if hosts, err := masterContract.GetNodeHosts(); err != nil {
-		return nil, fmt.Errorf("masterContract.GetNodeHosts: %w\n", err)
+		return nil, liteServerError(fmt.Errorf("masterContract.GetNodeHosts: %w\n", err))
 	} else {
-		fmt.Printf("hosts = %s\n", hosts)
+		log.Printf("hosts = %s", hosts)
 	}
 
 	contractAddr, err := masterContract.GetNodeContractAddress(w.Address())
 	if err != nil {
-		return nil, fmt.Errorf("masterContract.GetNodeContractAddress: %w\n", err)
+		return nil, liteServerError(fmt.Errorf("masterContract.GetNodeContractAddress: %w\n", err))
 	}
 
-	fmt.Printf("node contract (address = %s)\n", contractAddr)
+	log.Printf("node contract (address = %s)", contractAddr)
 
 	contract := OpenNodeContract(api, contractAddr)
 	if nodeData, err := contract.GetData(); err != nil {
-		return nil, fmt.Errorf("contract.GetData(): %w\n", err)
+		// a node without contract is not registered by the master contract
+		err = liteServerError(fmt.Errorf("contract.GetData(): %w\n", err))
+		if errors.Is(err, ErrNotRegistered) {
+			err = misconfigured(err)
+		}
+		return nil, err
 	} else {
 		if nodeData.Master.String() != masterContractAddr || nodeData.Owner.String() != w.Address().String() {
-			return nil, errors.New("strange node contract data")
+			return nil, misconfigured(errors.New("strange node contract data"))
 		} else {
 			if contractBalance, err := contract.GetBalance(); err != nil {
-				return nil, fmt.Errorf("contract.GetBalance: %w", err)
+				return nil, liteServerError(fmt.Errorf("contract.GetBalance: %w", err))
 			} else {
-				fmt.Printf("node contract (address = %s, balance = %s)\n", contractAddr, contractBalance)
+				log.Printf("node contract (address = %s, balance = %s)", contractAddr, contractBalance)
 			}
 			return &NodeToncli{
+				pool:           pool,
 				api:            api,
 				wallet:         w,
 				masterContract: masterContract,
@@ -86,31 +95,101 @@ func (c *NodeToncli) GetSignature(data []byte) []byte {
 }
 
 func (c *NodeToncli) GetUserContractPublicKey(userAddr string) (ed25519.PublicKey, error) {
-	userContractAddr, err := c.masterContract.GetUserContractAddress(address.MustParseAddr(userAddr))
+	addr, err := address.ParseAddr(userAddr)
+	if err != nil {
+		return nil, &Error{Kind: ErrInvalidAddress, Err: fmt.Errorf("address.ParseAddr: %w", err)}
+	}
+	userContractAddr, err := c.masterContract.GetUserContractAddress(addr)
 	if err != nil {
-		return nil, fmt.Errorf("masterContract.GetUserContractAddress: %w", err)
+		return nil, liteServerError(fmt.Errorf("masterContract.GetUserContractAddress: %w", err))
 	}
 	userContract := OpenUserContract(c.api, userContractAddr)
 	userContractData, err := userContract.GetData()
 	if err != nil {
-		return nil, fmt.Errorf("userContract.GetData: %w", err)
+		return nil, liteServerError(fmt.Errorf("userContract.GetData: %w", err))
 	}
 	return userContractData.PublicKey, nil
 }
 
+// CreateCall on the node contract, the error is ErrRejected when the contract
+// bounced it and ErrExpired when the user message expired before
 func (c *NodeToncli) CreateCall(userAddr string, userSign []byte, userMsg []byte) error {
 	log.Printf("CreateCall: %v %v %v %v", c.wallet, userAddr, userSign, userMsg)
-	err := c.contract.SendCreateCall(c.wallet, address.MustParseAddr(userAddr), userSign, userMsg)
+	addr, err := address.ParseAddr(userAddr)
 	if err != nil {
-		err = fmt.Errorf("SendCreateCall: %w", err)
+		return &Error{Kind: ErrInvalidAddress, Err: fmt.Errorf("address.ParseAddr: %w", err)}
+	}
+	if err := c.contract.SendCreateCall(context.Background(), c.wallet, addr, userSign, userMsg); err != nil {
+		return fmt.Errorf("SendCreateCall: %w", err)
 	}
-	return err
+	return nil
 }
 
+// EndCall on the node contract, errors as CreateCall
 func (c *NodeToncli) EndCall(userAddr string, userSign []byte, userMsg []byte) error {
-	err := c.contract.SendEndCall(c.wallet, address.MustParseAddr(userAddr), userSign, userMsg)
+	addr, err := address.ParseAddr(userAddr)
+	if err != nil {
+		return &Error{Kind: ErrInvalidAddress, Err: fmt.Errorf("address.ParseAddr: %w", err)}
+	}
+	if err := c.contract.SendEndCall(context.Background(), c.wallet, addr, userSign, userMsg); err != nil {
+		return fmt.Errorf("SendEndCall: %w", err)
+	}
+	return nil
+}
+
+// IsCallOpen tells if the call of the signed create call message userMsg
+// was created on the user contract and not ended yet
+func (c *NodeToncli) IsCallOpen(userAddr string, userMsg []byte) (bool, error) {
+	if _, ok := messageValidUntil(userMsg); !ok {
+		return false, &Error{Kind: ErrRejected, Err: errors.New("malformed user message")}
+	}
+	callID, _ := extractCreateCallMessage(userMsg)
+
+	addr, err := address.ParseAddr(userAddr)
+	if err != nil {
+		return false, &Error{Kind: ErrInvalidAddress, Err: fmt.Errorf("address.ParseAddr: %w", err)}
+	}
+	userContractAddr, err := c.masterContract.GetUserContractAddress(addr)
 	if err != nil {
-		err = fmt.Errorf("SendEndCall: %w", err)
+		return false, liteServerError(fmt.Errorf("masterContract.GetUserContractAddress: %w", err))
 	}
-	return err
+	callIDs, err := OpenUserContract(c.api, userContractAddr).GetCallIds()
+	if err != nil {
+		return false, liteServerError(fmt.Errorf("userContract.GetCallIds: %w", err))
+	}
+	for _, ID := range callIDs {
+		if ID == callID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *NodeToncli) GetNodeHosts() (map[string]*address.Address, error) {
+	hosts, err := c.masterContract.GetNodeHosts()
+	if err != nil {
+		err = liteServerError(fmt.Errorf("masterContract.GetNodeHosts: %w", err))
+	}
+	return hosts, err
+}
+
+func (c *NodeToncli) GetNodeHost() (string, error) {
+	data, err := c.contract.GetData()
+	if err != nil {
+		return "", liteServerError(fmt.Errorf("contract.GetData: %w", err))
+	}
+	return data.NodeHost, nil
+}
+
+// Ping a lite server
+func (c *NodeToncli) Ping(ctx context.Context) error {
+	if _, err := c.api.GetMasterchainInfo(ctx); err != nil {
+		return liteServerError(fmt.Errorf("api.GetMasterchainInfo: %w", err))
+	}
+	return nil
+}
+
+// Pool of the lite server connections
+func (c *NodeToncli) Pool() *liteclient.ConnectionPool {
+	return c.pool
 }
@@ -1,30 +1,45 @@
 package ton
 
 import (
+	"bytes"
 	"crypto/ed25519"
-	"github.com/xssnick/tonutils-go/ton/wallet"
+	"fmt"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/tonclient"
+	"github.com/xssnick/tonutils-go/address"
 	"log"
-	"os"
+	"sort"
+	"time"
 )
 
-func GetClientPubKey(userWalletAddr string) (ed25519.PublicKey, error) {
-	var result ed25519.PublicKey
-	nodeToncli, err := NewNodeToncli(os.Getenv("TON_SEED"), wallet.V3R2, os.Getenv("TON_MASTER_CONTRACT"))
-	if err != nil {
-		log.Printf("NewNodeToncli %v", os.Getenv("TON_MASTER_CONTRACT"))
-		return result, err
-	}
+const (
+	// userPublicKeyTTL of the public keys of user contracts, checked by every join
+	userPublicKeyTTL = 5 * time.Minute
+	// nodeHostsTTL of the hosts registered in the master contract
+	nodeHostsTTL = time.Minute
+)
 
-	result, err = nodeToncli.GetUserContractPublicKey(userWalletAddr)
-	if err != nil {
-		log.Printf("GetUserContractPublicKey %v", userWalletAddr)
-		return result, err
-	}
-	return result, nil
+var (
+	userPublicKeys = tonclient.NewCache[ed25519.PublicKey](userPublicKeyTTL)
+	nodeHosts      = tonclient.NewCache[map[string]*address.Address](nodeHostsTTL)
+)
+
+func GetClientPubKey(userWalletAddr string) (ed25519.PublicKey, error) {
+	return userPublicKeys.Get(userWalletAddr, func() (ed25519.PublicKey, error) {
+		nodeToncli, err := shared.Get()
+		if err != nil {
+			return nil, err
+		}
+		result, err := nodeToncli.GetUserContractPublicKey(userWalletAddr)
+		if err != nil {
+			log.Printf("GetUserContractPublicKey %v", userWalletAddr)
+			return nil, err
+		}
+		return result, nil
+	})
 }
 
 func CreateCall(userWalletAddr string, userSign []byte, userMsg []byte) error {
-	nodeToncli, err := NewNodeToncli(os.Getenv("TON_SEED"), wallet.V3R2, os.Getenv("TON_MASTER_CONTRACT"))
+	nodeToncli, err := shared.Get()
 	if err != nil {
 		return err
 	}
@@ -32,7 +47,7 @@ func CreateCall(userWalletAddr string, userSign []byte, userMsg []byte) error {
 }
 
 func GetSignature(data []byte) ([]byte, error) {
-	nodeToncli, err := NewNodeToncli(os.Getenv("TON_SEED"), wallet.V3R2, os.Getenv("TON_MASTER_CONTRACT"))
+	nodeToncli, err := shared.Get()
 	if err != nil {
 		return nil, err
 	}
@@ -41,9 +56,59 @@ func GetSignature(data []byte) ([]byte, error) {
 }
 
 func EndCall(userWalletAddr string, userSign []byte, userMsg []byte) error {
-	nodeToncli, err := NewNodeToncli(os.Getenv("TON_SEED"), wallet.V3R2, os.Getenv("TON_MASTER_CONTRACT"))
+	nodeToncli, err := shared.Get()
 	if err != nil {
 		return err
 	}
 	return nodeToncli.EndCall(userWalletAddr, userSign, userMsg)
 }
+
+// IsCallOpen tells if the call of the signed create call message userMsg is
+// open on the user contract of userWalletAddr, created and not ended
+func IsCallOpen(userWalletAddr string, userMsg []byte) (bool, error) {
+	nodeToncli, err := shared.Get()
+	if err != nil {
+		return false, err
+	}
+	return nodeToncli.IsCallOpen(userWalletAddr, userMsg)
+}
+
+// IsNodeAddress tells if addr is the address of the node contract of this node
+func IsNodeAddress(addr string) (bool, error) {
+	parsed, err := address.ParseAddr(addr)
+	if err != nil {
+		return false, &Error{Kind: ErrInvalidAddress, Err: fmt.Errorf("address.ParseAddr: %w", err)}
+	}
+	nodeToncli, err := shared.Get()
+	if err != nil {
+		return false, err
+	}
+	own := nodeToncli.contract.addr
+	return parsed.Workchain() == own.Workchain() && bytes.Equal(parsed.Data(), own.Data()), nil
+}
+
+// GetOtherNodeHosts registered in the master contract, the host of this node excluded
+func GetOtherNodeHosts() ([]string, error) {
+	nodeToncli, err := shared.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	self, err := nodeToncli.GetNodeHost()
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := nodeHosts.Get("", nodeToncli.GetNodeHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(hosts))
+	for host := range hosts {
+		if host != self {
+			result = append(result, host)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
@@ -0,0 +1,77 @@
+package ton
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Tracker retries a transaction until it was processed, rejected by the
+// contract or the signed message of the user expired
+type Tracker struct {
+	// Backoff before the first retry, doubled after every attempt up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout of an attempt, from sending to the transaction of the contract,
+	// capped at the time left before validUntil
+	Timeout time.Duration
+}
+
+// DefaultTracker of the call transactions of the node contract
+var DefaultTracker = &Tracker{
+	Backoff:    2 * time.Second,
+	MaxBackoff: 16 * time.Second,
+	Timeout:    45 * time.Second,
+}
+
+// Track attempts a transaction until it succeeds, fails for good or no retry
+// could start before validUntil. Errors of lite servers are retried,
+// rejected transactions and invalid settings are final. The error of the
+// last attempt is returned as ErrExpired when validUntil passed
+func (t *Tracker) Track(ctx context.Context, validUntil time.Time, attempt func(ctx context.Context) error) error {
+	backoff := t.Backoff
+	for attempts := 1; ; attempts++ {
+		// a message processed after validUntil is rejected anyway
+		deadline := time.Now().Add(t.Timeout)
+		if validUntil.Before(deadline) {
+			deadline = validUntil
+		}
+		attemptCtx, cancel := context.WithDeadline(ctx, deadline)
+		err := attempt(attemptCtx)
+		cancel()
+		if err == nil {
+			if attempts > 1 {
+				log.Printf("transaction processed after %d attempts", attempts)
+			}
+			return nil
+		}
+		if !retry(err) {
+			return err
+		}
+
+		if time.Now().Add(backoff).After(validUntil) {
+			return &Error{Kind: ErrExpired, Err: fmt.Errorf("%d attempts until %v: %w", attempts, validUntil, err)}
+		}
+		log.Printf("transaction attempt %d: %v, retry in %v", attempts, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > t.MaxBackoff {
+			backoff = t.MaxBackoff
+		}
+	}
+}
+
+// retry errors of lite servers, the others fail the same way again
+func retry(err error) bool {
+	return !errors.Is(err, ErrRejected) &&
+		!errors.Is(err, ErrMisconfigured) &&
+		!errors.Is(err, ErrNotRegistered) &&
+		!errors.Is(err, ErrInvalidAddress)
+}
@@ -0,0 +1,84 @@
+package ton
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testTracker = &Tracker{
+	Backoff:    time.Millisecond,
+	MaxBackoff: 4 * time.Millisecond,
+	Timeout:    time.Second,
+}
+
+func TestTrackRetriesUnavailable(t *testing.T) {
+	attempts := 0
+	err := testTracker.Track(context.Background(), time.Now().Add(time.Minute), func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return &Error{Kind: ErrUnavailable, Err: errors.New("timeout")}
+		}
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestTrackRejectedIsFinal(t *testing.T) {
+	attempts := 0
+	err := testTracker.Track(context.Background(), time.Now().Add(time.Minute), func(ctx context.Context) error {
+		attempts++
+		return &Error{Kind: ErrRejected, Err: errors.New("bounced")}
+	})
+
+	assert.ErrorIs(t, err, ErrRejected)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestTrackExpired(t *testing.T) {
+	attempts := 0
+	// no retry starts before the signed message expires
+	err := testTracker.Track(context.Background(), time.Now().Add(time.Millisecond/2), func(ctx context.Context) error {
+		attempts++
+		return &Error{Kind: ErrUnavailable, Err: errors.New("timeout")}
+	})
+
+	assert.ErrorIs(t, err, ErrExpired)
+	assert.ErrorIs(t, err, ErrUnavailable, "the error of the last attempt is kept")
+	assert.Equal(t, 1, attempts)
+}
+
+func TestTrackCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	tracker := &Tracker{Backoff: time.Hour, MaxBackoff: time.Hour, Timeout: time.Second}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	err := tracker.Track(ctx, time.Now().Add(2*time.Hour), func(ctx context.Context) error {
+		return &Error{Kind: ErrUnavailable, Err: errors.New("timeout")}
+	})
+
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestTrackTimeoutCappedAtValidUntil(t *testing.T) {
+	tracker := &Tracker{Backoff: time.Millisecond, MaxBackoff: time.Millisecond, Timeout: time.Hour}
+	validUntil := time.Now().Add(50 * time.Millisecond)
+
+	err := tracker.Track(context.Background(), validUntil, func(ctx context.Context) error {
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.False(t, deadline.After(validUntil), "an attempt ends with the signed message")
+		<-ctx.Done()
+		return &Error{Kind: ErrUnavailable, Err: ctx.Err()}
+	})
+
+	assert.ErrorIs(t, err, ErrExpired)
+}
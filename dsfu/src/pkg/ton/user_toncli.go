@@ -10,6 +10,7 @@ import (
 	"github.com/xssnick/tonutils-go/ton"
 	"github.com/xssnick/tonutils-go/ton/wallet"
 	"github.com/xssnick/tonutils-go/tvm/cell"
+	"log"
 	"strings"
 	"time"
 )
@@ -43,14 +44,14 @@ func NewUserToncli(walletSeed string, walletVersion wallet.Version, masterContra
 	if walletBalance, err := w.GetBalance(context.Background(), block); err != nil {
 		return nil, fmt.Errorf("w.GetBalance: %w", err)
 	} else {
-		fmt.Printf("user wallet (address = %s, balance = %s)\n", w.Address(), walletBalance)
+		log.Printf("user wallet (address = %s, balance = %s)", w.Address(), walletBalance)
 	}
 
 	masterContract := OpenMasterContract(api, address.MustParseAddr(masterContractAddr))
 	if hosts, err := masterContract.GetNodeHosts(); err != nil {
 		return nil, fmt.Errorf("masterContract.GetNodeHosts: %w\n", err)
 	} else {
-		fmt.Printf("hosts = %s\n", hosts)
+		log.Printf("hosts = %s", hosts)
 	}
 
 	contractAddr, err := masterContract.GetUserContractAddress(w.Address())
@@ -68,7 +69,7 @@ func NewUserToncli(walletSeed string, walletVersion wallet.Version, masterContra
 			if contractBalance, err := contract.GetBalance(); err != nil {
 				return nil, fmt.Errorf("contract.GetBalance: %w", err)
 			} else {
-				fmt.Printf("user contract (address = %s, balance = %s)\n", contractAddr, contractBalance)
+				log.Printf("user contract (address = %s, balance = %s)", contractAddr, contractBalance)
 			}
 			return &UserToncli{
 				api:            api,
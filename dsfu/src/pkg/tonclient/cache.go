@@ -0,0 +1,71 @@
+package tonclient
+
+import (
+	"sync"
+	"time"
+)
+
+// Cache of chain lookups by key for ttl, failed lookups are not cached.
+// Concurrent misses of a key share one load
+type Cache[V any] struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]cacheEntry[V]
+	loading map[string]*cacheLoad[V]
+}
+
+type cacheEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// cacheLoad in progress, done is closed when value and err are set
+type cacheLoad[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+func NewCache[V any](ttl time.Duration) *Cache[V] {
+	return &Cache[V]{
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry[V]),
+		loading: make(map[string]*cacheLoad[V]),
+	}
+}
+
+// Get the value of key, load is called when it is missing or expired and no
+// other caller is loading it
+func (c *Cache[V]) Get(key string, load func() (V, error)) (V, error) {
+	c.mu.Lock()
+	if entry, ok := c.entries[key]; ok && time.Now().Before(entry.expires) {
+		c.mu.Unlock()
+		return entry.value, nil
+	}
+	if l, ok := c.loading[key]; ok {
+		c.mu.Unlock()
+		<-l.done
+		return l.value, l.err
+	}
+	l := &cacheLoad[V]{done: make(chan struct{})}
+	c.loading[key] = l
+	c.mu.Unlock()
+
+	l.value, l.err = load()
+
+	c.mu.Lock()
+	delete(c.loading, key)
+	if l.err == nil {
+		now := time.Now()
+		c.entries[key] = cacheEntry[V]{value: l.value, expires: now.Add(c.ttl)}
+		// expired entries go with the writes, the keys are few
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.mu.Unlock()
+	close(l.done)
+	return l.value, l.err
+}
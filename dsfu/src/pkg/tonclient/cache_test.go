@@ -0,0 +1,84 @@
+package tonclient
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheHit(t *testing.T) {
+	c := NewCache[int](time.Minute)
+	loads := 0
+	load := func() (int, error) {
+		loads++
+		return 42, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		value, err := c.Get("key", load)
+		assert.NoError(t, err)
+		assert.Equal(t, 42, value)
+	}
+	assert.Equal(t, 1, loads)
+}
+
+func TestCacheExpires(t *testing.T) {
+	c := NewCache[int](time.Millisecond)
+	loads := 0
+	load := func() (int, error) {
+		loads++
+		return loads, nil
+	}
+
+	value, _ := c.Get("key", load)
+	assert.Equal(t, 1, value)
+	time.Sleep(2 * time.Millisecond)
+	value, _ = c.Get("key", load)
+	assert.Equal(t, 2, value)
+}
+
+func TestCacheSkipsErrors(t *testing.T) {
+	c := NewCache[int](time.Minute)
+	timeout := errors.New("timeout")
+	_, err := c.Get("key", func() (int, error) {
+		return 0, timeout
+	})
+	assert.ErrorIs(t, err, timeout)
+
+	value, err := c.Get("key", func() (int, error) {
+		return 7, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, value, "failed lookups are tried again")
+}
+
+func TestCacheSharesLoad(t *testing.T) {
+	c := NewCache[int](time.Minute)
+	var loads int32
+	release := make(chan struct{})
+	load := func() (int, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return 42, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := c.Get("key", load)
+			assert.NoError(t, err)
+			assert.Equal(t, 42, value)
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+}
@@ -0,0 +1,144 @@
+// Package tonclient keeps the lite server connections and caches the chain
+// lookups of the ton packages of the nodes and of the client backend
+package tonclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/xssnick/tonutils-go/liteclient"
+	"github.com/xssnick/tonutils-go/ton"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	globalConfigUrl = "https://ton-blockchain.github.io/testnet-global.config.json"
+
+	// redials of a dropped lite server connection, the shared client dials
+	// the lite servers again once none of its connections is left
+	redialTries    = 5
+	redialInterval = 3 * time.Second
+
+	healthCheckInterval = 30 * time.Second
+	healthCheckTimeout  = 10 * time.Second
+
+	// openBackoff after a failed open, calls fail with its error meanwhile
+	openBackoff = 5 * time.Second
+)
+
+// Connect a pool to the lite servers of the global config
+func Connect() (*liteclient.ConnectionPool, *ton.APIClient, error) {
+	pool := liteclient.NewConnectionPool()
+	pool.SetOnDisconnect(pool.DefaultReconnect(redialInterval, redialTries))
+	if err := Dial(context.Background(), pool); err != nil {
+		return nil, nil, err
+	}
+	return pool, ton.NewAPIClient(pool), nil
+}
+
+// Dial the lite servers of the global config into pool
+func Dial(ctx context.Context, pool *liteclient.ConnectionPool) error {
+	if err := pool.AddConnectionsFromConfigUrl(ctx, globalConfigUrl); err != nil {
+		return fmt.Errorf("client.AddConnectionsFromConfigUrl: %w", err)
+	}
+	return nil
+}
+
+// Client of the lite servers opened by Shared
+type Client interface {
+	// Ping a lite server
+	Ping(ctx context.Context) error
+	// Pool of the lite server connections of the client
+	Pool() *liteclient.ConnectionPool
+}
+
+// Shared client of the helpers of a ton package. It is opened by the first
+// call and kept for the life of the process, the pool can't be closed. Its
+// health is checked in the background and the lite servers are dialed again
+// when the pool lost all its connections
+type Shared[C Client] struct {
+	open     func() (C, error)
+	mu       sync.Mutex
+	client   C
+	ok       bool
+	err      error
+	failedAt time.Time
+	// opening is closed when the open in progress ended
+	opening chan struct{}
+}
+
+// NewShared client opened by open
+func NewShared[C Client](open func() (C, error)) *Shared[C] {
+	return &Shared[C]{open: open}
+}
+
+// Get the open client, opening it if needed. Concurrent callers wait for the
+// same open, which runs without the lock
+func (s *Shared[C]) Get() (C, error) {
+	var none C
+
+	s.mu.Lock()
+	for s.opening != nil {
+		opening := s.opening
+		s.mu.Unlock()
+		<-opening
+		s.mu.Lock()
+	}
+	if s.ok {
+		client := s.client
+		s.mu.Unlock()
+		return client, nil
+	}
+	if s.err != nil && time.Since(s.failedAt) < openBackoff {
+		err := s.err
+		s.mu.Unlock()
+		return none, err
+	}
+	opening := make(chan struct{})
+	s.opening = opening
+	s.mu.Unlock()
+
+	client, err := s.open()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opening = nil
+	close(opening)
+	if err != nil {
+		s.err, s.failedAt = err, time.Now()
+		return none, err
+	}
+	s.client, s.ok, s.err = client, true, nil
+	go s.healthCheck(client)
+	return client, nil
+}
+
+// healthCheck the lite servers of client, the connections of its pool redial
+// on their own until redialTries, then the pool is dialed again
+func (s *Shared[C]) healthCheck(client C) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	failures := 0
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+		err := client.Ping(ctx)
+		if err == nil {
+			failures = 0
+			cancel()
+			continue
+		}
+		failures++
+		log.Printf("health check %d: %v", failures, err)
+
+		if errors.Is(err, liteclient.ErrNoActiveConnections) {
+			log.Printf("lite servers unavailable, dialing again")
+			if err := Dial(context.Background(), client.Pool()); err != nil {
+				log.Printf("dial: %v", err)
+			}
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,70 @@
+package tonclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xssnick/tonutils-go/liteclient"
+)
+
+type testClient struct{}
+
+func (c *testClient) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (c *testClient) Pool() *liteclient.ConnectionPool {
+	return nil
+}
+
+func TestSharedOpensOnce(t *testing.T) {
+	var opens int32
+	release := make(chan struct{})
+	opened := &testClient{}
+	s := NewShared(func() (*testClient, error) {
+		atomic.AddInt32(&opens, 1)
+		<-release
+		return opened, nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client, err := s.Get()
+			assert.NoError(t, err)
+			assert.Same(t, opened, client)
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&opens))
+}
+
+func TestSharedBackoff(t *testing.T) {
+	opens := 0
+	unavailable := errors.New("unavailable")
+	s := NewShared(func() (*testClient, error) {
+		opens++
+		return nil, unavailable
+	})
+
+	for i := 0; i < 3; i++ {
+		client, err := s.Get()
+		assert.ErrorIs(t, err, unavailable)
+		assert.Nil(t, client)
+	}
+	assert.Equal(t, 1, opens, "calls fail with the error of the last open")
+
+	s.failedAt = time.Now().Add(-openBackoff)
+	_, _ = s.Get()
+	assert.Equal(t, 2, opens, "opened again after the backoff")
+}
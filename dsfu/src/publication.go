@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/rpcerror"
+)
+
+// Publication sources
+const (
+	SourceCamera     = "camera"
+	SourceMicrophone = "microphone"
+	SourceScreen     = "screen"
+)
+
+// Publication of a participant, tracks of one kind in one stream
+type Publication struct {
+	StreamID string   `json:"streamID"`
+	TrackIDs []string `json:"trackIDs"`
+	Kind     string   `json:"kind"`
+	Source   string   `json:"source"`
+}
+
+// TrackEvent message sent when a track is published or unpublished
+type TrackEvent struct {
+	TrackID     string       `json:"trackID"`
+	Publication *Publication `json:"publication"`
+}
+
+// SetSources declared by the client for its streams, camera or screen
+func (p *Participant) SetSources(sources map[string]string) error {
+	for _, source := range sources {
+		if source != SourceCamera && source != SourceScreen {
+			return rpcerror.ErrBadRequest.Wrap(fmt.Errorf("unknown source: %v", source))
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sources == nil {
+		p.sources = make(map[string]string)
+	}
+	for streamID, source := range sources {
+		p.sources[streamID] = source
+	}
+	return nil
+}
+
+// GetPublications of participant
+func (p *Participant) GetPublications() []*Publication {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Publications
+}
+
+// StreamIDs of all publications
+func (p *Participant) StreamIDs() []string {
+	streamIDs := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, publication := range p.GetPublications() {
+		if !seen[publication.StreamID] {
+			seen[publication.StreamID] = true
+			streamIDs = append(streamIDs, publication.StreamID)
+		}
+	}
+	return streamIDs
+}
+
+// GetStreamID of the primary stream
+func (p *Participant) GetStreamID() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.StreamID
+}
+
+// SetPublications received from the node of a remote participant
+func (p *Participant) SetPublications(publications []*Publication, streamID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Publications = publications
+	p.StreamID = streamID
+}
+
+// addTrack to the publication of its stream and kind, publications are
+// replaced rather than modified so they can be read while being marshalled
+func (p *Participant) addTrack(streamID, trackID, kind string) *Publication {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var publication *Publication
+	publications := make([]*Publication, 0, len(p.Publications)+1)
+	for _, pub := range p.Publications {
+		if pub.StreamID == streamID && pub.Kind == kind {
+			publication = &Publication{
+				StreamID: streamID,
+				TrackIDs: append(append([]string{}, pub.TrackIDs...), trackID),
+				Kind:     kind,
+				Source:   pub.Source,
+			}
+			pub = publication
+		}
+		publications = append(publications, pub)
+	}
+	if publication == nil {
+		publication = &Publication{
+			StreamID: streamID,
+			TrackIDs: []string{trackID},
+			Kind:     kind,
+			Source:   p.source(streamID, kind),
+		}
+		publications = append(publications, publication)
+	}
+
+	p.Publications = publications
+	p.StreamID = p.primaryStreamID()
+	return publication
+}
+
+// removeTrack from its publication, the publication is dropped with its last track
+func (p *Participant) removeTrack(streamID, trackID, kind string) *Publication {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var publication *Publication
+	publications := make([]*Publication, 0, len(p.Publications))
+	for _, pub := range p.Publications {
+		if pub.StreamID != streamID || pub.Kind != kind {
+			publications = append(publications, pub)
+			continue
+		}
+		trackIDs := make([]string, 0, len(pub.TrackIDs))
+		for _, ID := range pub.TrackIDs {
+			if ID != trackID {
+				trackIDs = append(trackIDs, ID)
+			}
+		}
+		publication = &Publication{
+			StreamID: streamID,
+			TrackIDs: trackIDs,
+			Kind:     kind,
+			Source:   pub.Source,
+		}
+		if len(trackIDs) > 0 {
+			publications = append(publications, publication)
+		}
+	}
+
+	p.Publications = publications
+	p.StreamID = p.primaryStreamID()
+	return publication
+}
+
+func (p *Participant) source(streamID, kind string) string {
+	if p.sources[streamID] == SourceScreen {
+		return SourceScreen
+	}
+	if kind == "audio" {
+		return SourceMicrophone
+	}
+	return SourceCamera
+}
+
+// primaryStreamID is the camera stream, kept in StreamID for clients
+// which predate publications
+func (p *Participant) primaryStreamID() string {
+	streamID := ""
+	for _, publication := range p.Publications {
+		if publication.Source == SourceCamera {
+			return publication.StreamID
+		}
+		if streamID == "" && publication.Source != SourceScreen {
+			streamID = publication.StreamID
+		}
+	}
+	return streamID
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/sfu"
+	"github.com/rs/zerolog/log"
+)
+
+// ConnectionQualityInterval in observer ticks between connectionQuality notifications
+const ConnectionQualityInterval = 5
+
+// ConnectionQualityInfo of a participant, excellent, good or poor
+type ConnectionQualityInfo struct {
+	UID     string                `json:"uid"`
+	Quality sfu.ConnectionQuality `json:"quality"`
+}
+
+// updateConnectionQuality measures the local participants, shares them with
+// the other nodes and notifies the local participants of all qualities
+func (r *Room) updateConnectionQuality() {
+	nodeID := r.Node.ID().Pretty()
+
+	local := make([]*ConnectionQualityInfo, 0)
+	r.OnlineParticipants.Range(func(_, ival interface{}) bool {
+		participant, _ := ival.(*Participant)
+		if participant.Host == nodeID && participant.Peer != nil {
+			info := &ConnectionQualityInfo{UID: participant.UID, Quality: participant.Peer.ConnectionQuality()}
+			r.qualities.Store(info.UID, info.Quality)
+			local = append(local, info)
+		}
+		return true
+	})
+	if len(local) == 0 {
+		return
+	}
+
+	r.Publish("connectionQuality", local)
+	r.BroadcastLocal("connectionQuality", r.GetConnectionQualities())
+}
+
+// OnRemoteConnectionQuality of the participants of another node
+func (r *Room) OnRemoteConnectionQuality(payload json.RawMessage) {
+	var qualities []*ConnectionQualityInfo
+	if err := json.Unmarshal(payload, &qualities); err != nil {
+		log.Error().Err(err).Msg("connectionQuality")
+		return
+	}
+	for _, info := range qualities {
+		r.qualities.Store(info.UID, info.Quality)
+	}
+}
+
+// GetConnectionQualities of the online participants, qualities of the
+// participants who left are dropped
+func (r *Room) GetConnectionQualities() []*ConnectionQualityInfo {
+	qualities := make([]*ConnectionQualityInfo, 0)
+	r.qualities.Range(func(ikey, ival interface{}) bool {
+		UID := ikey.(string)
+		if _, ok := r.OnlineParticipants.Load(UID); !ok {
+			r.qualities.Delete(UID)
+			return true
+		}
+		qualities = append(qualities, &ConnectionQualityInfo{UID: UID, Quality: ival.(sfu.ConnectionQuality)})
+		return true
+	})
+	return qualities
+}
@@ -4,13 +4,22 @@ import (
 	"context"
 	"crypto/ed25519"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/carlmjohnson/requests"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/billing"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/call"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/node"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/recorder"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/relay"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/roomstate"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/rpcerror"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/sfu"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/stats"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/ton"
 	"github.com/lucsky/cuid"
+	"github.com/pion/webrtc/v3"
 	"github.com/rs/zerolog/log"
-	"main/pkg/node"
-	"main/pkg/relay"
-	"main/pkg/sfu"
-	"main/pkg/ton"
 	"math"
 	"sync"
 	"time"
@@ -22,6 +31,9 @@ var Rooms sync.Map
 // Handlers global pubsub handlers
 var Handlers sync.Map
 
+// ChatHistorySize max chat messages kept per room on each node
+const ChatHistorySize = 100
+
 // Room for participants
 type Room struct {
 	SID                 string
@@ -31,18 +43,32 @@ type Room struct {
 	Hosts               sync.Map
 	Node                node.Node
 	RemoteViewersCount  sync.Map
-	LocalViewersCount   int
+	Banned              sync.Map
 	ClientAddress       string
 	ClientPk            ed25519.PublicKey
 	URL                 string
 	CallID              string
+	NoBilling           bool
 	FirstNotifyResponse NotifyResponse
 	LastNotifyResponse  NotifyResponse
-	opened              bool
-	created             bool
-	closed              bool
-	ended               bool
-	createdChan         chan struct{}
+	notifyMu            sync.Mutex
+	call                *call.State
+	journal             *billing.Journal
+	checkpointing       int32
+	chatMu              sync.Mutex
+	chatHistory         []*ChatMessage
+	recordingMu         sync.Mutex
+	recorder            *recorder.Recorder
+	recording           bool
+	qualities           sync.Map
+	speakers            sync.Map
+	speakersMu          sync.Mutex
+	activeSpeakers      []string
+	notifies            sync.WaitGroup
+	notifiesMu          sync.Mutex
+	stateEpoch          string
+	stateSeq            uint64
+	hostStates          *roomstate.Tracker
 }
 
 // RoomMessage typed json from participant
@@ -66,6 +92,9 @@ type NotifyData struct {
 	CallID   string `json:"callID"`
 	UID      string `json:"uid"`
 	Type     string `json:"type"`
+	// Host of the node a participant migrates to
+	Host      string `json:"host,omitempty"`
+	NoPublish bool   `json:"noPublish,omitempty"`
 }
 
 // NotifyRequest data
@@ -85,6 +114,30 @@ type NotifyResponse struct {
 type ParticipantsMessage struct {
 	Participants map[string]*Participant `json:"participants"`
 	ViewersCount int                     `json:"viewersCount"`
+	Banned       []string                `json:"banned,omitempty"`
+}
+
+// ModerationCommand from a host, executed on the node of the target participant
+type ModerationCommand struct {
+	Action string `json:"action"`
+	UID    string `json:"uid"`
+	Kind   string `json:"kind,omitempty"`
+	Muted  bool   `json:"muted,omitempty"`
+}
+
+// ChatMessage sent by a participant to the room
+type ChatMessage struct {
+	ID        string `json:"id"`
+	UID       string `json:"uid"`
+	Name      string `json:"name"`
+	Text      string `json:"text"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// RecordingEvent announced when a recording starts or stops
+type RecordingEvent struct {
+	ID        string `json:"id"`
+	Recording bool   `json:"recording"`
 }
 
 // ParticipantsCount all
@@ -96,12 +149,16 @@ type ParticipantsCount struct {
 // OnJoin participant
 func (r *Room) OnJoin(participant *Participant) {
 	if participant.NoPublish == false {
-		r.Broadcast(participant, "onJoin", nil)
+		r.BroadcastState(participant, "onJoin", nil)
 	} else {
-		r.LocalViewersCount++
+		r.call.AddViewer()
+		r.publishDelta("", nil, nil)
 	}
 	r.BroadcastLocal("participantsCount", r.GetAllCountJson())
-	go r.NotifyAndTx(participant, "join")
+	r.record(func(journal *billing.Journal) error {
+		return journal.Join(participant.UID, participant.NoPublish, participant.AddedAt)
+	})
+	r.notify(participant, "join")
 }
 
 // OnJoinRemote participant
@@ -115,16 +172,16 @@ func (r *Room) OnJoinRemote(participant *Participant) {
 
 // OnLeave participant
 func (r *Room) OnLeave(participant *Participant) {
-	if participant.NoPublish == false {
-		r.Broadcast(participant, "onLeave", nil)
-		r.EndedParticipants.Store(participant, true)
+	noPublish, since := participant.role()
+	if noPublish == false {
+		r.BroadcastState(participant, "onLeave", nil)
 	} else {
-		if r.LocalViewersCount > 0 {
-			r.LocalViewersCount--
-		}
+		r.removeViewer(participant)
+		r.publishDelta("", nil, nil)
 	}
 	r.BroadcastLocal("participantsCount", r.GetAllCountJson())
-	go r.NotifyAndTx(participant, "leave")
+	r.endRole(participant.UID, noPublish, since, participant.RemovedAt)
+	r.notify(participant, "leave")
 }
 
 // OnLeaveRemote participant
@@ -139,7 +196,38 @@ func (r *Room) OnLeaveRemote(participant *Participant) {
 // OnStream participant
 func (r *Room) OnStream(participant *Participant) {
 	if participant.NoPublish == false {
-		r.Broadcast(participant, "onStream", nil)
+		r.BroadcastState(participant, "onStream", nil)
+	}
+}
+
+// OnTrackPublished by a local participant
+func (r *Room) OnTrackPublished(participant *Participant, receiver sfu.Receiver) {
+	streamID := participant.GetStreamID()
+	publication := participant.addTrack(receiver.StreamID(), receiver.TrackID(), receiver.Kind().String())
+
+	if participant.NoPublish == false {
+		payload, _ := json.Marshal(&TrackEvent{TrackID: receiver.TrackID(), Publication: publication})
+		r.BroadcastState(participant, "onTrackPublished", payload)
+	}
+	if current := participant.GetStreamID(); current != streamID && current != "" {
+		r.OnStream(participant)
+	}
+}
+
+// OnTrackUnpublished by a local participant
+func (r *Room) OnTrackUnpublished(participant *Participant, receiver sfu.Receiver) {
+	publication := participant.removeTrack(receiver.StreamID(), receiver.TrackID(), receiver.Kind().String())
+	if publication == nil {
+		return
+	}
+	// the tracks of a participant who left close after its leave was announced
+	if ival, ok := r.OnlineParticipants.Load(participant.UID); !ok || ival != participant {
+		return
+	}
+
+	if participant.NoPublish == false {
+		payload, _ := json.Marshal(&TrackEvent{TrackID: receiver.TrackID(), Publication: publication})
+		r.BroadcastState(participant, "onTrackUnpublished", payload)
 	}
 }
 
@@ -160,7 +248,7 @@ func (r *Room) OnRemoteEnd(participant *Participant) {
 
 // EndRoom participant
 func (r *Room) EndRoom() {
-	r.ended = true
+	r.call.End()
 	r.OnlineParticipants.Range(func(_, ival interface{}) bool {
 		participant, _ := ival.(*Participant)
 		if participant.Host == r.Node.ID().Pretty() {
@@ -170,6 +258,220 @@ func (r *Room) EndRoom() {
 	})
 }
 
+// Moderate participant by host command on every node. Bans also apply to
+// participants who did not join yet and viewers are only known by their own
+// node, so only these commands may target a participant unknown here
+func (r *Room) Moderate(command *ModerationCommand) error {
+	if command.Action != "ban" && command.Action != "promote" && r.getParticipant(command.UID) == nil {
+		return rpcerror.ErrParticipantNotFound
+	}
+	r.Publish("moderate", command)
+	r.OnModerate(command)
+	return nil
+}
+
+// OnModerate applies host command, the node of the target participant executes it
+func (r *Room) OnModerate(command *ModerationCommand) {
+	participant := r.getParticipant(command.UID)
+
+	switch command.Action {
+	case "ban":
+		r.Banned.Store(command.UID, true)
+	case "forceMute":
+		if participant != nil {
+			participant.SetForceMuted(command.Kind, command.Muted)
+			r.muteDownTracks(participant)
+		}
+	case "demote":
+		// demoted participants stop being forwarded everywhere
+		if participant != nil {
+			participant.SetForceMuted("audio", true)
+			participant.SetForceMuted("video", true)
+			r.muteDownTracks(participant)
+		}
+	case "promote":
+		// promoting a publisher keeps the mutes forced by the hosts
+		if participant != nil && participant.IsViewer() {
+			participant.SetForceMuted("audio", false)
+			participant.SetForceMuted("video", false)
+			r.muteDownTracks(participant)
+		}
+	}
+
+	if participant == nil || participant.Host != r.Node.ID().Pretty() {
+		return
+	}
+
+	log.Printf("moderate: %v %v", command.Action, command.UID)
+
+	switch command.Action {
+	case "kick", "ban":
+		if err := participant.Notify("kicked", command); err != nil {
+			log.Error().Err(err).Msg("kicked")
+		}
+		participant.Close()
+		participant.CloseConn()
+	case "forceMute":
+		if err := participant.Notify("forceMute", command); err != nil {
+			log.Error().Err(err).Msg("forceMute")
+		}
+		if command.Muted {
+			payload, _ := json.Marshal(&MuteEvent{Kind: command.Kind, Muted: true})
+			r.BroadcastState(participant, "muteEvent", payload)
+		}
+	case "promote":
+		r.OnPromote(participant)
+	case "demote":
+		r.OnDemote(participant)
+	}
+}
+
+// OnPromote viewer to publisher
+func (r *Room) OnPromote(participant *Participant) {
+	now := time.Now()
+	since, ok := participant.changeRole(false, now)
+	if !ok {
+		return
+	}
+	r.endRole(participant.UID, true, since, now)
+	r.record(func(journal *billing.Journal) error {
+		return journal.Join(participant.UID, false, now)
+	})
+
+	r.removeViewer(participant)
+
+	// viewers joined without a publisher transport
+	if participant.Peer.Publisher() == nil {
+		if err := participant.Peer.AddPublisher(); err != nil {
+			log.Error().Err(err).Msg("promoted")
+		}
+		participant.bindPublisher(r)
+	}
+
+	// the client sends an offer on its publisher transport to start publishing
+	if err := participant.Notify("promoted", nil); err != nil {
+		log.Error().Err(err).Msg("promoted")
+	}
+	r.BroadcastState(participant, "onJoin", nil)
+	r.BroadcastLocal("participantsCount", r.GetAllCountJson())
+}
+
+// OnDemote publisher to viewer
+func (r *Room) OnDemote(participant *Participant) {
+	now := time.Now()
+	since, ok := participant.changeRole(true, now)
+	if !ok {
+		return
+	}
+	r.endRole(participant.UID, false, since, now)
+	r.record(func(journal *billing.Journal) error {
+		return journal.Join(participant.UID, true, now)
+	})
+
+	r.call.AddViewer()
+
+	if err := participant.Notify("demoted", nil); err != nil {
+		log.Error().Err(err).Msg("demoted")
+	}
+	r.BroadcastState(participant, "onLeave", nil)
+	r.BroadcastLocal("participantsCount", r.GetAllCountJson())
+}
+
+// PublishInterval of a local participant, ended by its leave or demotion
+type PublishInterval struct {
+	UID       string
+	AddedAt   time.Time
+	RemovedAt time.Time
+}
+
+// endRole of a local participant who had it since, by its leave, promotion
+// or demotion at. Publishers are billed for the interval
+func (r *Room) endRole(UID string, noPublish bool, since time.Time, at time.Time) {
+	if !noPublish {
+		r.EndedParticipants.Store(&PublishInterval{UID: UID, AddedAt: since, RemovedAt: at}, true)
+	}
+	r.record(func(journal *billing.Journal) error {
+		return journal.Leave(UID, noPublish, at)
+	})
+}
+
+// ResolveStreamIDs of participants given by UID, local and remote
+func (r *Room) ResolveStreamIDs(streamIDs []string, UIDs []string) ([]string, error) {
+	resolved := append([]string{}, streamIDs...)
+	for _, UID := range UIDs {
+		participant := r.getParticipant(UID)
+		if participant == nil {
+			return nil, rpcerror.ErrParticipantNotFound.Wrap(fmt.Errorf("participant not found: %v", UID))
+		}
+		participantStreamIDs := participant.StreamIDs()
+		if len(participantStreamIDs) == 0 {
+			return nil, rpcerror.ErrBadRequest.Wrap(fmt.Errorf("participant not publishing: %v", UID))
+		}
+		resolved = append(resolved, participantStreamIDs...)
+	}
+	return resolved, nil
+}
+
+// IsBanned participant
+func (r *Room) IsBanned(UID string) bool {
+	_, ok := r.Banned.Load(UID)
+	return ok
+}
+
+// muteDownTracks stops forwarding force muted tracks of participant to local subscribers
+func (r *Room) muteDownTracks(participant *Participant) {
+	streamIDs := participant.StreamIDs()
+	if len(streamIDs) == 0 {
+		return
+	}
+	audio := participant.IsForceMuted("audio")
+	video := participant.IsForceMuted("video")
+
+	for _, peer := range r.Session.Peers() {
+		if peer.Subscriber() == nil {
+			continue
+		}
+		for _, streamID := range streamIDs {
+			for _, dt := range peer.Subscriber().GetDownTracks(streamID) {
+				switch dt.Kind() {
+				case webrtc.RTPCodecTypeAudio:
+					dt.Mute(audio)
+				case webrtc.RTPCodecTypeVideo:
+					dt.Mute(video)
+				}
+			}
+		}
+	}
+}
+
+// applyForceMutes covers down tracks created after a force mute
+func (r *Room) applyForceMutes() {
+	r.OnlineParticipants.Range(func(_, ival interface{}) bool {
+		participant, _ := ival.(*Participant)
+		if participant.IsForceMuted("audio") || participant.IsForceMuted("video") {
+			r.muteDownTracks(participant)
+		}
+		return true
+	})
+}
+
+func (r *Room) getParticipant(UID string) *Participant {
+	if ival, ok := r.OnlineParticipants.Load(UID); ok {
+		participant, _ := ival.(*Participant)
+		return participant
+	}
+	return nil
+}
+
+func (r *Room) getBanned() []string {
+	var banned []string
+	r.Banned.Range(func(ikey, _ interface{}) bool {
+		banned = append(banned, ikey.(string))
+		return true
+	})
+	return banned
+}
+
 // Broadcast all room participants
 func (r *Room) Broadcast(participant *Participant, method string, params json.RawMessage) {
 	roomMessage := &RoomMessage{Participant: participant, Payload: params}
@@ -182,7 +484,7 @@ func (r *Room) BroadcastLocal(method string, params interface{}) {
 	r.OnlineParticipants.Range(func(_, ival interface{}) bool {
 		participant, _ := ival.(*Participant)
 		if participant.Host == r.Node.ID().Pretty() {
-			if err := participant.conn.Notify(participant.ctx, method, params); err != nil {
+			if err := participant.Notify(method, params); err != nil {
 				log.Error().Err(err).Msg(method)
 			}
 		}
@@ -190,15 +492,145 @@ func (r *Room) BroadcastLocal(method string, params interface{}) {
 	})
 }
 
+// Chat message to all room participants
+func (r *Room) Chat(message *ChatMessage) {
+	r.addChatMessage(message)
+	r.BroadcastLocal("chat", message)
+	r.Publish("chat", message)
+}
+
+// GetChatHistory oldest first
+func (r *Room) GetChatHistory() []*ChatMessage {
+	r.chatMu.Lock()
+	defer r.chatMu.Unlock()
+
+	history := make([]*ChatMessage, len(r.chatHistory))
+	copy(history, r.chatHistory)
+	return history
+}
+
+func (r *Room) addChatMessage(message *ChatMessage) {
+	r.chatMu.Lock()
+	defer r.chatMu.Unlock()
+
+	r.chatHistory = append(r.chatHistory, message)
+	if len(r.chatHistory) > ChatHistorySize {
+		r.chatHistory = r.chatHistory[len(r.chatHistory)-ChatHistorySize:]
+	}
+}
+
+// StartRecording every stream of the room on this node, remote streams
+// reach it over relay peers
+func (r *Room) StartRecording(participant *Participant) (*RecordingEvent, error) {
+	r.recordingMu.Lock()
+	if r.recording {
+		r.recordingMu.Unlock()
+		return nil, rpcerror.ErrAlreadyRecording
+	}
+	rec, err := recorder.New(conf.Recording.Dir, r.SID)
+	if err != nil {
+		r.recordingMu.Unlock()
+		return nil, err
+	}
+	r.recorder = rec
+	r.recording = true
+	r.recordingMu.Unlock()
+
+	rec.Sync(r.getRouters())
+
+	event := &RecordingEvent{ID: rec.ID(), Recording: true}
+	payload, _ := json.Marshal(event)
+	r.Broadcast(participant, "recordingStarted", payload)
+	return event, nil
+}
+
+// StopRecording of the room and write its manifest. A recording started on
+// another node is stopped by that node, the manifest is nil then
+func (r *Room) StopRecording(participant *Participant) (*recorder.Manifest, error) {
+	r.recordingMu.Lock()
+	remote := r.recorder == nil && r.recording
+	r.recordingMu.Unlock()
+
+	if remote {
+		r.Publish("stopRecording", &RoomMessage{Participant: participant})
+		return nil, nil
+	}
+	return r.stopRecorder(participant)
+}
+
+// stopRecorder of this node and announce the stop
+func (r *Room) stopRecorder(participant *Participant) (*recorder.Manifest, error) {
+	r.recordingMu.Lock()
+	rec := r.recorder
+	if rec == nil {
+		r.recordingMu.Unlock()
+		return nil, rpcerror.ErrNotRecording
+	}
+	r.recorder = nil
+	r.recording = false
+	r.recordingMu.Unlock()
+
+	manifest, err := rec.Stop()
+	if err != nil {
+		return nil, err
+	}
+
+	payload, _ := json.Marshal(&RecordingEvent{ID: rec.ID(), Recording: false})
+	r.Broadcast(participant, "recordingStopped", payload)
+	return manifest, nil
+}
+
+// IsRecording on any node
+func (r *Room) IsRecording() bool {
+	r.recordingMu.Lock()
+	defer r.recordingMu.Unlock()
+	return r.recording
+}
+
+// syncRecording records streams published since the recording started
+func (r *Room) syncRecording() {
+	r.recordingMu.Lock()
+	rec := r.recorder
+	r.recordingMu.Unlock()
+
+	if rec != nil {
+		rec.Sync(r.getRouters())
+	}
+}
+
+// getRouters of local publishers and relay peers by UID
+func (r *Room) getRouters() map[string]sfu.Router {
+	routers := make(map[string]sfu.Router)
+	for _, peer := range r.Session.Peers() {
+		if peer.Publisher() != nil {
+			routers[peer.ID()] = peer.Publisher().GetRouter()
+		}
+	}
+	for _, relayPeer := range r.Session.RelayPeers() {
+		routers[relayPeer.ID()] = relayPeer.GetRouter()
+	}
+	return routers
+}
+
 // OnRemoteMessage from p2p
 func (r *Room) OnRemoteMessage(senderID string, pubMessage *node.PubMessage) {
 
 	if senderID == r.Node.ID().Pretty() {
 		return
 	}
+	stats.PubSubMessages.WithLabelValues(pubMessage.Method, "in").Inc()
 
 	switch pubMessage.Method {
+	case "stateDelta":
+		r.OnRemoteStateDelta(senderID, pubMessage.Payload)
+	case "stateSnapshot":
+		r.OnRemoteStateSnapshot(senderID, pubMessage.Payload)
+	case "stateRequest":
+		r.OnRemoteStateRequest(senderID, pubMessage.Payload)
+	case "stateDigest":
+		r.OnRemoteStateDigest(senderID, pubMessage.Payload)
 	case "internal":
+		// full state of nodes before the versioned state
 		var participantsMessage ParticipantsMessage
 		err := json.Unmarshal(pubMessage.Payload, &participantsMessage)
 		if err != nil {
@@ -207,6 +639,9 @@ func (r *Room) OnRemoteMessage(senderID string, pubMessage *node.PubMessage) {
 		}
 		r.OnRemoteParticipants(senderID, participantsMessage.Participants)
 		r.OnRemoteViewers(senderID, participantsMessage.ViewersCount)
+		for _, UID := range participantsMessage.Banned {
+			r.Banned.Store(UID, true)
+		}
 	case "relayOffer":
 		var relayOffer RelayMessage
 		err := json.Unmarshal(pubMessage.Payload, &relayOffer)
@@ -243,6 +678,43 @@ func (r *Room) OnRemoteMessage(senderID string, pubMessage *node.PubMessage) {
 			messages, _ := ival.(chan []byte)
 			messages <- relayAnswer.Data
 		}
+	case "moderate":
+		var command ModerationCommand
+		err := json.Unmarshal(pubMessage.Payload, &command)
+		if err != nil {
+			log.Error().Err(err).Msg("moderate")
+			return
+		}
+		r.OnModerate(&command)
+	case "chat":
+		var message ChatMessage
+		err := json.Unmarshal(pubMessage.Payload, &message)
+		if err != nil {
+			log.Error().Err(err).Msg("chat")
+			return
+		}
+		r.addChatMessage(&message)
+		r.BroadcastLocal("chat", &message)
+	case "recordingStarted", "recordingStopped":
+		r.recordingMu.Lock()
+		r.recording = pubMessage.Method == "recordingStarted"
+		r.recordingMu.Unlock()
+		r.BroadcastLocal(pubMessage.Method, pubMessage.Payload)
+	case "stopRecording":
+		// only the node holding the recorder stops it, the host was checked
+		// by the node of its participant
+		var roomMessage RoomMessage
+		if err := json.Unmarshal(pubMessage.Payload, &roomMessage); err != nil {
+			log.Error().Err(err).Msg("stopRecording")
+			return
+		}
+		if _, err := r.stopRecorder(roomMessage.Participant); err != nil && err != rpcerror.ErrNotRecording {
+			log.Error().Err(err).Msg("stopRecording")
+		}
+	case "connectionQuality":
+		r.OnRemoteConnectionQuality(pubMessage.Payload)
+	case "activeSpeakers":
+		r.OnRemoteActiveSpeakers(senderID, pubMessage.Payload)
 	case "end":
 		log.Printf("end: %v", string(pubMessage.Payload))
 
@@ -259,7 +731,7 @@ func (r *Room) OnRemoteMessage(senderID string, pubMessage *node.PubMessage) {
 	}
 }
 
-// OnRemoteParticipants from p2p
+// OnRemoteParticipants from p2p, all participants of hostID
 func (r *Room) OnRemoteParticipants(hostID string, participants map[string]*Participant) {
 
 	r.Hosts.Store(hostID, true)
@@ -271,9 +743,9 @@ func (r *Room) OnRemoteParticipants(hostID string, participants map[string]*Part
 			r.OnJoinRemote(participant)
 		} else {
 			remoteParticipant := ival.(*Participant)
-			if remoteParticipant.StreamID != participant.StreamID {
-				remoteParticipant.StreamID = participant.StreamID
-			}
+			remoteParticipant.SetPublications(participant.Publications, participant.StreamID)
+			remoteParticipant.SetForceMuted("audio", participant.ForceAudioMuted)
+			remoteParticipant.SetForceMuted("video", participant.ForceVideoMuted)
 		}
 	}
 
@@ -308,20 +780,36 @@ func (r *Room) OnRemoteViewers(hostID string, viewersCount int) {
 // Close room
 func (r *Room) Close() {
 
-	if r.closed {
+	created, ok := r.call.Close()
+	if !ok {
 		return
 	}
-	r.closed = true
-	if r.created {
-		<-r.createdChan
+
+	r.recordingMu.Lock()
+	rec := r.recorder
+	r.recorder = nil
+	r.recordingMu.Unlock()
+	if rec != nil {
+		if _, err := rec.Stop(); err != nil {
+			log.Error().Err(err).Msg("recording")
+		}
+	}
+
+	if created {
+		// the last leave carries the signed spent minutes
+		r.notifiesMu.Lock()
+		r.notifies.Wait()
+		r.notifiesMu.Unlock()
 		duration := r.getEndedDuration()
 		log.Printf("duration calc: %v", duration)
-		log.Printf("duration last: %v", r.LastNotifyResponse.Duration)
+		log.Printf("duration last: %v", r.lastNotifyResponse().Duration)
 
-		log.Printf("end call: %v", r.LastNotifyResponse)
-
-		err := ton.EndCall(r.ClientAddress, r.LastNotifyResponse.Signature, r.LastNotifyResponse.Message)
-		log.Printf("err: %v", err)
+		r.endCall()
+	} else {
+		// calls never created have nothing to settle
+		r.record(func(journal *billing.Journal) error {
+			return journal.Remove()
+		})
 	}
 }
 
@@ -364,24 +852,23 @@ func (r *Room) GetLocalParticipants() map[string]*Participant {
 	return participants
 }
 
-// GetLocalViewersCount all local viewers
+// GetLocalViewersCount all local viewers, counted by the call state on
+// join, leave, promote and demote
 func (r *Room) GetLocalViewersCount() int {
-	count := 0
-	r.OnlineParticipants.Range(func(_, ival interface{}) bool {
-		participant, _ := ival.(*Participant)
-		if participant.Host == r.Node.ID().Pretty() {
-			if participant.NoPublish == true {
-				count++
-			}
-		}
-		return true
-	})
-	return count
+	return r.call.Viewers()
+}
+
+// removeViewer left or promoted, a viewer which was not counted is a bug
+// of the join and leave ordering
+func (r *Room) removeViewer(participant *Participant) {
+	if _, ok := r.call.RemoveViewer(); !ok {
+		log.Error().Str("sid", r.SID).Str("uid", participant.UID).Msg("viewer removed without join")
+	}
 }
 
 // GetAllCountJson json
 func (r *Room) GetAllCountJson() *RoomMessage {
-	viewersCount := r.LocalViewersCount
+	viewersCount := r.GetLocalViewersCount()
 	r.RemoteViewersCount.Range(func(_, ival interface{}) bool {
 		viewersCount += ival.(int)
 		return true
@@ -437,13 +924,11 @@ func (r *Room) RelayAll() {
 		}
 
 		for _, participant := range participants {
-			if participant.StreamID != "" {
-				_, ok := participant.relayed[host]
-				if ok == false {
+			if len(participant.GetPublications()) > 0 {
+				if participant.markRelayed(host) {
 					log.Printf("start relay: %v", participant.UID)
 					data, derr := participant.Peer.Publisher().Relay(signalFunc)
 					log.Printf("relay: %v %v", data, derr)
-					participant.relayed[host] = true
 				}
 			}
 		}
@@ -462,30 +947,46 @@ func (r *Room) Publish(method string, data interface{}) {
 	err = r.Node.SendMessage(context.Background(), r.SID, json)
 	if err != nil {
 		log.Error().Err(err).Msg("Publish")
+		return
 	}
+	stats.PubSubMessages.WithLabelValues(method, "out").Inc()
 }
 
+// IsClosed or ended by the host
 func (r *Room) IsClosed() bool {
-	if r.closed {
-		return r.closed
+	return r.call.Closed()
+}
+
+// start a created room on this node, join its topic and observe it
+func (r *Room) start() {
+	r.openJournal()
+	r.Session.OnAudioLevels(r.OnAudioLevels)
+	err := r.Node.JoinRoom(r.SID, r.Node.ID().Pretty(), r.OnRemoteMessage)
+	if err != nil {
+		log.Error().Err(err).Msg("JoinRoom")
 	}
-	return r.ended
+	// the other nodes answer with their snapshots, digests catch up otherwise
+	r.requestState("")
+
+	go r.observer()
 }
 
 func (r *Room) observer() {
 	counter := 0
+	ticks := 0
 
 loop:
 	for {
 		time.Sleep(time.Duration(1) * time.Second)
+		ticks++
 
-		participantsMessage := &ParticipantsMessage{
-			Participants: r.GetLocalParticipants(),
-			ViewersCount: r.GetLocalViewersCount(),
+		if ticks%roomstate.DigestInterval == 0 {
+			r.publishDigest()
+		} else if ticks < roomstate.DigestInterval && !r.hostStates.Known() {
+			// the topic mesh may not have been formed for the first request
+			r.requestState("")
 		}
 
-		r.Publish("internal", participantsMessage)
-
 		participants := r.GetParticipants()
 
 		if len(participants) == 0 {
@@ -500,34 +1001,91 @@ loop:
 			break loop
 		}
 		r.RelayAll()
-		if r.created == false {
+		r.applyForceMutes()
+		r.syncRecording()
+		if ticks%ConnectionQualityInterval == 0 {
+			r.updateConnectionQuality()
+		}
+		if !r.NoBilling && !r.call.Created() {
 			go r.createCall()
 		}
+		if ticks%CheckpointInterval == 0 && !r.NoBilling && r.call.CreateSent() {
+			go r.checkpoint()
+		}
+		if ticks%JournalTickInterval == 0 {
+			r.record(func(journal *billing.Journal) error {
+				return journal.Tick()
+			})
+		}
 	}
 }
 
+// createCallTx and endCallTx send the transactions of a call and isCallOpen
+// looks it up on chain, replaced by tests
+var (
+	createCallTx = ton.CreateCall
+	endCallTx    = ton.EndCall
+	isCallOpen   = ton.IsCallOpen
+)
+
+// createCall once the local participants spent a few minutes, the observer
+// tries every tick and only the first claim sends the transaction
 func (r *Room) createCall() {
 	duration := r.getLocalDuration()
-	if duration > 3 {
-		r.created = true
+	if duration <= 3 || !r.call.BeginCreate() {
+		return
+	}
 
-		log.Printf("create call: %v", r.FirstNotifyResponse)
+	r.notifyMu.Lock()
+	first := r.FirstNotifyResponse
+	r.notifyMu.Unlock()
 
-		err := ton.CreateCall(r.ClientAddress, r.FirstNotifyResponse.Signature, r.FirstNotifyResponse.Message)
-		close(r.createdChan)
-		if err != nil {
-			log.Printf("err: %v", err)
-			r.EndRoom()
+	log.Printf("create call: %v", first)
+
+	err := createCallTx(r.ClientAddress, first.Signature, first.Message)
+	stats.TonTransaction("create_call", err)
+	r.record(func(journal *billing.Journal) error {
+		return journal.Created(err)
+	})
+	r.call.EndCreate()
+	if err != nil {
+		log.Printf("err: %v", err)
+		if errors.Is(err, ton.ErrRejected) || errors.Is(err, ton.ErrExpired) {
+			r.alertHosts(err)
 		}
+		r.EndRoom()
+	}
+}
+
+// CallAlert tells the hosts why their call could not be billed
+type CallAlert struct {
+	CallID string `json:"callID"`
+	Reason string `json:"reason"`
+}
+
+// alertHosts of this node that the create call transaction failed for good
+func (r *Room) alertHosts(err error) {
+	reason := "expired"
+	if errors.Is(err, ton.ErrRejected) {
+		reason = "rejected"
 	}
+	r.OnlineParticipants.Range(func(_, ival interface{}) bool {
+		participant := ival.(*Participant)
+		if participant.IsHost && participant.Host == r.Node.ID().Pretty() {
+			if err := participant.Notify("callFailed", CallAlert{CallID: r.CallID, Reason: reason}); err != nil {
+				log.Error().Err(err).Str("uid", participant.UID).Msg("callFailed")
+			}
+		}
+		return true
+	})
 }
 
 func (r *Room) getEndedDuration() int {
 	duration := 0.0
 
 	r.EndedParticipants.Range(func(k, _ interface{}) bool {
-		participant := k.(*Participant)
-		difference := participant.RemovedAt.Sub(participant.AddedAt)
+		interval := k.(*PublishInterval)
+		difference := interval.RemovedAt.Sub(interval.AddedAt)
 		duration += difference.Seconds()
 		return true
 	})
@@ -552,29 +1110,34 @@ func (r *Room) getLocalDuration() int {
 	return minutes
 }
 
-// NotifyAndTx participant
-func (r *Room) NotifyAndTx(participant *Participant, action string) {
-	duration := r.getEndedDuration()
-	log.Printf("duration: %v", duration)
+// notify the client backend in the background, Close waits for pending
+// notifies. Participants leaving meanwhile wait for it before adding theirs
+func (r *Room) notify(participant *Participant, action string) {
+	r.notifiesMu.Lock()
+	r.notifies.Add(1)
+	r.notifiesMu.Unlock()
+	go func() {
+		defer r.notifies.Done()
+		r.NotifyAndTx(participant, action)
+	}()
+}
 
-	notifyData := NotifyData{
-		Duration: duration,
-		SID:      r.SID,
-		CallID:   r.CallID,
-		UID:      participant.UID,
-		Type:     action,
-	}
+// callback posts notifyData signed by the node to the client backend of the
+// room and decodes its response
+func (r *Room) callback(notifyData NotifyData, response interface{}) error {
+	return postNotify(r.URL, notifyData, response)
+}
 
+// postNotify of notifyData signed by the node to the client backend at URL
+func postNotify(URL string, notifyData NotifyData, response interface{}) error {
 	j, err := json.Marshal(notifyData)
 	if err != nil {
-		log.Printf("NotifyAndTx: %v", err)
-		return
+		return err
 	}
 
 	sign, err := ton.GetSignature(j)
 	if err != nil {
-		log.Printf("NotifyAndTx: %v", err)
-		return
+		return err
 	}
 
 	notifyRequest := NotifyRequest{
@@ -582,26 +1145,66 @@ func (r *Room) NotifyAndTx(participant *Participant, action string) {
 		Signature: sign,
 	}
 
-	var notifyResponse NotifyResponse
-
+	start := time.Now()
 	err = requests.
-		URL(r.URL).
+		URL(URL).
 		BodyJSON(&notifyRequest).
-		ToJSON(&notifyResponse).
+		ToJSON(response).
 		Fetch(context.Background())
-	if err != nil {
-		log.Printf("err: %v", err)
+	stats.ObserveNotify(notifyData.Type, start)
+	return err
+}
+
+// NotifyAndTx participant
+func (r *Room) NotifyAndTx(participant *Participant, action string) {
+	if r.NoBilling {
+		return
+	}
+
+	duration := r.getEndedDuration()
+	log.Printf("duration: %v", duration)
+
+	notifyData := NotifyData{
+		Duration: duration,
+		SID:      r.SID,
+		CallID:   r.CallID,
+		UID:      participant.UID,
+		Type:     action,
+	}
+
+	var notifyResponse NotifyResponse
+	if err := r.callback(notifyData, &notifyResponse); err != nil {
+		log.Printf("NotifyAndTx: %v", err)
 		return
 	}
+	// the client backend signs the spent minutes it was notified
+	if notifyResponse.Duration == 0 {
+		notifyResponse.Duration = duration
+	}
+	r.record(func(journal *billing.Journal) error {
+		return journal.Response(billing.Response{
+			Action:    action,
+			Message:   notifyResponse.Message,
+			Signature: notifyResponse.Signature,
+			Duration:  notifyResponse.Duration,
+		})
+	})
 
 	if action == "join" {
-		if r.opened {
-			return
+		r.notifyMu.Lock()
+		if r.call.Open() {
+			r.FirstNotifyResponse = notifyResponse
 		}
-		r.opened = true
-		r.FirstNotifyResponse = notifyResponse
+		r.notifyMu.Unlock()
 	}
 	if action == "leave" {
-		r.LastNotifyResponse = notifyResponse
+		r.keepNotifyResponse(notifyResponse)
 	}
 }
+
+// lastNotifyResponse with the most spent minutes signed by the client backend
+func (r *Room) lastNotifyResponse() NotifyResponse {
+	r.notifyMu.Lock()
+	defer r.notifyMu.Unlock()
+	return r.LastNotifyResponse
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/call"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/node"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/recorder"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/roomstate"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/rpcerror"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/sfu"
+)
+
+// testNode of a single node cluster, nothing is published
+type testNode struct {
+	node.Node
+}
+
+func (n *testNode) ID() peer.ID {
+	return peer.ID("node")
+}
+
+func (n *testNode) SendMessage(ctx context.Context, roomName string, msg []byte) error {
+	return nil
+}
+
+// newTestRoom opened by a host online for ten minutes, so the observer may
+// create its call. Joins and leaves are not notified to a client backend
+func newTestRoom(t *testing.T, SID string) *Room {
+	n := &testNode{}
+	room := &Room{
+		SID:        SID,
+		Node:       n,
+		NoBilling:  true,
+		call:       call.NewState(),
+		stateEpoch: "epoch",
+		hostStates: roomstate.NewTracker(),
+	}
+	host := newTestParticipant(room, "host", false, time.Now().Add(-10*time.Minute))
+	host.IsHost = true
+	room.OnlineParticipants.Store(host.UID, host)
+	room.call.Open()
+
+	// an end call message valid for a minute, the end is not signed again
+	message := make([]byte, 16)
+	binary.BigEndian.PutUint32(message[8:], uint32(time.Now().Add(time.Minute).Unix()))
+	room.LastNotifyResponse = NotifyResponse{Message: message}
+
+	Rooms.Store(SID, room)
+	t.Cleanup(func() {
+		Rooms.Delete(SID)
+	})
+	return room
+}
+
+// newTestParticipant of room on its node, joined at addedAt
+func newTestParticipant(room *Room, UID string, noPublish bool, addedAt time.Time) *Participant {
+	p := NewParticipant(sfu.NewPeer(nil), room.Node)
+	p.SID, p.UID, p.Host = room.SID, UID, room.Node.ID().Pretty()
+	p.NoPublish = noPublish
+	p.AddedAt, p.roleAt = addedAt, addedAt
+	return p
+}
+
+// TestRoomJoinLeaveClose races participants entering and leaving a room
+// against its close and the creation of its call by the observer, run it
+// with -race
+func TestRoomJoinLeaveClose(t *testing.T) {
+	var mu sync.Mutex
+	var transactions []string
+	transaction := func(name string) func(string, []byte, []byte) error {
+		return func(string, []byte, []byte) error {
+			mu.Lock()
+			transactions = append(transactions, name)
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			return nil
+		}
+	}
+	createCall, endCall := createCallTx, endCallTx
+	createCallTx, endCallTx = transaction("create"), transaction("end")
+	t.Cleanup(func() {
+		createCallTx, endCallTx = createCall, endCall
+	})
+
+	const participants = 20
+	for i := 0; i < 20; i++ {
+		room := newTestRoom(t, fmt.Sprintf("room%d", i))
+		transactions = nil
+
+		var wg sync.WaitGroup
+		done := make(chan struct{})
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				viewers := room.GetLocalViewersCount()
+				assert.True(t, viewers >= 0 && viewers <= participants/2, "viewers %v", viewers)
+			}
+		}()
+
+		for j := 0; j < participants; j++ {
+			p := newTestParticipant(room, fmt.Sprintf("uid%v", j), j%2 == 0, time.Now())
+
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				p.EnterRoom(&Token{SID: p.SID, UID: p.UID}, room, nil)
+			}()
+			go func() {
+				defer wg.Done()
+				p.Close()
+			}()
+		}
+		for j := 0; j < 2; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				room.createCall()
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			room.Close()
+		}()
+		wg.Wait()
+		close(done)
+
+		// a call created before the close is ended after it, never created after
+		room.createCall()
+		mu.Lock()
+		if len(transactions) > 0 {
+			assert.Equal(t, []string{"create", "end"}, transactions)
+		}
+		mu.Unlock()
+		assert.Equal(t, 0, room.GetLocalViewersCount(), "every viewer left")
+		assert.Len(t, room.GetParticipants(), 1, "only the host is left")
+	}
+}
+
+func TestPromoteDemoteBilling(t *testing.T) {
+	room := newTestRoom(t, "roles")
+	now := time.Now()
+	p := newTestParticipant(room, "speaker", false, now.Add(-10*time.Minute+time.Second))
+	p.EnterRoom(&Token{SID: p.SID, UID: p.UID}, room, nil)
+
+	room.OnDemote(p)
+	assert.Equal(t, 10, room.getEndedDuration(), "billed as a publisher until the demotion")
+	assert.Equal(t, 1, room.GetLocalViewersCount())
+
+	// a viewer for four minutes is not billed
+	p.roleAt = now.Add(-4*time.Minute + time.Second)
+	room.OnPromote(p)
+	assert.Equal(t, 10, room.getEndedDuration())
+	assert.Equal(t, 0, room.GetLocalViewersCount())
+
+	// billed again from the promotion
+	p.roleAt = now.Add(-3*time.Minute + time.Second)
+	assert.Equal(t, 10+3+10, room.getSpentDuration(), "the host is online for ten minutes")
+	p.Close()
+	assert.Equal(t, 13, room.getEndedDuration())
+}
+
+// sentNode of a cluster keeping the messages published by the room
+type sentNode struct {
+	testNode
+	sent []*node.PubMessage
+}
+
+func (n *sentNode) SendMessage(ctx context.Context, roomName string, msg []byte) error {
+	var pubMessage node.PubMessage
+	if err := json.Unmarshal(msg, &pubMessage); err != nil {
+		return err
+	}
+	n.sent = append(n.sent, &pubMessage)
+	return nil
+}
+
+func TestStopRemoteRecording(t *testing.T) {
+	recording := conf.Recording.Dir
+	conf.Recording.Dir = t.TempDir()
+	t.Cleanup(func() {
+		conf.Recording.Dir = recording
+	})
+
+	// the host stopping the recording is on a node without the recorder
+	room := newTestRoom(t, "stop")
+	n := &sentNode{}
+	room.Node = n
+	room.recording = true
+	host, _ := room.OnlineParticipants.Load("host")
+	manifest, err := room.StopRecording(host.(*Participant))
+	require.NoError(t, err)
+	assert.Nil(t, manifest)
+	require.Len(t, n.sent, 1)
+	assert.Equal(t, "stopRecording", n.sent[0].Method)
+	assert.True(t, room.IsRecording(), "stopped once the recording node announces it")
+
+	// the node holding the recorder stops it
+	rec, err := recorder.New(conf.Recording.Dir, room.SID)
+	require.NoError(t, err)
+	room.recorder = rec
+	room.OnRemoteMessage("other", n.sent[0])
+	assert.False(t, room.IsRecording())
+	assert.Nil(t, room.recorder)
+	assert.FileExists(t, filepath.Join(rec.Dir(), "manifest.json"))
+	require.Len(t, n.sent, 2)
+	assert.Equal(t, "recordingStopped", n.sent[1].Method)
+
+	// an unknown recording is not stopped again
+	_, err = room.StopRecording(host.(*Participant))
+	assert.Equal(t, rpcerror.ErrNotRecording, err)
+}
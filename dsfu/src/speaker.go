@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/sfu"
+	"github.com/rs/zerolog/log"
+	"sort"
+)
+
+// Speaker active over the last audio level interval
+type Speaker struct {
+	UID string `json:"uid"`
+	// Level average in -dBov, lower is louder
+	Level uint8 `json:"level"`
+	// Active packets above the threshold
+	Active int `json:"active"`
+}
+
+// OnAudioLevels of the session, the streams of the local participants are
+// shared with the other nodes when their speakers change
+func (r *Room) OnAudioLevels(levels []sfu.AudioLevel) {
+	nodeID := r.Node.ID().Pretty()
+
+	// audio streams of the unmuted local participants, relayed streams of
+	// remote participants are observed by their own nodes
+	speakers := make(map[string]string)
+	r.OnlineParticipants.Range(func(_, ival interface{}) bool {
+		participant, _ := ival.(*Participant)
+		if participant.Host != nodeID || participant.IsMuted("audio") || participant.IsForceMuted("audio") {
+			return true
+		}
+		for _, publication := range participant.GetPublications() {
+			if publication.Kind == "audio" {
+				speakers[publication.StreamID] = participant.UID
+			}
+		}
+		return true
+	})
+
+	local := make([]*Speaker, 0)
+	seen := make(map[string]bool)
+	for _, level := range levels {
+		// streams without voice packets are listed when no filter is configured
+		UID, ok := speakers[level.StreamID]
+		if !ok || seen[UID] || level.Active == 0 {
+			continue
+		}
+		seen[UID] = true
+		local = append(local, &Speaker{UID: UID, Level: level.Level, Active: level.Active})
+	}
+
+	if ival, ok := r.speakers.Load(nodeID); ok && sameSpeakers(ival.([]*Speaker), local) {
+		return
+	}
+	r.speakers.Store(nodeID, local)
+	r.Publish("activeSpeakers", local)
+	r.updateActiveSpeakers()
+}
+
+// OnRemoteActiveSpeakers of the participants of another node
+func (r *Room) OnRemoteActiveSpeakers(hostID string, payload json.RawMessage) {
+	var speakers []*Speaker
+	if err := json.Unmarshal(payload, &speakers); err != nil {
+		log.Error().Err(err).Msg("activeSpeakers")
+		return
+	}
+	r.speakers.Store(hostID, speakers)
+	r.updateActiveSpeakers()
+}
+
+// updateActiveSpeakers merges the speakers of all nodes and notifies the
+// local participants when the list changed. Every node orders the same way,
+// the most active and loudest first
+func (r *Room) updateActiveSpeakers() {
+	merged := make([]*Speaker, 0)
+	r.speakers.Range(func(_, ival interface{}) bool {
+		for _, speaker := range ival.([]*Speaker) {
+			if _, ok := r.OnlineParticipants.Load(speaker.UID); ok {
+				merged = append(merged, speaker)
+			}
+		}
+		return true
+	})
+	sort.Slice(merged, func(i, j int) bool {
+		si, sj := merged[i], merged[j]
+		switch {
+		case si.Active != sj.Active:
+			return si.Active > sj.Active
+		case si.Level != sj.Level:
+			return si.Level < sj.Level
+		default:
+			return si.UID < sj.UID
+		}
+	})
+
+	UIDs := make([]string, 0, len(merged))
+	for _, speaker := range merged {
+		UIDs = append(UIDs, speaker.UID)
+	}
+
+	r.speakersMu.Lock()
+	changed := !equalStrings(r.activeSpeakers, UIDs)
+	if changed {
+		r.activeSpeakers = UIDs
+	}
+	r.speakersMu.Unlock()
+
+	if changed {
+		r.BroadcastLocal("activeSpeakers", UIDs)
+	}
+}
+
+// GetActiveSpeakers UIDs of the room, the most active first
+func (r *Room) GetActiveSpeakers() []string {
+	r.speakersMu.Lock()
+	defer r.speakersMu.Unlock()
+	return append([]string{}, r.activeSpeakers...)
+}
+
+// sameSpeakers in the same order, levels are ignored
+func sameSpeakers(a, b []*Speaker) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].UID != b[i].UID {
+			return false
+		}
+	}
+	return true
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/roomstate"
+	"github.com/rs/zerolog/log"
+	"sync/atomic"
+)
+
+// StateDelta of a local participant published on join, leave, stream and mute
+// events. The other nodes update the participant and notify Method with the
+// Payload to their participants. Deltas without participant carry the
+// viewers count only
+type StateDelta struct {
+	roomstate.Version
+	Method       string          `json:"method,omitempty"`
+	Participant  *Participant    `json:"participant,omitempty"`
+	Payload      json.RawMessage `json:"payload,omitempty"`
+	ViewersCount int             `json:"viewersCount"`
+}
+
+// StateSnapshot of the local participants of a node
+type StateSnapshot struct {
+	roomstate.Version
+	Participants map[string]*Participant `json:"participants"`
+	ViewersCount int                     `json:"viewersCount"`
+	Banned       []string                `json:"banned,omitempty"`
+}
+
+// StateRequest for the snapshot of Host, every node answers if Host is empty.
+// The version of the requesting node lets the others know it
+type StateRequest struct {
+	roomstate.Version
+	Host string `json:"host,omitempty"`
+}
+
+// StateDigest published periodically for anti-entropy, nodes missing deltas
+// request a snapshot
+type StateDigest struct {
+	roomstate.Version
+}
+
+// BroadcastState notifies the local participants of an event of participant
+// and publishes it as a delta, the other nodes update the participant before
+// notifying theirs
+func (r *Room) BroadcastState(participant *Participant, method string, params json.RawMessage) {
+	r.BroadcastLocal(method, &RoomMessage{Participant: participant, Payload: params})
+	r.publishDelta(method, participant, params)
+}
+
+// publishDelta of the local state, participant is nil for viewers count changes
+func (r *Room) publishDelta(method string, participant *Participant, params json.RawMessage) {
+	r.Publish("stateDelta", &StateDelta{
+		Version:      roomstate.Version{Epoch: r.stateEpoch, Seq: atomic.AddUint64(&r.stateSeq, 1)},
+		Method:       method,
+		Participant:  participant,
+		Payload:      params,
+		ViewersCount: r.GetLocalViewersCount(),
+	})
+}
+
+// stateVersion of the local participants
+func (r *Room) stateVersion() roomstate.Version {
+	return roomstate.Version{Epoch: r.stateEpoch, Seq: atomic.LoadUint64(&r.stateSeq)}
+}
+
+// requestState of host, or of every node if host is empty
+func (r *Room) requestState(host string) {
+	r.Publish("stateRequest", &StateRequest{Version: r.stateVersion(), Host: host})
+}
+
+// publishSnapshot of the local participants, the version is taken first so
+// every change up to it is included
+func (r *Room) publishSnapshot() {
+	version := r.stateVersion()
+	r.Publish("stateSnapshot", &StateSnapshot{
+		Version:      version,
+		Participants: r.GetLocalParticipants(),
+		ViewersCount: r.GetLocalViewersCount(),
+		Banned:       r.getBanned(),
+	})
+}
+
+// publishDigest and check the remote nodes for missing deltas and timeouts
+func (r *Room) publishDigest() {
+	r.Publish("stateDigest", &StateDigest{Version: r.stateVersion()})
+
+	expired, behind := r.hostStates.Expire(func(UID string) bool {
+		_, ok := r.OnlineParticipants.Load(UID)
+		return ok
+	})
+	for _, hostID := range expired {
+		log.Warn().Str("sid", r.SID).Str("host", hostID).Msg("state timeout")
+		r.dropHost(hostID)
+	}
+	for _, hostID := range behind {
+		r.requestHostState(hostID)
+	}
+}
+
+// OnRemoteStateDelta of a participant of another node
+func (r *Room) OnRemoteStateDelta(hostID string, payload json.RawMessage) {
+	var delta StateDelta
+	if err := json.Unmarshal(payload, &delta); err != nil {
+		log.Error().Err(err).Msg("stateDelta")
+		return
+	}
+
+	r.Hosts.Store(hostID, true)
+	tracked := &roomstate.Delta{Version: delta.Version, Method: delta.Method}
+	if delta.Participant != nil {
+		tracked.UID = delta.Participant.UID
+	}
+	apply, viewers, resync := r.hostStates.Delta(hostID, tracked)
+	if resync {
+		r.requestHostState(hostID)
+	}
+	if viewers {
+		r.OnRemoteViewers(hostID, delta.ViewersCount)
+	}
+
+	participant := delta.Participant
+	if !apply || participant == nil || participant.Host != hostID {
+		return
+	}
+	roomMessage := &RoomMessage{Participant: participant, Payload: delta.Payload}
+
+	// viewers and demoted participants are not shared, their events are
+	if delta.Method == "onLeave" || participant.NoPublish {
+		if r.removeRemoteParticipant(hostID, participant.UID) {
+			r.OnLeaveRemote(participant)
+		}
+		if delta.Method != "onLeave" && delta.Method != "" {
+			r.BroadcastLocal(delta.Method, roomMessage)
+		}
+		return
+	}
+
+	if r.storeRemoteParticipant(participant) {
+		r.OnJoinRemote(participant)
+	}
+	if delta.Method != "onJoin" && delta.Method != "" {
+		r.BroadcastLocal(delta.Method, roomMessage)
+	}
+}
+
+// OnRemoteStateSnapshot of the participants of another node, participants
+// updated by newer deltas are kept
+func (r *Room) OnRemoteStateSnapshot(hostID string, payload json.RawMessage) {
+	var snapshot StateSnapshot
+	if err := json.Unmarshal(payload, &snapshot); err != nil {
+		log.Error().Err(err).Msg("stateSnapshot")
+		return
+	}
+
+	r.Hosts.Store(hostID, true)
+	UIDs := make([]string, 0, len(snapshot.Participants))
+	for UID := range snapshot.Participants {
+		UIDs = append(UIDs, UID)
+	}
+	var online []string
+	r.OnlineParticipants.Range(func(_, ival interface{}) bool {
+		participant, _ := ival.(*Participant)
+		if participant.Host == hostID {
+			online = append(online, participant.UID)
+		}
+		return true
+	})
+	apply, newer, viewers := r.hostStates.Snapshot(hostID, snapshot.Version, UIDs, online)
+	if !apply {
+		// an answer to another node, nothing missed
+		return
+	}
+
+	for UID, participant := range snapshot.Participants {
+		if newer[UID] || participant.Host != hostID {
+			continue
+		}
+		if r.storeRemoteParticipant(participant) {
+			r.OnJoinRemote(participant)
+		}
+	}
+	r.OnlineParticipants.Range(func(_, ival interface{}) bool {
+		participant, _ := ival.(*Participant)
+		if participant.Host == hostID && !newer[participant.UID] {
+			if _, ok := snapshot.Participants[participant.UID]; !ok {
+				r.OnlineParticipants.Delete(participant.UID)
+				r.OnLeaveRemote(participant)
+			}
+		}
+		return true
+	})
+
+	if viewers {
+		r.OnRemoteViewers(hostID, snapshot.ViewersCount)
+	}
+	for _, UID := range snapshot.Banned {
+		r.Banned.Store(UID, true)
+	}
+}
+
+// OnRemoteStateRequest answers with the local snapshot
+func (r *Room) OnRemoteStateRequest(hostID string, payload json.RawMessage) {
+	var request StateRequest
+	if err := json.Unmarshal(payload, &request); err != nil {
+		log.Error().Err(err).Msg("stateRequest")
+		return
+	}
+	r.checkHostVersion(hostID, request.Version)
+
+	if request.Host == "" || request.Host == r.Node.ID().Pretty() {
+		r.publishSnapshot()
+	}
+}
+
+// OnRemoteStateDigest requests the snapshot of a node this node is behind of
+func (r *Room) OnRemoteStateDigest(hostID string, payload json.RawMessage) {
+	var digest StateDigest
+	if err := json.Unmarshal(payload, &digest); err != nil {
+		log.Error().Err(err).Msg("stateDigest")
+		return
+	}
+	r.checkHostVersion(hostID, digest.Version)
+}
+
+// checkHostVersion of a remote node, its snapshot is requested when this node
+// does not know it yet or missed deltas
+func (r *Room) checkHostVersion(hostID string, version roomstate.Version) {
+	r.Hosts.Store(hostID, true)
+	if r.hostStates.Check(hostID, version) {
+		r.requestHostState(hostID)
+	}
+}
+
+// requestHostState unless it was requested within the gap timeout
+func (r *Room) requestHostState(hostID string) {
+	if r.hostStates.Request(hostID) {
+		r.requestState(hostID)
+	}
+}
+
+// storeRemoteParticipant or update the known one, returns true if it is new
+func (r *Room) storeRemoteParticipant(participant *Participant) bool {
+	ival, ok := r.OnlineParticipants.Load(participant.UID)
+	if !ok {
+		r.OnlineParticipants.Store(participant.UID, participant)
+		return true
+	}
+	remoteParticipant := ival.(*Participant)
+	switch remoteParticipant.Host {
+	case participant.Host:
+		remoteParticipant.update(participant)
+		return false
+	case r.Node.ID().Pretty():
+		// migrated to this node, the other node still has to see it leave
+		return false
+	default:
+		// migrated between other nodes, the leave of the first one is ignored
+		r.OnlineParticipants.Store(participant.UID, participant)
+		return true
+	}
+}
+
+// removeRemoteParticipant of hostID, returns true if it was known
+func (r *Room) removeRemoteParticipant(hostID string, UID string) bool {
+	ival, ok := r.OnlineParticipants.Load(UID)
+	if !ok || ival.(*Participant).Host != hostID {
+		return false
+	}
+	r.OnlineParticipants.Delete(UID)
+	return true
+}
+
+// dropHost which timed out with its participants and viewers
+func (r *Room) dropHost(hostID string) {
+	r.Hosts.Delete(hostID)
+	r.OnlineParticipants.Range(func(_, ival interface{}) bool {
+		participant, _ := ival.(*Participant)
+		if participant.Host == hostID {
+			r.OnlineParticipants.Delete(participant.UID)
+			r.OnLeaveRemote(participant)
+		}
+		return true
+	})
+	r.OnRemoteViewers(hostID, 0)
+	r.RemoteViewersCount.Delete(hostID)
+}
+
+// update a remote participant from the state of its node
+func (p *Participant) update(state *Participant) {
+	p.SetPublications(state.Publications, state.StreamID)
+	p.SetForceMuted("audio", state.ForceAudioMuted)
+	p.SetForceMuted("video", state.ForceVideoMuted)
+
+	p.mu.Lock()
+	p.AudioMuted = state.AudioMuted
+	p.VideoMuted = state.VideoMuted
+	p.HandRaised = state.HandRaised
+	p.mu.Unlock()
+}
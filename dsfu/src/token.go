@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/auth"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/rpcerror"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/ton"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// LoadTestClientKey verifies tokens without the TON lookup, rooms joined
+// with it are not billed. Only set by the loadtest config
+var LoadTestClientKey ed25519.PublicKey
+
+// UsedNonces global map of token nonces to their expiry, only in memory: the
+// tokens issued before the node started are rejected
+var UsedNonces = auth.NewNonceCache()
+
+// Token model
+type Token struct {
+	SID           string `json:"sid"`
+	UID           string `json:"uid"`
+	Name          string `json:"name"`
+	IsHost        bool   `json:"isHost"`
+	ClientAddress string `json:"clientAddress"`
+	URL           string `json:"url"`
+	CallID        string `json:"callID"`
+	NoPublish     bool   `json:"noPublish"`
+	auth.Validity
+	// Node contract address the token was issued for, a nonce is only
+	// remembered by the node which consumed it
+	Node string `json:"node"`
+}
+
+// VerifyToken decodes a base64 token and signature, verifies them with the
+// client key and checks the token was issued for this node and its nonce was
+// not used. The nonce is consumed, so concurrent joins with the token fail but
+// one, a failed join releases it with Release. The room is nil until its
+// first join
+func VerifyToken(tokenBase64 string, signatureBase64 string) (*Token, *Room, ed25519.PublicKey, error) {
+	tokenJson, err := base64.StdEncoding.DecodeString(tokenBase64)
+	if err != nil {
+		return nil, nil, nil, rpcerror.ErrTokenMalformed.Wrap(err)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(signatureBase64)
+	if err != nil {
+		return nil, nil, nil, rpcerror.ErrTokenMalformed.Wrap(err)
+	}
+
+	var token Token
+	err = json.Unmarshal(tokenJson, &token)
+	if err != nil {
+		return nil, nil, nil, rpcerror.ErrTokenMalformed.Wrap(err)
+	}
+	log.Printf("got token: %v", token)
+
+	var room *Room
+	if ival, ok := Rooms.Load(token.SID); ok {
+		room, _ = ival.(*Room)
+		if room.IsClosed() {
+			return nil, nil, nil, rpcerror.ErrRoomEnded
+		}
+		if room.IsBanned(token.UID) {
+			return nil, nil, nil, rpcerror.ErrBanned
+		}
+	}
+
+	var clientPk ed25519.PublicKey
+	if room != nil {
+		clientPk = room.ClientPk
+	} else if LoadTestClientKey != nil {
+		clientPk = LoadTestClientKey
+	} else {
+		clientPk, err = ton.GetClientPubKey(token.ClientAddress)
+		log.Printf("GetClientPubKey: %v, %v, %v", token.ClientAddress, clientPk, err)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	verified := ton.VerifyMessage(clientPk, tokenJson, signature)
+	if verified != true {
+		return nil, nil, nil, rpcerror.ErrTokenInvalid
+	}
+
+	log.Printf("verified: %v", verified)
+
+	now := time.Now()
+	if err := token.Validate(now); err != nil {
+		return nil, nil, nil, err
+	}
+	// load test tokens are verified without the chain
+	if LoadTestClientKey == nil {
+		own, err := ton.IsNodeAddress(token.Node)
+		if errors.Is(err, ton.ErrInvalidAddress) {
+			return nil, nil, nil, rpcerror.ErrTokenMalformed.Wrap(err)
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if !own {
+			return nil, nil, nil, rpcerror.ErrTokenInvalid.Wrap(fmt.Errorf("token for another node: %v", token.Node))
+		}
+	}
+	if err := UsedNonces.Use(&token.Validity, now); err != nil {
+		return nil, nil, nil, err
+	}
+	return &token, room, clientPk, nil
+}
+
+// Release the nonce of a verified token whose join failed, so it can be
+// used again until it expires
+func (t *Token) Release() {
+	UsedNonces.Release(t.Nonce)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/auth"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/rpcerror"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/ton"
+)
+
+// signToken of the load test client key, verified without the chain
+func signToken(t *testing.T, token *Token) (string, string) {
+	pk, sk, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key := LoadTestClientKey
+	LoadTestClientKey = pk
+	t.Cleanup(func() {
+		LoadTestClientKey = key
+	})
+
+	data, err := json.Marshal(token)
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(data), base64.StdEncoding.EncodeToString(ton.SignMessage(sk, data))
+}
+
+func TestVerifyTokenConsumesNonce(t *testing.T) {
+	now := time.Now()
+	token, signature := signToken(t, &Token{
+		SID:      "nonce",
+		UID:      "uid",
+		Validity: auth.Validity{NotBefore: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix(), Nonce: "verify"},
+	})
+
+	// concurrent joins with the token fail but one
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	verified := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, _, err := VerifyToken(token, signature)
+			if err == nil {
+				mu.Lock()
+				verified++
+				mu.Unlock()
+				return
+			}
+			assert.ErrorIs(t, rpcerror.Classify(err), rpcerror.ErrTokenUsed)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, verified)
+
+	// a failed join releases the nonce
+	UsedNonces.Release("verify")
+	verifiedToken, _, _, err := VerifyToken(token, signature)
+	require.NoError(t, err)
+	verifiedToken.Release()
+	_, _, _, err = VerifyToken(token, signature)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/node"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/sfu"
+	"github.com/dTelecom/hack-a-tonx/dsfu/src/pkg/stats"
+	"github.com/pion/webrtc/v3"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTP ingest and playback kinds
+const (
+	WHIP = "whip"
+	WHEP = "whep"
+)
+
+// maxSDPSize of offers and trickle fragments in bytes
+const maxSDPSize = 64 * 1024
+
+// gatheringTimeout for the answer candidates, WHIP and WHEP answers carry all of them
+const gatheringTimeout = 5 * time.Second
+
+// connectTimeout of a created session, sessions whose client never connects
+// leave the room instead of being counted and billed
+const connectTimeout = 30 * time.Second
+
+// HTTPSessions global map of WHIP and WHEP sessions by resource ID
+var HTTPSessions sync.Map
+
+// HTTPSession of a WHIP publisher or WHEP player
+type HTTPSession struct {
+	Participant   *Participant
+	Authorization string
+	Target        int
+}
+
+// Close session and leave the room
+func (s *HTTPSession) Close(ID string) {
+	HTTPSessions.Delete(ID)
+	s.Participant.Close()
+}
+
+// HTTPSessionHandler serves WHIP or WHEP, POST /{kind}/{sid} creates a
+// session, PATCH /{kind}/{sid}/{id} trickles candidates and DELETE ends it.
+// The Authorization header carries "Bearer <token>.<signature>" of a signed Token.
+// WHEP has no offers from the node, a player gets the streams published when
+// its session was created and is refused while nothing is published. Players
+// create a new session to get streams published later
+type HTTPSessionHandler struct {
+	Kind string
+	SFU  *sfu.SFU
+	Node node.Node
+}
+
+func (h *HTTPSessionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, PATCH, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match")
+	w.Header().Set("Access-Control-Expose-Headers", "Location, Link")
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/"+h.Kind), "/"), "/")
+	if parts[0] == "" || len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodOptions:
+		w.Header().Set("Accept-Post", "application/sdp")
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && len(parts) == 1 && IsDraining():
+		stats.JoinFailures.WithLabelValues("draining").Inc()
+		http.Error(w, "node draining", http.StatusServiceUnavailable)
+	case r.Method == http.MethodPost && len(parts) == 1:
+		h.create(w, r, parts[0])
+	case r.Method == http.MethodPatch && len(parts) == 2:
+		h.trickle(w, r, parts[1])
+	case r.Method == http.MethodDelete && len(parts) == 2:
+		h.delete(w, r, parts[1])
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *HTTPSessionHandler) create(w http.ResponseWriter, r *http.Request, SID string) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/sdp") {
+		http.Error(w, "unsupported media type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	authorization := r.Header.Get("Authorization")
+	credentials := strings.SplitN(strings.TrimPrefix(authorization, "Bearer "), ".", 2)
+	if !strings.HasPrefix(authorization, "Bearer ") || len(credentials) != 2 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	offer, err := io.ReadAll(io.LimitReader(r.Body, maxSDPSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the nonce of a failed session is released for a retry
+	token, room, clientPk, err := VerifyToken(credentials[0], credentials[1])
+	if err != nil {
+		stats.JoinFailures.WithLabelValues("token").Inc()
+		log.Error().Err(err).Msg(h.Kind)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if token.SID != SID {
+		stats.JoinFailures.WithLabelValues("token").Inc()
+		token.Release()
+		http.Error(w, "token for another room", http.StatusForbidden)
+		return
+	}
+	if h.Kind == WHIP && token.NoPublish {
+		stats.JoinFailures.WithLabelValues("token").Inc()
+		token.Release()
+		http.Error(w, "viewer token", http.StatusForbidden)
+		return
+	}
+
+	p := NewParticipant(sfu.NewPeer(h.SFU), h.Node)
+	p.SetToken(token)
+
+	// WHIP only publishes and WHEP only plays
+	joinConfig := sfu.JoinConfig{NoSubscribe: true}
+	target := 0
+	if h.Kind == WHEP {
+		p.NoPublish = true
+		joinConfig = sfu.JoinConfig{NoPublish: true}
+		target = 1
+	}
+
+	err = p.Peer.Join(p.SID, p.UID, joinConfig)
+	if err != nil {
+		stats.JoinFailures.WithLabelValues("peer").Inc()
+		p.Peer.Close()
+		token.Release()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var pc *webrtc.PeerConnection
+	if h.Kind == WHIP {
+		pc = p.Peer.Publisher().PeerConnection()
+	} else {
+		pc = p.Peer.Subscriber().PeerConnection()
+		// streams published later would need an offer the player can't get
+		if p.Peer.Subscriber().Freeze() == 0 {
+			stats.JoinFailures.WithLabelValues("nothing_published").Inc()
+			p.Peer.Close()
+			token.Release()
+			http.Error(w, "nothing published", http.StatusConflict)
+			return
+		}
+	}
+
+	_, err = p.Peer.Answer(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: string(offer)})
+	if err != nil {
+		stats.JoinFailures.WithLabelValues("answer").Inc()
+		p.Peer.Close()
+		token.Release()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case <-webrtc.GatheringCompletePromise(pc):
+	case <-time.After(gatheringTimeout):
+		log.Printf("%v: ice gathering timeout", h.Kind)
+	}
+
+	ID, err := newResumeSecret()
+	if err != nil {
+		p.Peer.Close()
+		stats.JoinFailures.WithLabelValues("internal").Inc()
+		token.Release()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	session := &HTTPSession{Participant: p, Authorization: authorization, Target: target}
+	HTTPSessions.Store(ID, session)
+
+	connectTimer := time.AfterFunc(connectTimeout, func() {
+		log.Printf("%v: session %v not connected after %v", h.Kind, token.UID, connectTimeout)
+		session.Close(ID)
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			connectTimer.Stop()
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			connectTimer.Stop()
+			session.Close(ID)
+		}
+	})
+
+	p.EnterRoom(token, room, clientPk)
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", fmt.Sprintf("/%s/%s/%s", h.Kind, SID, ID))
+	w.WriteHeader(http.StatusCreated)
+	if _, err := w.Write([]byte(pc.LocalDescription().SDP)); err != nil {
+		log.Error().Err(err).Msg(h.Kind)
+	}
+}
+
+func (h *HTTPSessionHandler) trickle(w http.ResponseWriter, r *http.Request, ID string) {
+	session := h.authorize(w, r, ID)
+	if session == nil {
+		return
+	}
+
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/trickle-ice-sdpfrag") {
+		http.Error(w, "unsupported media type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	fragment, err := io.ReadAll(io.LimitReader(r.Body, maxSDPSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var mid string
+	scanner := bufio.NewScanner(bytes.NewReader(fragment))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "a=mid:"):
+			mid = strings.TrimPrefix(line, "a=mid:")
+		case strings.HasPrefix(line, "a=candidate:"):
+			candidateMid := mid
+			candidate := webrtc.ICECandidateInit{
+				Candidate: strings.TrimPrefix(line, "a="),
+				SDPMid:    &candidateMid,
+			}
+			if err := session.Participant.Peer.Trickle(candidate, session.Target); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *HTTPSessionHandler) delete(w http.ResponseWriter, r *http.Request, ID string) {
+	session := h.authorize(w, r, ID)
+	if session == nil {
+		return
+	}
+
+	session.Close(ID)
+	w.WriteHeader(http.StatusOK)
+}
+
+// authorize requests on a session resource with the Authorization it was created with
+func (h *HTTPSessionHandler) authorize(w http.ResponseWriter, r *http.Request, ID string) *HTTPSession {
+	ival, ok := HTTPSessions.Load(ID)
+	if !ok {
+		http.NotFound(w, r)
+		return nil
+	}
+	session, _ := ival.(*HTTPSession)
+
+	authorization := r.Header.Get("Authorization")
+	if subtle.ConstantTimeCompare([]byte(authorization), []byte(session.Authorization)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+	return session
+}